@@ -19,6 +19,12 @@ const (
 	CurrentDeptKey  = "currentDept"
 	BellowDeptKey   = "bellowDept"
 	CustomerDeptKey = "customerDept"
+	PermissionsKey  = "permissions"
+	RoleScopesKey   = "roleScopes"
+	RequestIDKey    = "requestId"
+
+	// AllPermission 超级权限标识, 拥有该权限视为拥有全部权限
+	AllPermission = "*:*:*"
 
 	// Redis key 模板
 	TokenKey    = "token:%s:%s"    // clientId + userId
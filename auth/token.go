@@ -8,6 +8,7 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	oteltrace "go.opentelemetry.io/otel/trace"
 )
 
 type UserClaims struct {
@@ -83,6 +84,41 @@ func GetTenantId(ctx context.Context) string {
 	return tenantIdStr
 }
 
+// LookupUserId 从上下文获取用户ID, 不存在时返回 false 且不输出日志
+func LookupUserId(ctx context.Context) (string, bool) {
+	v, ok := ctx.Value(UserIDKey).(string)
+	return v, ok && v != ""
+}
+
+// LookupTenantId 从上下文获取租户ID, 不存在时返回 false 且不输出日志
+func LookupTenantId(ctx context.Context) (string, bool) {
+	v, ok := ctx.Value(TenantIDKey).(string)
+	return v, ok && v != ""
+}
+
+// GetPermissions 从上下文获取权限标识列表
+func GetPermissions(ctx context.Context) []string {
+	perms, _ := ctx.Value(PermissionsKey).([]string)
+	return perms
+}
+
+// GetRoleScopes 从上下文获取角色数据权限列表
+func GetRoleScopes(ctx context.Context) []RoleScope {
+	scopes, _ := ctx.Value(RoleScopesKey).([]RoleScope)
+	return scopes
+}
+
+// GetRequestId 从上下文获取请求ID, 未设置时使用链路追踪的 TraceID
+func GetRequestId(ctx context.Context) string {
+	if id, ok := ctx.Value(RequestIDKey).(string); ok && id != "" {
+		return id
+	}
+	if sc := oteltrace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
+
 func GetUserIdInt(ctx context.Context) int64 {
 	userIdStr := GetUserId(ctx)
 	userId, _ := strconv.ParseInt(userIdStr, 10, 64)
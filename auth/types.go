@@ -12,4 +12,13 @@ type UserInfo struct {
 	Roles       []string `json:"roles"`
 	Permissions []string `json:"permissions"`
 	DeptIds     []string `json:"deptIds"`
+
+	RoleScopes []RoleScope `json:"roleScopes"`
+}
+
+// RoleScope 角色数据权限, 用户拥有多个角色时按并集生效
+type RoleScope struct {
+	RoleId    string   `json:"roleId"`
+	DataScope int      `json:"dataScope"` // 1：全部 2：自定 3：本部门 4：本部门及以下 5：仅本人 6：部门及以下或本人
+	DeptIds   []string `json:"deptIds"`   // 自定数据权限的部门
 }
@@ -13,4 +13,12 @@ const (
 
 	CodeNoData     = 1300 // 数据未找到
 	CodeOrmInvalid = 1301 // ORM错误
+	CodeConflict   = 1302 // 数据已被修改
+
+	CodeTenantNotFound = 1400 // 租户不存在
+	CodeTenantDisabled = 1401 // 租户已停用
+	CodeTenantExpired  = 1402 // 租户已过期
+	CodeCrossTenant    = 1403 // 跨租户操作
+	CodeTenantUserMax  = 1404 // 租户用户数已达上限
+	CodeTenantSeatMax  = 1405 // 租户在线数已达上限
 )
@@ -60,6 +60,11 @@ func GORMErr(err error) *Error {
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return New(CodeNoData, "数据不存在")
 	}
+	// 插件返回的业务错误（如跨租户写入）保持原有错误码
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
 	return New(CodeOrmInvalid, err.Error())
 }
 
@@ -71,6 +76,10 @@ func GORMErrMsg(err error, msg string) *Error {
 		}
 		return New(CodeNoData, msg)
 	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
 	return New(CodeOrmInvalid, err.Error())
 }
 
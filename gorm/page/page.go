@@ -0,0 +1,243 @@
+// Package page
+// @Description: 通用分页查询, 返回值可直接交给 helper.Success 输出 rows / total
+//
+//	res, err := page.Paginate[model.SysUser](db.WithContext(ctx).Where("status = ?", "0"), req.Request, page.Options{
+//		Sortable:     []string{"create_time", "user_name"},
+//		DefaultOrder: "create_time desc",
+//	})
+//
+// 统计与查询使用各自独立的会话, TenantPlugin、DataScopePlugin 的条件对两者分别生效
+package page
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ovra-cloud/ovra-toolkit/errx"
+	"github.com/ovra-cloud/ovra-toolkit/gorm/query"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	DefaultPageSize    = 10  // 默认每页条数
+	DefaultMaxPageSize = 100 // 默认每页最大条数
+)
+
+var (
+	ErrInvalidSort   = errx.New(errx.CodeInvalid, "排序字段不合法")
+	ErrInvalidCursor = errx.New(errx.CodeInvalid, "分页游标不合法")
+)
+
+// Request 分页请求参数, 可嵌入接口请求结构体
+//
+// OrderByColumn 与 IsAsc 支持逗号分隔的多列, IsAsc 取值 asc / desc / ascending / descending
+type Request struct {
+	PageNum       int    `json:"pageNum,optional" form:"pageNum,optional"`
+	PageSize      int    `json:"pageSize,optional" form:"pageSize,optional"`
+	OrderByColumn string `json:"orderByColumn,optional" form:"orderByColumn,optional"`
+	IsAsc         string `json:"isAsc,optional" form:"isAsc,optional"`
+	Cursor        string `json:"cursor,optional" form:"cursor,optional"` // 游标分页时上一页返回的 NextCursor
+}
+
+// Result 分页结果
+type Result[T any] struct {
+	Rows       []T    `json:"rows"`
+	Total      int64  `json:"total"`
+	PageNum    int    `json:"pageNum"`
+	PageSize   int    `json:"pageSize"`
+	NextCursor string `json:"nextCursor,omitempty"` // 游标分页时下一页的游标, 为空表示没有更多数据
+}
+
+// Options 分页选项
+type Options struct {
+	Sortable        []string // 允许客户端排序的列（字段名或列名）, 为空时忽略客户端排序参数
+	DefaultOrder    string   // 客户端未指定排序时使用, 如 "create_time desc"
+	DefaultPageSize int      // 默认 DefaultPageSize
+	MaxPageSize     int      // 默认 DefaultMaxPageSize, 超过时按最大值查询
+	SkipCount       bool     // 不统计总数, 大表可配合游标分页使用
+
+	// Keyset 游标列（须唯一且非空, 通常为主键）, 支持整数、字符串与 time.Time 类型,
+	// 设置后按该列游标分页, 忽略页码与客户端排序
+	Keyset     string
+	KeysetDesc bool // 游标列倒序
+}
+
+func (o Options) pageSize(size int) int {
+	if o.DefaultPageSize <= 0 {
+		o.DefaultPageSize = DefaultPageSize
+	}
+	if o.MaxPageSize <= 0 {
+		o.MaxPageSize = DefaultMaxPageSize
+	}
+	if size <= 0 {
+		size = o.DefaultPageSize
+	}
+	return min(size, o.MaxPageSize)
+}
+
+// Paginate 按 req 分页查询 T, db 上已设置的条件、关联预加载等对统计和查询同时生效
+func Paginate[T any](db *gorm.DB, req Request, opts Options) (*Result[T], error) {
+	res := &Result[T]{
+		Rows:     make([]T, 0),
+		PageNum:  max(req.PageNum, 1),
+		PageSize: opts.pageSize(req.PageSize),
+	}
+	stmt := db.Session(&gorm.Session{Initialized: true}).Statement
+	if err := stmt.Parse(new(T)); err != nil {
+		return nil, err
+	}
+
+	if !opts.SkipCount {
+		// 复制语句后再移除 LIMIT 与预加载, 不影响调用方及后续查询
+		tx := db.Session(&gorm.Session{Initialized: true})
+		if tx.Statement.Model == nil {
+			tx = tx.Model(new(T))
+		}
+		delete(tx.Statement.Clauses, "LIMIT")
+		tx.Statement.Preloads = nil
+		if err := tx.Count(&res.Total).Error; err != nil {
+			return nil, err
+		}
+		if res.Total == 0 {
+			return res, nil
+		}
+	}
+
+	tx := db.Session(&gorm.Session{Initialized: true})
+	if opts.Keyset != "" {
+		return res, keysetFind(tx, stmt.Schema, req.Cursor, opts, res)
+	}
+
+	orders, err := orderBy(stmt.Schema, db.NamingStrategy, req, opts.Sortable)
+	if err != nil {
+		return nil, err
+	}
+	if len(orders) > 0 {
+		tx = tx.Order(clause.OrderBy{Columns: orders})
+	} else if opts.DefaultOrder != "" {
+		tx = tx.Order(opts.DefaultOrder)
+	}
+	err = tx.Offset((res.PageNum - 1) * res.PageSize).Limit(res.PageSize).Find(&res.Rows).Error
+	return res, err
+}
+
+// keysetFind 按游标列查询下一页, 多取一条判断是否还有数据
+func keysetFind[T any](tx *gorm.DB, s *schema.Schema, cursor string, opts Options, res *Result[T]) error {
+	field := query.LookUpField(s, tx.NamingStrategy, opts.Keyset)
+	if field == nil {
+		return fmt.Errorf("page: keyset column %s not found in %s", opts.Keyset, s.Name)
+	}
+	column := clause.Column{Table: clause.CurrentTable, Name: field.DBName}
+	if cursor != "" {
+		value, err := decodeCursor(field, cursor)
+		if err != nil {
+			return err
+		}
+		if opts.KeysetDesc {
+			tx = tx.Where(clause.Lt{Column: column, Value: value})
+		} else {
+			tx = tx.Where(clause.Gt{Column: column, Value: value})
+		}
+	}
+	tx = tx.Order(clause.OrderByColumn{Column: column, Desc: opts.KeysetDesc})
+	if err := tx.Limit(res.PageSize + 1).Find(&res.Rows).Error; err != nil {
+		return err
+	}
+	if len(res.Rows) <= res.PageSize {
+		return nil
+	}
+	res.Rows = res.Rows[:res.PageSize]
+	last := reflect.ValueOf(&res.Rows[len(res.Rows)-1]).Elem()
+	value, _ := field.ValueOf(tx.Statement.Context, last)
+	next, err := encodeCursor(value)
+	if err != nil {
+		return fmt.Errorf("page: keyset column %s: %w", opts.Keyset, err)
+	}
+	res.NextCursor = next
+	return nil
+}
+
+// encodeCursor 编码游标列的值, 指针取其指向的值, 时间按 RFC3339Nano 格式
+func encodeCursor(value interface{}) (string, error) {
+	rv := reflect.ValueOf(value)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return "", errors.New("value is null")
+		}
+		rv = rv.Elem()
+	}
+	var s string
+	if t, ok := rv.Interface().(time.Time); ok {
+		s = t.Format(time.RFC3339Nano)
+	} else {
+		s = fmt.Sprint(rv.Interface())
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(s)), nil
+}
+
+// decodeCursor 按游标列类型解析游标, 避免大整数按字符串比较时丢失精度
+func decodeCursor(field *schema.Field, cursor string) (interface{}, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	s := string(b)
+	if field.IndirectFieldType == reflect.TypeOf(time.Time{}) {
+		if v, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return v, nil
+		}
+		return nil, ErrInvalidCursor
+	}
+	switch field.IndirectFieldType.Kind() {
+	case reflect.String:
+		return s, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return v, nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return v, nil
+		}
+	}
+	return nil, ErrInvalidCursor
+}
+
+// orderBy 解析客户端排序参数, 仅允许 sortable 中的列
+func orderBy(s *schema.Schema, namer schema.Namer, req Request, sortable []string) ([]clause.OrderByColumn, error) {
+	if req.OrderByColumn == "" || len(sortable) == 0 {
+		return nil, nil
+	}
+	allowed := make(map[string]bool, len(sortable))
+	for _, name := range sortable {
+		if f := query.LookUpField(s, namer, name); f != nil {
+			allowed[f.DBName] = true
+		}
+	}
+	directions := strings.Split(req.IsAsc, ",")
+	var orders []clause.OrderByColumn
+	for i, name := range strings.Split(req.OrderByColumn, ",") {
+		f := query.LookUpField(s, namer, strings.TrimSpace(name))
+		if f == nil || !allowed[f.DBName] {
+			return nil, ErrInvalidSort
+		}
+		direction := strings.ToLower(strings.TrimSpace(directions[min(i, len(directions)-1)]))
+		switch direction {
+		case "", "asc", "ascending", "desc", "descending":
+		default:
+			return nil, ErrInvalidSort
+		}
+		orders = append(orders, clause.OrderByColumn{
+			Column: clause.Column{Table: clause.CurrentTable, Name: f.DBName},
+			Desc:   strings.HasPrefix(direction, "desc"),
+		})
+	}
+	return orders, nil
+}
@@ -0,0 +1,219 @@
+package page_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+	"github.com/ovra-cloud/ovra-toolkit/gorm/page"
+	"github.com/ovra-cloud/ovra-toolkit/gorm/plugin"
+	"github.com/ovra-cloud/ovra-toolkit/helper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type Article struct {
+	ID         int64 `gorm:"primaryKey;autoIncrement:false"`
+	TenantID   string
+	CreateBy   string `datascope:"user"`
+	Title      string
+	CreateTime int64
+}
+
+type Comment struct {
+	ID        int64 `gorm:"primaryKey"`
+	ArticleID int64
+	Content   string
+}
+
+// ArticleWithComments 与 Article 使用同一张表, 用于预加载
+type ArticleWithComments struct {
+	Article
+	Comments []Comment `gorm:"foreignKey:ArticleID"`
+}
+
+func (ArticleWithComments) TableName() string { return "articles" }
+
+// Event 指针主键与时间游标
+type Event struct {
+	ID         *int64 `gorm:"primaryKey"`
+	OccurredAt time.Time
+}
+
+func newDB(t *testing.T, plugins ...gorm.Plugin) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	require.NoError(t, db.AutoMigrate(&Article{}))
+
+	var rows []Article
+	for i := 1; i <= 30; i++ {
+		rows = append(rows, Article{
+			ID:         int64(i),
+			TenantID:   []string{"t1", "t2"}[i%2],
+			CreateBy:   []string{"u1", "u2", "u3"}[i%3],
+			Title:      fmt.Sprintf("a%02d", i),
+			CreateTime: int64(100 - i),
+		})
+	}
+	require.NoError(t, db.Create(&rows).Error)
+	for _, p := range plugins {
+		require.NoError(t, db.Use(p))
+	}
+	return db
+}
+
+func ids(rows []Article) []int64 {
+	result := make([]int64, 0, len(rows))
+	for _, r := range rows {
+		result = append(result, r.ID)
+	}
+	return result
+}
+
+func TestPaginate(t *testing.T) {
+	db := newDB(t)
+	opts := page.Options{Sortable: []string{"CreateTime", "title"}, DefaultOrder: "id"}
+
+	t.Run("页码与总数", func(t *testing.T) {
+		res, err := page.Paginate[Article](db, page.Request{PageNum: 2, PageSize: 4}, opts)
+		require.NoError(t, err)
+		assert.Equal(t, int64(30), res.Total)
+		assert.Equal(t, []int64{5, 6, 7, 8}, ids(res.Rows))
+		assert.Equal(t, 2, res.PageNum)
+		assert.Equal(t, 4, res.PageSize)
+
+		res, err = page.Paginate[Article](db.Limit(1), page.Request{PageNum: 9, PageSize: 4}, opts)
+		require.NoError(t, err)
+		assert.Equal(t, int64(30), res.Total)
+		assert.Empty(t, res.Rows)
+		assert.NotNil(t, res.Rows)
+	})
+
+	t.Run("每页条数限制", func(t *testing.T) {
+		res, err := page.Paginate[Article](db, page.Request{PageSize: 1000}, page.Options{MaxPageSize: 20})
+		require.NoError(t, err)
+		assert.Equal(t, 20, res.PageSize)
+		assert.Len(t, res.Rows, 20)
+
+		res, err = page.Paginate[Article](db, page.Request{}, page.Options{})
+		require.NoError(t, err)
+		assert.Equal(t, 1, res.PageNum)
+		assert.Equal(t, page.DefaultPageSize, res.PageSize)
+	})
+
+	t.Run("排序白名单", func(t *testing.T) {
+		res, err := page.Paginate[Article](db, page.Request{PageSize: 3, OrderByColumn: "createTime", IsAsc: "ascending"}, opts)
+		require.NoError(t, err)
+		assert.Equal(t, []int64{30, 29, 28}, ids(res.Rows))
+
+		res, err = page.Paginate[Article](db, page.Request{PageSize: 3, OrderByColumn: "title", IsAsc: "desc"}, opts)
+		require.NoError(t, err)
+		assert.Equal(t, []int64{30, 29, 28}, ids(res.Rows))
+
+		_, err = page.Paginate[Article](db, page.Request{OrderByColumn: "create_by"}, opts)
+		assert.ErrorIs(t, err, page.ErrInvalidSort)
+		_, err = page.Paginate[Article](db, page.Request{OrderByColumn: "title", IsAsc: "desc; drop"}, opts)
+		assert.ErrorIs(t, err, page.ErrInvalidSort)
+	})
+
+	t.Run("租户与数据权限", func(t *testing.T) {
+		ctx := context.WithValue(context.Background(), auth.TenantIDKey, "t1")
+		ctx = context.WithValue(ctx, auth.UserIDKey, "u1")
+		ctx = context.WithValue(ctx, auth.DataScopeKey, 5)
+		scoped := newDB(t, &plugin.TenantPlugin{Enabled: true}, &plugin.DataScopePlugin{Enabled: true})
+		res, err := page.Paginate[Article](scoped.WithContext(ctx), page.Request{PageSize: 2}, opts)
+		require.NoError(t, err)
+		// 偶数ID属于 t1, 能被 3 整除的ID由 u1 创建
+		assert.Equal(t, int64(5), res.Total)
+		assert.Equal(t, []int64{6, 12}, ids(res.Rows))
+
+		res, err = page.Paginate[Article](scoped.WithContext(ctx).Where("id > ?", 10), page.Request{PageSize: 2}, opts)
+		require.NoError(t, err)
+		assert.Equal(t, int64(4), res.Total)
+		assert.Equal(t, []int64{12, 18}, ids(res.Rows))
+	})
+
+	t.Run("游标分页", func(t *testing.T) {
+		keyset := page.Options{Keyset: "id", KeysetDesc: true, SkipCount: true}
+		var got []int64
+		req := page.Request{PageSize: 12}
+		for i := 0; i < 5; i++ {
+			res, err := page.Paginate[Article](db, req, keyset)
+			require.NoError(t, err)
+			got = append(got, ids(res.Rows)...)
+			if res.NextCursor == "" {
+				break
+			}
+			req.Cursor = res.NextCursor
+		}
+		require.Len(t, got, 30)
+		assert.Equal(t, int64(30), got[0])
+		assert.Equal(t, int64(1), got[29])
+
+		_, err := page.Paginate[Article](db, page.Request{Cursor: "%%"}, keyset)
+		assert.ErrorIs(t, err, page.ErrInvalidCursor)
+	})
+
+	t.Run("预加载", func(t *testing.T) {
+		require.NoError(t, db.AutoMigrate(&Comment{}))
+		require.NoError(t, db.Create(&[]Comment{{ID: 1, ArticleID: 1}, {ID: 2, ArticleID: 1}, {ID: 3, ArticleID: 2}}).Error)
+		q := db.Model(&ArticleWithComments{}).Preload("Comments").Limit(1)
+		res, err := page.Paginate[ArticleWithComments](q, page.Request{PageSize: 2}, page.Options{DefaultOrder: "id"})
+		require.NoError(t, err)
+		assert.Equal(t, int64(30), res.Total)
+		require.Len(t, res.Rows, 2)
+		assert.Len(t, res.Rows[0].Comments, 2)
+		assert.Len(t, res.Rows[1].Comments, 1)
+
+		// 调用方的 LIMIT 与预加载不受影响
+		var rows []ArticleWithComments
+		require.NoError(t, q.Order("id").Find(&rows).Error)
+		require.Len(t, rows, 1)
+		assert.Len(t, rows[0].Comments, 2)
+	})
+
+	t.Run("指针与时间游标", func(t *testing.T) {
+		require.NoError(t, db.AutoMigrate(&Event{}))
+		base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		var events []Event
+		for i := 1; i <= 5; i++ {
+			id := int64(i)
+			events = append(events, Event{ID: &id, OccurredAt: base.Add(time.Duration(i) * 1500 * time.Microsecond)})
+		}
+		require.NoError(t, db.Create(&events).Error)
+
+		for _, keyset := range []string{"id", "occurredAt"} {
+			var got []int64
+			req := page.Request{PageSize: 2}
+			for i := 0; i < 5; i++ {
+				res, err := page.Paginate[Event](db, req, page.Options{Keyset: keyset, SkipCount: true})
+				require.NoError(t, err)
+				for _, e := range res.Rows {
+					got = append(got, *e.ID)
+				}
+				if res.NextCursor == "" {
+					break
+				}
+				req.Cursor = res.NextCursor
+			}
+			assert.Equal(t, []int64{1, 2, 3, 4, 5}, got, keyset)
+		}
+	})
+
+	t.Run("响应格式", func(t *testing.T) {
+		res, err := page.Paginate[Article](db, page.Request{PageSize: 1}, opts)
+		require.NoError(t, err)
+		body := helper.Success(res).(map[string]interface{})
+		assert.Equal(t, float64(30), body["total"])
+		assert.Len(t, body["rows"], 1)
+		assert.Equal(t, float64(1), body["pageSize"])
+	})
+}
@@ -0,0 +1,306 @@
+package plugin
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+	"github.com/zeromicro/go-zero/core/logx"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	// AuditTag 变更历史字段标签: `audit:"-"` 不记录, `audit:"redact"` 记录变更但隐藏新旧值.
+	// 加密字段（encrypt 标签）按解密后的值比较并隐藏新旧值, 其盲索引列不记录
+	AuditTag = "audit"
+
+	DefaultChangeTable   = "sys_change_log" // DBSink 默认表名
+	DefaultHistoryMaxRow = 500              // 单条语句默认最多记录的行数
+
+	redactedValue  = "******"
+	historyRecords = "audit:history_records"
+)
+
+// ChangeRecord 字段变更记录
+type ChangeRecord struct {
+	TableName  string    `json:"tableName"`
+	PrimaryKey string    `json:"primaryKey"` // 联合主键以逗号分隔
+	Column     string    `json:"column"`
+	OldValue   string    `json:"oldValue"`
+	NewValue   string    `json:"newValue"`
+	UserId     string    `json:"userId"`
+	TenantId   string    `json:"tenantId"`
+	RequestId  string    `json:"requestId"`
+	ChangeTime time.Time `json:"changeTime"`
+}
+
+// ChangeSink 变更记录输出
+type ChangeSink interface {
+	Save(ctx context.Context, records []ChangeRecord) error
+}
+
+// ChangeSinkFunc 函数形式的 ChangeSink
+type ChangeSinkFunc func(ctx context.Context, records []ChangeRecord) error
+
+func (f ChangeSinkFunc) Save(ctx context.Context, records []ChangeRecord) error {
+	return f(ctx, records)
+}
+
+// LogxSink 以 JSON 输出到 logx
+type LogxSink struct{}
+
+func (LogxSink) Save(ctx context.Context, records []ChangeRecord) error {
+	for _, r := range records {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		logx.WithContext(ctx).Infof("[audit] change: %s", b)
+	}
+	return nil
+}
+
+// ChannelSink 写入 channel, channel 已满时丢弃并记录日志, 不阻塞业务语句
+func ChannelSink(ch chan<- ChangeRecord) ChangeSink {
+	return ChangeSinkFunc(func(ctx context.Context, records []ChangeRecord) error {
+		for _, r := range records {
+			select {
+			case ch <- r:
+			default:
+				logx.WithContext(ctx).Errorf("[audit] change dropped: table=%s pk=%s column=%s", r.TableName, r.PrimaryKey, r.Column)
+			}
+		}
+		return nil
+	})
+}
+
+// DBSink 写入数据库表, 表结构与 ChangeRecord 字段对应
+type DBSink struct {
+	DB    *gorm.DB
+	Table string // 默认 sys_change_log
+}
+
+func (s *DBSink) Save(ctx context.Context, records []ChangeRecord) error {
+	table := s.Table
+	if table == "" {
+		table = DefaultChangeTable
+	}
+	return s.DB.WithContext(ctx).Table(table).Create(&records).Error
+}
+
+// historyUpdate 包装 gorm:update, 更新前后读取受影响的行并逐列比较
+func (ap *AuditPlugin) historyUpdate(update func(*gorm.DB)) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		stmt := db.Statement
+		if db.Error != nil || db.DryRun || stmt.SQL.Len() > 0 || stmt.Schema == nil ||
+			stmt.Schema.Table != stmt.Table || len(stmt.Schema.PrimaryFields) == 0 {
+			update(db)
+			return
+		}
+
+		exprs := primaryKeyExprs(stmt)
+		if c, ok := stmt.Clauses["WHERE"]; ok {
+			if where, ok := c.Expression.(clause.Where); ok {
+				exprs = append(exprs, where.Exprs...)
+			}
+		}
+		if len(exprs) == 0 {
+			update(db)
+			return
+		}
+		olds, err := ap.loadRows(db, exprs)
+		if err != nil || len(olds) > ap.maxRows() {
+			logx.WithContext(stmt.Context).Errorf("[audit] change history skipped: table=%s rows=%d err=%v", stmt.Table, len(olds), err)
+			update(db)
+			return
+		}
+
+		update(db)
+		if db.Error != nil || db.RowsAffected == 0 || len(olds) == 0 {
+			return
+		}
+		if records := ap.diffRows(db, olds); len(records) > 0 {
+			db.InstanceSet(historyRecords, records)
+		}
+	}
+}
+
+// flushHistory 语句提交后输出变更记录, 出错不影响业务语句
+func (ap *AuditPlugin) flushHistory(db *gorm.DB) {
+	v, ok := db.InstanceGet(historyRecords)
+	if !ok || db.Error != nil {
+		return
+	}
+	ctx := db.Statement.Context
+	sink := ap.HistorySink
+	if sink == nil {
+		sink = LogxSink{}
+	}
+	if err := sink.Save(ctx, v.([]ChangeRecord)); err != nil {
+		logx.WithContext(ctx).Errorf("[audit] save change history: %v", err)
+	}
+}
+
+func (ap *AuditPlugin) maxRows() int {
+	if ap.HistoryMaxRows > 0 {
+		return ap.HistoryMaxRows
+	}
+	return DefaultHistoryMaxRow
+}
+
+// loadRows 在同一连接（事务）中读取行, 条件已包含租户、数据权限, 不再重复追加
+func (ap *AuditPlugin) loadRows(db *gorm.DB, exprs []clause.Expression) ([]map[string]interface{}, error) {
+	var rows []map[string]interface{}
+	err := db.Session(&gorm.Session{NewDB: true}).Set(dataScopeProbe, true).Unscoped().
+		Model(db.Statement.Model).Table(db.Statement.Table).
+		Clauses(clause.Where{Exprs: exprs}).Limit(ap.maxRows() + 1).
+		Find(&rows).Error
+	return rows, err
+}
+
+// diffRows 按主键重新读取更新后的行, 生成变更记录
+//
+// gorm:update 执行后会移除 SET 子句, 因此比较全部字段, 表达式更新也能得到实际的新值
+func (ap *AuditPlugin) diffRows(db *gorm.DB, olds []map[string]interface{}) []ChangeRecord {
+	stmt := db.Statement
+	indexes := make(map[string]bool)
+	for _, f := range stmt.Schema.Fields {
+		if tag, ok := f.Tag.Lookup(EncryptTag); ok && tag != "-" {
+			if name := encryptIndexName(tag); name != "" {
+				indexes[name] = true
+			}
+		}
+	}
+	var fields []*schema.Field
+	for _, f := range stmt.Schema.Fields {
+		if ap.tracked(f) && !indexes[f.Name] && !indexes[f.DBName] {
+			fields = append(fields, f)
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	pkValues := make([][]interface{}, 0, len(olds))
+	for _, row := range olds {
+		pkValues = append(pkValues, rowKey(stmt.Schema, row))
+	}
+	column, values := schema.ToQueryValues(stmt.Table, stmt.Schema.PrimaryFieldDBNames, pkValues)
+	news, err := ap.loadRows(db, []clause.Expression{clause.IN{Column: column, Values: values}})
+	if err != nil {
+		logx.WithContext(stmt.Context).Errorf("[audit] change history skipped: table=%s err=%v", stmt.Table, err)
+		return nil
+	}
+	updated := make(map[string]map[string]interface{}, len(news))
+	for _, row := range news {
+		updated[keyString(rowKey(stmt.Schema, row))] = row
+	}
+
+	ctx := stmt.Context
+	userID, _ := getUserID(db)
+	tenantID, _ := ctx.Value(auth.TenantIDKey).(string)
+	requestID := auth.GetRequestId(ctx)
+	now := ap.now(db)
+
+	var records []ChangeRecord
+	for _, old := range olds {
+		pk := keyString(rowKey(stmt.Schema, old))
+		row, ok := updated[pk]
+		if !ok {
+			continue
+		}
+		for _, f := range fields {
+			o, n := old[f.DBName], row[f.DBName]
+			oldValue, newValue := formatValue(o), formatValue(n)
+			if (o == nil) == (n == nil) && oldValue == newValue {
+				continue
+			}
+			if redacted(f) {
+				oldValue, newValue = redactedValue, redactedValue
+			}
+			records = append(records, ChangeRecord{
+				TableName:  stmt.Table,
+				PrimaryKey: pk,
+				Column:     f.DBName,
+				OldValue:   oldValue,
+				NewValue:   newValue,
+				UserId:     userID,
+				TenantId:   tenantID,
+				RequestId:  requestID,
+				ChangeTime: now,
+			})
+		}
+	}
+	return records
+}
+
+// tracked 判断字段是否记录变更, 主键与审计字段不记录
+func (ap *AuditPlugin) tracked(f *schema.Field) bool {
+	if f.PrimaryKey || f.DBName == "" || f.Tag.Get(AuditTag) == "-" {
+		return false
+	}
+	switch f.Name {
+	case ap.CreateBy, ap.CreateDept, ap.CreateTime, ap.UpdateBy, ap.UpdateTime:
+		return false
+	}
+	return true
+}
+
+// redacted 判断字段是否隐藏新旧值
+func redacted(f *schema.Field) bool {
+	if f.Tag.Get(AuditTag) == "redact" {
+		return true
+	}
+	tag, ok := f.Tag.Lookup(EncryptTag)
+	return ok && tag != "-"
+}
+
+func rowKey(s *schema.Schema, row map[string]interface{}) []interface{} {
+	key := make([]interface{}, 0, len(s.PrimaryFieldDBNames))
+	for _, name := range s.PrimaryFieldDBNames {
+		key = append(key, row[name])
+	}
+	return key
+}
+
+func keyString(key []interface{}) string {
+	parts := make([]string, 0, len(key))
+	for _, v := range key {
+		parts = append(parts, formatValue(v))
+	}
+	return strings.Join(parts, ",")
+}
+
+// formatValue 将扫描出的列值转换为字符串
+func formatValue(v interface{}) string {
+	if valuer, ok := v.(driver.Valuer); ok {
+		if value, err := valuer.Value(); err == nil {
+			v = value
+		}
+	}
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return ""
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return ""
+	}
+	switch x := rv.Interface().(type) {
+	case []byte:
+		return string(x)
+	case time.Time:
+		return x.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(x)
+	}
+}
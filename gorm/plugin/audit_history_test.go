@@ -0,0 +1,169 @@
+package plugin_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+	"github.com/ovra-cloud/ovra-toolkit/gorm/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type Account struct {
+	ID         int64 `gorm:"primaryKey"`
+	TenantID   string
+	Name       string
+	Balance    int
+	Password   string `audit:"redact"`
+	Remark     string `audit:"-"`
+	UpdateBy   string
+	UpdateTime time.Time
+}
+
+// memorySink 收集变更记录
+type memorySink struct {
+	mu      sync.Mutex
+	records []plugin.ChangeRecord
+}
+
+func (s *memorySink) Save(_ context.Context, records []plugin.ChangeRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, records...)
+	return nil
+}
+
+func (s *memorySink) take() []plugin.ChangeRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := s.records
+	s.records = nil
+	return records
+}
+
+func TestAuditPluginHistory(t *testing.T) {
+	sink := &memorySink{}
+	db, _ := newSQLiteDB(t, &plugin.AuditPlugin{History: true, HistorySink: sink}, &plugin.TenantPlugin{Enabled: true})
+	require.NoError(t, db.AutoMigrate(&Account{}))
+
+	ctx := context.WithValue(context.Background(), auth.TenantIDKey, "1")
+	ctx = context.WithValue(ctx, auth.UserIDKey, "9")
+	ctx = context.WithValue(ctx, auth.RequestIDKey, "req-1")
+	require.NoError(t, db.WithContext(ctx).Create(&[]Account{
+		{ID: 1, Name: "a", Balance: 10, Password: "p1"},
+		{ID: 2, Name: "b", Balance: 20, Password: "p2"},
+	}).Error)
+	other := context.WithValue(context.Background(), auth.TenantIDKey, "2")
+	require.NoError(t, db.WithContext(other).Create(&Account{ID: 3, Name: "c", Balance: 30}).Error)
+	sink.take()
+
+	t.Run("Updates(map)", func(t *testing.T) {
+		err := db.WithContext(ctx).Model(&Account{ID: 1}).
+			Updates(map[string]interface{}{"name": "a2", "balance": 10, "remark": "x"}).Error
+		require.NoError(t, err)
+		records := sink.take()
+		require.Len(t, records, 1)
+		r := records[0]
+		assert.Equal(t, "accounts", r.TableName)
+		assert.Equal(t, "1", r.PrimaryKey)
+		assert.Equal(t, "name", r.Column)
+		assert.Equal(t, "a", r.OldValue)
+		assert.Equal(t, "a2", r.NewValue)
+		assert.Equal(t, "9", r.UserId)
+		assert.Equal(t, "1", r.TenantId)
+		assert.Equal(t, "req-1", r.RequestId)
+		assert.False(t, r.ChangeTime.IsZero())
+	})
+
+	t.Run("Save", func(t *testing.T) {
+		var acc Account
+		require.NoError(t, db.WithContext(ctx).First(&acc, 2).Error)
+		acc.Balance = 25
+		acc.Password = "secret"
+		require.NoError(t, db.WithContext(ctx).Save(&acc).Error)
+		records := sink.take()
+		require.Len(t, records, 2)
+		assert.Equal(t, "balance", records[0].Column)
+		assert.Equal(t, "20", records[0].OldValue)
+		assert.Equal(t, "25", records[0].NewValue)
+		assert.Equal(t, "password", records[1].Column)
+		assert.Equal(t, "******", records[1].OldValue)
+		assert.Equal(t, "******", records[1].NewValue)
+	})
+
+	t.Run("批量更新与表达式", func(t *testing.T) {
+		err := db.WithContext(ctx).Model(&Account{}).Where("balance > ?", 0).
+			Update("balance", gorm.Expr("balance + ?", 1)).Error
+		require.NoError(t, err)
+		records := sink.take()
+		require.Len(t, records, 2, "仅记录当前租户的数据")
+		assert.Equal(t, "1", records[0].PrimaryKey)
+		assert.Equal(t, "11", records[0].NewValue)
+		assert.Equal(t, "2", records[1].PrimaryKey)
+		assert.Equal(t, "26", records[1].NewValue)
+	})
+
+	t.Run("失败或未命中不记录", func(t *testing.T) {
+		require.NoError(t, db.WithContext(ctx).Model(&Account{ID: 3}).Update("name", "x").Error)
+		assert.Empty(t, sink.take())
+
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return tx.Model(&Account{ID: 1}).Update("no_such_column", 1).Error
+		})
+		require.Error(t, err)
+		assert.Empty(t, sink.take())
+	})
+}
+
+func TestAuditPluginHistoryEncrypted(t *testing.T) {
+	sink := &memorySink{}
+	ep := &plugin.EncryptPlugin{Keys: map[string][]byte{"k1": testKey1}, CurrentKey: "k1", IndexKey: []byte("index-key")}
+	db, _ := newSQLiteDB(t, &plugin.AuditPlugin{History: true, HistorySink: sink}, ep)
+	require.NoError(t, db.AutoMigrate(&Customer{}))
+	c := Customer{ID: 1, Name: "a", Phone: "13800000001"}
+	require.NoError(t, db.Create(&c).Error)
+
+	// 每次保存重新加密, 明文未变时不记录
+	c.Name = "b"
+	require.NoError(t, db.Save(&c).Error)
+	records := sink.take()
+	require.Len(t, records, 1)
+	assert.Equal(t, "name", records[0].Column)
+
+	c.Phone = "13800000002"
+	require.NoError(t, db.Save(&c).Error)
+	records = sink.take()
+	require.Len(t, records, 1)
+	assert.Equal(t, "phone", records[0].Column)
+	assert.Equal(t, "******", records[0].OldValue)
+	assert.Equal(t, "******", records[0].NewValue)
+}
+
+func TestAuditPluginHistorySinks(t *testing.T) {
+	ch := make(chan plugin.ChangeRecord, 1)
+	db, _ := newSQLiteDB(t, &plugin.AuditPlugin{History: true, HistorySink: plugin.ChannelSink(ch)})
+	require.NoError(t, db.AutoMigrate(&Account{}))
+	require.NoError(t, db.Create(&[]Account{{ID: 1, Name: "a"}, {ID: 2, Name: "b"}}).Error)
+
+	// channel 已满时丢弃, 不阻塞更新
+	require.NoError(t, db.Model(&Account{}).Where("id > 0").Update("name", "z").Error)
+	assert.Len(t, ch, 1)
+	r := <-ch
+	assert.Equal(t, "1", r.PrimaryKey)
+
+	type ChangeLog struct {
+		ID int64
+		plugin.ChangeRecord
+	}
+	sinkDB, _ := newSQLiteDB(t)
+	require.NoError(t, sinkDB.Table(plugin.DefaultChangeTable).AutoMigrate(&ChangeLog{}))
+	sink := &plugin.DBSink{DB: sinkDB}
+	require.NoError(t, sink.Save(context.Background(), []plugin.ChangeRecord{{TableName: "accounts", PrimaryKey: "1", Column: "name"}}))
+	var count int64
+	require.NoError(t, sinkDB.Table(plugin.DefaultChangeTable).Where("primary_key = ?", "1").Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+}
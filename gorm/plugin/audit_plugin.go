@@ -2,14 +2,34 @@ package plugin
 
 import (
 	"reflect"
-	"strconv"
 	"time"
 
 	"github.com/ovra-cloud/ovra-toolkit/auth"
 	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
 )
 
-type AuditPlugin struct{}
+// AuditPlugin 审计插件, 填充创建人、创建部门、更新人及时间
+//
+// 人员、部门字段支持 string、整数及其指针; 时间字段支持 time.Time、*time.Time 与整数时间戳,
+// 时间戳默认为秒, 可通过 autoCreateTime:milli / autoUpdateTime:nano 等标签指定精度.
+// UpdateColumn(s) 按 GORM 约定不填充更新人与更新时间.
+//
+// History 开启后记录更新语句的字段级变更历史, 包括 Updates(map) 与 Save,
+// 变更记录在语句提交后输出到 HistorySink; 外层事务回滚时记录不会撤回
+type AuditPlugin struct {
+	CreateBy   string // 创建人字段, 默认 CreateBy
+	CreateDept string // 创建部门字段, 默认 CreateDept, 取 auth.CurrentDeptKey
+	CreateTime string // 创建时间字段, 默认 CreateTime
+	UpdateBy   string // 更新人字段, 默认 UpdateBy
+	UpdateTime string // 更新时间字段, 默认 UpdateTime
+
+	Now func() time.Time // 时钟, 默认使用 gorm.Config.NowFunc
+
+	History        bool       // 是否记录字段变更历史
+	HistorySink    ChangeSink // 变更记录输出, 默认 LogxSink
+	HistoryMaxRows int        // 单条语句最多记录的行数, 超过时不记录, 默认 500
+}
 
 func (ap *AuditPlugin) Name() string {
 	return "AuditPlugin"
@@ -21,78 +41,240 @@ func getUserID(db *gorm.DB) (string, bool) {
 }
 
 func (ap *AuditPlugin) Initialize(db *gorm.DB) error {
+	ap.setDefaults()
+
 	// ===== Create =====
 	if err := db.Callback().Create().Before("gorm:create").
-		Register("audit:create", func(db *gorm.DB) {
-			userID, ok := getUserID(db)
-			if !ok || db.Statement == nil || db.Statement.Schema == nil {
-				return
-			}
-			now := time.Now()
-			WalkStruct(db.Statement.ReflectValue, func(v reflect.Value) {
-				processAuditCreate(db, v, userID, now)
-			})
-		}); err != nil {
+		Register("audit:create", ap.fillCreate); err != nil {
 		return err
 	}
 	// ===== Update =====
 	if err := db.Callback().Update().Before("gorm:update").
-		Register("audit:update", func(db *gorm.DB) {
-			userID, ok := getUserID(db)
-			if !ok {
-				return
-			}
-			now := time.Now()
-			if db.Statement.Schema.LookUpField("UpdateBy") != nil {
-				db.Statement.SetColumn("UpdateBy", userID)
-			}
-			if db.Statement.Schema.LookUpField("UpdateTime") != nil {
-				db.Statement.SetColumn("UpdateTime", now)
-			}
-		}); err != nil {
+		Register("audit:update", ap.fillUpdate); err != nil {
 		return err
 	}
-	return nil
+	// ===== History =====
+	if !ap.History {
+		return nil
+	}
+	update := db.Callback().Update().Get("gorm:update")
+	if err := db.Callback().Update().Replace("gorm:update", ap.historyUpdate(update)); err != nil {
+		return err
+	}
+	return db.Callback().Update().After("gorm:commit_or_rollback_transaction").
+		Register("audit:history", ap.flushHistory)
 }
 
-func processAuditCreate(db *gorm.DB, v reflect.Value, userID string, now time.Time) {
-	// create_by
-	if field := db.Statement.Schema.LookUpField("CreateBy"); field != nil {
-		if f := v.FieldByName("CreateBy"); f.IsValid() && f.CanSet() && f.IsZero() {
-			setUserValue(f, userID)
+func (ap *AuditPlugin) setDefaults() {
+	if ap.CreateBy == "" {
+		ap.CreateBy = "CreateBy"
+	}
+	if ap.CreateDept == "" {
+		ap.CreateDept = "CreateDept"
+	}
+	if ap.CreateTime == "" {
+		ap.CreateTime = "CreateTime"
+	}
+	if ap.UpdateBy == "" {
+		ap.UpdateBy = "UpdateBy"
+	}
+	if ap.UpdateTime == "" {
+		ap.UpdateTime = "UpdateTime"
+	}
+}
+
+func (ap *AuditPlugin) now(db *gorm.DB) time.Time {
+	if ap.Now != nil {
+		return ap.Now()
+	}
+	return db.NowFunc()
+}
+
+// auditValue 待填充的审计字段
+type auditValue struct {
+	field     *schema.Field
+	value     interface{}
+	overwrite bool // 更新字段总是覆盖, 创建字段仅在为空时填充
+}
+
+// auditValues 按当前语句的模型计算需要填充的字段
+func (ap *AuditPlugin) auditValues(db *gorm.DB, create bool) []auditValue {
+	stmt := db.Statement
+	ctx := stmt.Context
+	now := ap.now(db)
+	userID, _ := getUserID(db)
+	deptID, _ := ctx.Value(auth.CurrentDeptKey).(string)
+
+	var values []auditValue
+	add := func(name string, value interface{}, ok, overwrite bool) {
+		field := stmt.Schema.LookUpField(name)
+		if !ok || field == nil || field.DBName == "" {
+			return
 		}
+		values = append(values, auditValue{field: field, value: value, overwrite: overwrite})
+	}
+	addID := func(name, id string, overwrite bool) {
+		value, ok := auditIDValue(stmt.Schema.LookUpField(name), id)
+		add(name, value, ok, overwrite)
+	}
+	addTime := func(name string, overwrite bool) {
+		value, ok := auditTimeValue(stmt.Schema.LookUpField(name), now)
+		add(name, value, ok, overwrite)
+	}
+
+	if create {
+		addID(ap.CreateBy, userID, false)
+		addID(ap.CreateDept, deptID, false)
+		addTime(ap.CreateTime, false)
+	}
+	addID(ap.UpdateBy, userID, true)
+	addTime(ap.UpdateTime, true)
+	return values
+}
+
+// fillCreate 填充 Create、Save、FirstOrCreate 及批量创建的审计字段
+func (ap *AuditPlugin) fillCreate(db *gorm.DB) {
+	stmt := db.Statement
+	if stmt == nil || stmt.Schema == nil {
+		return
 	}
-	// update_by
-	if field := db.Statement.Schema.LookUpField("UpdateBy"); field != nil {
-		if f := v.FieldByName("UpdateBy"); f.IsValid() && f.CanSet() && f.IsZero() {
-			setUserValue(f, userID)
+	values := ap.auditValues(db, true)
+	switch dest := stmt.Dest.(type) {
+	case map[string]interface{}:
+		setMapValues(dest, values)
+		return
+	case *map[string]interface{}:
+		setMapValues(*dest, values)
+		return
+	case []map[string]interface{}:
+		for _, m := range dest {
+			setMapValues(m, values)
 		}
+		return
 	}
-	// create_time
-	if field := db.Statement.Schema.LookUpField("CreateTime"); field != nil {
-		if f := v.FieldByName("CreateTime"); f.IsValid() && f.CanSet() {
-			if f.Type() == reflect.TypeOf(time.Time{}) {
-				f.Set(reflect.ValueOf(now))
+	WalkStruct(stmt.ReflectValue, func(v reflect.Value) {
+		if v.Type() != stmt.Schema.ModelType {
+			return
+		}
+		for _, av := range values {
+			if _, zero := av.field.ValueOf(stmt.Context, v); zero || av.overwrite {
+				_ = db.AddError(av.field.Set(stmt.Context, v, av.value))
 			}
 		}
+	})
+}
+
+// fillUpdate 填充 Update、Updates、Save 及批量更新的更新人、更新时间
+func (ap *AuditPlugin) fillUpdate(db *gorm.DB) {
+	stmt := db.Statement
+	if stmt == nil || stmt.Schema == nil || stmt.SkipHooks {
+		return
 	}
-	// update_time
-	if field := db.Statement.Schema.LookUpField("UpdateTime"); field != nil {
-		if f := v.FieldByName("UpdateTime"); f.IsValid() && f.CanSet() {
-			if f.Type() == reflect.TypeOf(time.Time{}) {
-				f.Set(reflect.ValueOf(now))
-			}
+	values := ap.auditValues(db, false)
+	if len(values) == 0 {
+		return
+	}
+
+	switch dest := stmt.Dest.(type) {
+	case map[string]interface{}:
+		setMapValues(dest, values)
+	case *map[string]interface{}:
+		setMapValues(*dest, values)
+	case []map[string]interface{}:
+		for _, m := range dest {
+			setMapValues(m, values)
+		}
+	default:
+		// 使用其他结构体更新时（如 DTO）, 其不含的字段不会进入 SET, 无法填充
+		t := reflect.TypeOf(stmt.Dest)
+		for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			t = t.Elem()
+		}
+		if t != stmt.Schema.ModelType {
+			return
+		}
+		for _, av := range values {
+			stmt.SetColumn(av.field.Name, av.value, true)
+		}
+	}
+	fields := make([]*schema.Field, 0, len(values))
+	for _, av := range values {
+		fields = append(fields, av.field)
+	}
+	appendSelects(stmt, fields)
+}
+
+// appendSelects 使用 Select 指定更新列时, 追加插件填充的列, 显式 Omit 的除外
+func appendSelects(stmt *gorm.Statement, fields []*schema.Field) {
+	if len(stmt.Selects) == 0 {
+		return
+	}
+	selected := make(map[string]bool, len(stmt.Selects)+len(stmt.Omits))
+	for _, s := range stmt.Selects {
+		if s == "*" {
+			return
 		}
+		selected[s] = true
 	}
+	for _, s := range stmt.Omits {
+		selected[s] = true
+	}
+	selects := stmt.Selects[:len(stmt.Selects):len(stmt.Selects)]
+	for _, f := range fields {
+		if !selected[f.Name] && !selected[f.DBName] {
+			selects = append(selects, f.DBName)
+		}
+	}
+	stmt.Selects = selects
 }
 
-func setUserValue(f reflect.Value, userID string) {
-	switch f.Kind() {
-	case reflect.String:
-		f.SetString(userID)
-	case reflect.Int, reflect.Int64:
-		if id, err := strconv.ParseInt(userID, 10, 64); err == nil {
-			f.SetInt(id)
+// setMapValues 按 map 中已有的键（字段名或列名）填充审计字段
+func setMapValues(m map[string]interface{}, values []auditValue) {
+	for _, av := range values {
+		key := av.field.DBName
+		if _, ok := m[av.field.Name]; ok {
+			key = av.field.Name
+		}
+		if v, ok := m[key]; !ok || v == nil || av.overwrite {
+			m[key] = av.value
 		}
 	}
 }
+
+// auditIDValue 按字段类型转换用户、部门ID, 类型不匹配时不填充
+func auditIDValue(field *schema.Field, id string) (interface{}, bool) {
+	if field == nil || id == "" {
+		return nil, false
+	}
+	value := idValue(field, id)
+	if _, ok := value.(string); ok && field.IndirectFieldType.Kind() != reflect.String {
+		return nil, false
+	}
+	return value, true
+}
+
+// auditTimeValue 按字段类型转换时间
+func auditTimeValue(field *schema.Field, now time.Time) (interface{}, bool) {
+	if field == nil {
+		return nil, false
+	}
+	t := field.IndirectFieldType
+	if t == reflect.TypeOf(time.Time{}) {
+		return now, true
+	}
+	if t.Kind() < reflect.Int || t.Kind() > reflect.Uint64 {
+		return nil, false
+	}
+	precision := field.AutoCreateTime
+	if field.AutoUpdateTime > 0 {
+		precision = field.AutoUpdateTime
+	}
+	switch precision {
+	case schema.UnixMillisecond:
+		return now.UnixMilli(), true
+	case schema.UnixNanosecond:
+		return now.UnixNano(), true
+	default:
+		return now.Unix(), true
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ovra-cloud/ovra-toolkit/auth"
 	"github.com/ovra-cloud/ovra-toolkit/gorm/plugin"
 
 	"gorm.io/driver/mysql"
@@ -13,6 +14,7 @@ import (
 	"gorm.io/gorm/schema"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gorm.io/gorm"
 
 	slog "log"
@@ -90,3 +92,101 @@ func TestAuditPlugin(t *testing.T) {
 	assert.Equal(t, "AliceUpdated", updatedUser.Name)
 	assert.Equal(t, "tester", updatedUser.UpdateBy)
 }
+
+type AuditDoc struct {
+	ID         int64 `gorm:"primaryKey"`
+	Title      string
+	CreateDept *int64
+	CreateBy   int32
+	UpdateBy   *int64
+	CreateTime *time.Time
+	UpdateTime int64
+}
+
+type AuditNote struct {
+	ID       int64 `gorm:"primaryKey"`
+	Title    string
+	Creator  string
+	Modifier string
+	Created  int64 `gorm:"autoCreateTime:milli"`
+	Modified time.Time
+}
+
+func TestAuditPluginFields(t *testing.T) {
+	clock := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	ap := &plugin.AuditPlugin{Now: func() time.Time { return clock }}
+	db, rec := newSQLiteDB(t, ap)
+	require.NoError(t, db.AutoMigrate(&AuditDoc{}))
+
+	ctx := context.WithValue(context.Background(), auth.UserIDKey, "9")
+	ctx = context.WithValue(ctx, auth.CurrentDeptKey, "100")
+	load := func(id int64) AuditDoc {
+		var doc AuditDoc
+		require.NoError(t, db.First(&doc, id).Error)
+		return doc
+	}
+
+	t.Run("创建", func(t *testing.T) {
+		require.NoError(t, db.WithContext(ctx).Create(&AuditDoc{ID: 1, Title: "a"}).Error)
+		require.NoError(t, db.WithContext(ctx).Create(&[]AuditDoc{{ID: 2}, {ID: 3}}).Error)
+		require.NoError(t, db.WithContext(ctx).Model(&AuditDoc{}).Create(map[string]interface{}{"id": 4, "title": "m"}).Error)
+
+		for _, id := range []int64{1, 2, 3, 4} {
+			doc := load(id)
+			assert.Equal(t, int32(9), doc.CreateBy)
+			require.NotNil(t, doc.CreateDept)
+			assert.Equal(t, int64(100), *doc.CreateDept)
+			require.NotNil(t, doc.UpdateBy)
+			assert.Equal(t, int64(9), *doc.UpdateBy)
+			require.NotNil(t, doc.CreateTime)
+			assert.True(t, clock.Equal(*doc.CreateTime))
+			assert.Equal(t, clock.Unix(), doc.UpdateTime)
+		}
+	})
+
+	clock = clock.Add(time.Hour)
+	other := context.WithValue(context.Background(), auth.UserIDKey, "10")
+
+	t.Run("更新", func(t *testing.T) {
+		doc := load(1)
+		require.NoError(t, db.WithContext(other).Model(&doc).Update("title", "b").Error)
+		assert.Equal(t, int64(10), *doc.UpdateBy)
+
+		require.NoError(t, db.WithContext(other).Model(&AuditDoc{ID: 2}).Select("title").Updates(AuditDoc{Title: "c"}).Error)
+		assert.Contains(t, rec.Last(), "`update_by`=10")
+
+		doc = load(3)
+		doc.Title = "d"
+		require.NoError(t, db.WithContext(other).Save(&doc).Error)
+
+		require.NoError(t, db.WithContext(other).Model(&AuditDoc{}).Where("id = ?", 4).
+			Updates(map[string]interface{}{"title": "e"}).Error)
+
+		for _, id := range []int64{1, 2, 3, 4} {
+			doc := load(id)
+			assert.Equal(t, int64(10), *doc.UpdateBy, id)
+			assert.Equal(t, clock.Unix(), doc.UpdateTime, id)
+			assert.Equal(t, int32(9), doc.CreateBy, id)
+		}
+	})
+
+	t.Run("UpdateColumn 不填充", func(t *testing.T) {
+		require.NoError(t, db.WithContext(ctx).Model(&AuditDoc{ID: 1}).UpdateColumn("title", "f").Error)
+		assert.Equal(t, int64(10), *load(1).UpdateBy)
+	})
+
+	t.Run("自定义字段", func(t *testing.T) {
+		db, _ := newSQLiteDB(t, &plugin.AuditPlugin{
+			CreateBy: "Creator", UpdateBy: "Modifier", CreateTime: "Created", UpdateTime: "Modified",
+			Now: func() time.Time { return clock },
+		})
+		require.NoError(t, db.AutoMigrate(&AuditNote{}))
+		require.NoError(t, db.WithContext(ctx).Create(&AuditNote{ID: 1}).Error)
+		var note AuditNote
+		require.NoError(t, db.First(&note, 1).Error)
+		assert.Equal(t, "9", note.Creator)
+		assert.Equal(t, "9", note.Modifier)
+		assert.Equal(t, clock.UnixMilli(), note.Created)
+		assert.True(t, clock.Equal(note.Modified))
+	})
+}
@@ -0,0 +1,85 @@
+package plugin
+
+import (
+	"context"
+
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+
+	"github.com/zeromicro/go-zero/core/logx"
+	"gorm.io/gorm"
+)
+
+const (
+	BypassTenant    = "tenant"
+	BypassDataScope = "data_scope"
+
+	ignoreTenantSetting    = "plugin:ignore_tenant"
+	ignoreDataScopeSetting = "plugin:ignore_data_scope"
+)
+
+type ignoreTenantKey struct{}
+type ignoreDataScopeKey struct{}
+
+// BypassEvent 跳过租户或数据权限过滤的审计事件
+type BypassEvent struct {
+	Kind   string // BypassTenant / BypassDataScope
+	Table  string
+	UserId string
+}
+
+// BypassAudit 记录跳过过滤的审计事件, 输出到 logx, 不可替换以免审计被关闭
+func BypassAudit(ctx context.Context, event BypassEvent) {
+	logx.WithContext(ctx).Infof("[audit] %s filter bypassed: table=%s user=%s", event.Kind, event.Table, event.UserId)
+}
+
+// IgnoreTenant 返回跳过 TenantPlugin 的 context, 在该 context 下执行的语句或事务均不做租户过滤
+func IgnoreTenant(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ignoreTenantKey{}, true)
+}
+
+// IgnoreDataScope 返回跳过 DataScopePlugin 的 context
+func IgnoreDataScope(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ignoreDataScopeKey{}, true)
+}
+
+// WithoutTenant 仅对当前语句跳过 TenantPlugin, 用法 db.Scopes(plugin.WithoutTenant)
+func WithoutTenant(db *gorm.DB) *gorm.DB {
+	return db.Set(ignoreTenantSetting, true)
+}
+
+// WithoutDataScope 仅对当前语句跳过 DataScopePlugin, 用法 db.Scopes(plugin.WithoutDataScope)
+func WithoutDataScope(db *gorm.DB) *gorm.DB {
+	return db.Set(ignoreDataScopeSetting, true)
+}
+
+// isBypassed 判断当前语句是否显式跳过过滤, 跳过时记录审计
+func isBypassed(db *gorm.DB, kind string) bool {
+	ignored := bypassed(db.Statement, kind)
+	if ignored {
+		ctx := db.Statement.Context
+		userId, _ := auth.LookupUserId(ctx)
+		BypassAudit(ctx, BypassEvent{Kind: kind, Table: db.Statement.Table, UserId: userId})
+	}
+	return ignored
+}
+
+// bypassed 判断语句是否跳过过滤, 不记录审计
+func bypassed(stmt *gorm.Statement, kind string) bool {
+	var (
+		ctxKey  any
+		setting string
+	)
+	switch kind {
+	case BypassTenant:
+		ctxKey, setting = ignoreTenantKey{}, ignoreTenantSetting
+	case BypassDataScope:
+		ctxKey, setting = ignoreDataScopeKey{}, ignoreDataScopeSetting
+	default:
+		return false
+	}
+	if ignored, _ := stmt.Context.Value(ctxKey).(bool); ignored {
+		return true
+	}
+	v, ok := stmt.Settings.Load(setting)
+	return ok && v == true
+}
@@ -0,0 +1,56 @@
+package plugin_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+	"github.com/ovra-cloud/ovra-toolkit/gorm/plugin"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zeromicro/go-zero/core/logx/logtest"
+	"gorm.io/gorm"
+)
+
+func TestBypass(t *testing.T) {
+	db := newDryRunDB(t,
+		&plugin.TenantPlugin{Enabled: true},
+		&plugin.DataScopePlugin{Enabled: true},
+	)
+	logs := logtest.NewCollector(t)
+
+	ctx := context.WithValue(context.Background(), auth.TenantIDKey, "1")
+	ctx = context.WithValue(ctx, auth.UserIDKey, "100")
+	ctx = context.WithValue(ctx, auth.DataScopeKey, 5)
+
+	sql := func(db *gorm.DB) string {
+		return db.Find(&[]User{}).Statement.SQL.String()
+	}
+
+	t.Run("默认过滤", func(t *testing.T) {
+		s := sql(db.WithContext(ctx))
+		assert.Contains(t, s, "tenant_id")
+		assert.Contains(t, s, "create_by")
+		assert.Empty(t, logs.String())
+	})
+
+	t.Run("context 跳过租户", func(t *testing.T) {
+		s := sql(db.WithContext(plugin.IgnoreTenant(ctx)))
+		assert.NotContains(t, s, "tenant_id")
+		assert.Contains(t, s, "create_by")
+		assert.Equal(t, "[audit] tenant filter bypassed: table=test_user user=100", logs.Content())
+	})
+
+	t.Run("Scopes 跳过数据权限", func(t *testing.T) {
+		logs.Reset()
+		s := sql(db.WithContext(ctx).Scopes(plugin.WithoutDataScope))
+		assert.Contains(t, s, "tenant_id")
+		assert.NotContains(t, s, "create_by")
+		assert.Equal(t, "[audit] data_scope filter bypassed: table=test_user user=100", logs.Content())
+
+		logs.Reset()
+		s = sql(db.WithContext(ctx))
+		assert.Contains(t, s, "create_by")
+		assert.Empty(t, logs.String())
+	})
+}
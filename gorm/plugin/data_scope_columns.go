@@ -0,0 +1,139 @@
+package plugin
+
+import (
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	DefaultDeptColumn = "create_dept" // 默认部门列
+	DefaultUserColumn = "create_by"   // 默认用户列
+
+	// DataScopeTag 数据权限字段标签: `datascope:"dept"` 部门列, `datascope:"user"` 用户列,
+	// 关联字段上的 `datascope:"join"` 表示按关联模型的数据权限过滤
+	DataScopeTag = "datascope"
+)
+
+// DataScopeColumns 模型的数据权限列
+type DataScopeColumns struct {
+	Dept string // 部门列, 为空时不按部门过滤
+	User string // 用户列, 为空时不按用户过滤
+	// Join 关联路径, 如 "Order" 或 "Order.Shop", 设置后 Dept / User 为关联表的列,
+	// 均为空时使用关联模型自身的声明
+	Join string
+}
+
+// DataScoped 模型实现该接口声明数据权限列, 优先于 datascope 标签; 返回空值时不追加数据权限
+type DataScoped interface {
+	DataScopeColumns() DataScopeColumns
+}
+
+// scopeColumns 返回模型的数据权限列, 未声明且不含默认列的模型返回 false
+func (dsp *DataScopePlugin) scopeColumns(s *schema.Schema) (DataScopeColumns, bool) {
+	if v, ok := dsp.columns.Load(s); ok {
+		cols := v.(DataScopeColumns)
+		return cols, cols != DataScopeColumns{}
+	}
+	var cols DataScopeColumns
+	if m, ok := reflect.New(s.ModelType).Interface().(DataScoped); ok {
+		cols = m.DataScopeColumns()
+	} else {
+		for _, f := range s.Fields {
+			switch f.Tag.Get(DataScopeTag) {
+			case "dept":
+				cols.Dept = f.DBName
+			case "user":
+				cols.User = f.DBName
+			case "join":
+				cols.Join = f.Name
+			}
+		}
+		if cols == (DataScopeColumns{}) {
+			if s.LookUpField(DefaultDeptColumn) != nil {
+				cols.Dept = DefaultDeptColumn
+			}
+			if s.LookUpField(DefaultUserColumn) != nil {
+				cols.User = DefaultUserColumn
+			}
+		}
+	}
+	dsp.columns.Store(s, cols)
+	dsp.tables.Store(strings.ToLower(s.Table), s)
+	return cols, cols != DataScopeColumns{}
+}
+
+// statementColumns 返回当前语句主表的模型与数据权限列, 表名与模型不一致（db.Table）时按已登记的模型判断,
+// 未登记的表不追加数据权限
+func (dsp *DataScopePlugin) statementColumns(stmt *gorm.Statement) (*schema.Schema, DataScopeColumns, bool) {
+	s := stmt.Schema
+	if s == nil || s.Table != stmt.Table {
+		v, ok := dsp.tables.Load(strings.ToLower(stmt.Table))
+		if !ok {
+			return nil, DataScopeColumns{}, false
+		}
+		s = v.(*schema.Schema)
+	}
+	cols, ok := dsp.scopeColumns(s)
+	return s, cols, ok
+}
+
+// joinScopeExpr 将关联表上的数据权限条件转换为主表的子查询条件
+//
+//	owner.fk IN (SELECT rel.pk FROM rel WHERE ...)
+func (dsp *DataScopePlugin) joinScopeExpr(stmt *gorm.Statement, s *schema.Schema, cols DataScopeColumns, build func(table string, cols DataScopeColumns) clause.Expression) clause.Expression {
+	rels := joinRelations(s, cols.Join)
+	if rels == nil {
+		return denyExpr
+	}
+	target := rels[len(rels)-1].FieldSchema
+	inner := DataScopeColumns{Dept: cols.Dept, User: cols.User}
+	if inner.Dept == "" && inner.User == "" {
+		inner, _ = dsp.scopeColumns(target)
+		inner.Join = ""
+	}
+	expr := build(target.Table, inner)
+	if expr == nil {
+		return nil
+	}
+	for i := len(rels) - 1; i >= 0; i-- {
+		owner := stmt.Table
+		if i > 0 {
+			owner = rels[i-1].FieldSchema.Table
+		}
+		if expr = relationInExpr(rels[i], owner, expr); expr == nil {
+			return denyExpr
+		}
+	}
+	return expr
+}
+
+// relationInExpr 生成关联子查询, 仅支持 belongs to / has one / has many
+func relationInExpr(rel *schema.Relationship, owner string, cond clause.Expression) clause.Expression {
+	if rel.JoinTable != nil {
+		return nil
+	}
+	table := rel.FieldSchema.Table
+	for _, ref := range rel.References {
+		if ref.PrimaryKey == nil || ref.ForeignKey == nil {
+			continue
+		}
+		ownerCol, relCol := ref.ForeignKey.DBName, ref.PrimaryKey.DBName
+		if ref.OwnPrimaryKey {
+			ownerCol, relCol = ref.PrimaryKey.DBName, ref.ForeignKey.DBName
+		}
+		return clause.Expr{
+			SQL: "? IN (SELECT ? FROM ? WHERE ?)",
+			Vars: []interface{}{
+				clause.Column{Table: owner, Name: ownerCol},
+				clause.Column{Table: table, Name: relCol},
+				clause.Table{Name: table},
+				cond,
+			},
+		}
+	}
+	return nil
+}
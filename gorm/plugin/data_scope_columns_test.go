@@ -0,0 +1,119 @@
+package plugin_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+	"github.com/ovra-cloud/ovra-toolkit/gorm/plugin"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Ticket struct {
+	ID      int64
+	DeptID  string `datascope:"dept"`
+	OwnerID string `datascope:"user"`
+}
+
+type Shop struct {
+	ID     int64
+	DeptID string
+}
+
+func (Shop) DataScopeColumns() plugin.DataScopeColumns {
+	return plugin.DataScopeColumns{Dept: "dept_id"}
+}
+
+type Order struct {
+	ID         int64
+	ShopID     int64
+	Shop       Shop
+	CreateDept string
+	CreateBy   string
+}
+
+type OrderItem struct {
+	ID      int64
+	OrderID int64
+	Order   Order `datascope:"join"`
+}
+
+type ShopItem struct {
+	ID      int64
+	OrderID int64
+	Order   Order
+}
+
+func (ShopItem) DataScopeColumns() plugin.DataScopeColumns {
+	return plugin.DataScopeColumns{Join: "Order.Shop"}
+}
+
+type Setting struct {
+	ID    int64
+	Value string
+}
+
+// Area 显式声明不需要数据权限
+type Area struct {
+	ID         int64
+	CreateDept string
+}
+
+func (Area) DataScopeColumns() plugin.DataScopeColumns {
+	return plugin.DataScopeColumns{}
+}
+
+func TestDataScopePluginTable(t *testing.T) {
+	db := newDryRunDB(t, &plugin.DataScopePlugin{Enabled: true, Models: []interface{}{&Shop{}, &OrderItem{}}})
+	find := func(table string) string {
+		var rows []map[string]interface{}
+		return db.WithContext(dataScopeCtx(3)).Table(table).Find(&rows).Statement.SQL.String()
+	}
+
+	assert.Equal(t, "SELECT * FROM `areas`", db.WithContext(dataScopeCtx(3)).Find(&[]Area{}).Statement.SQL.String())
+	assert.Equal(t, "SELECT * FROM `areas`", find("areas"))
+	assert.Equal(t, "SELECT * FROM `shops` WHERE (`shops`.`dept_id` = ?)", find("shops"))
+	assert.Equal(t, "SELECT * FROM `order_items` WHERE (`order_items`.`order_id` IN "+
+		"(SELECT `orders`.`id` FROM `orders` WHERE `orders`.`create_dept` = ?))", find("order_items"))
+	// 未登记的表不使用默认列
+	assert.Equal(t, "SELECT * FROM `logs`", find("logs"))
+}
+
+func TestDataScopePluginColumns(t *testing.T) {
+	db := newDryRunDB(t, &plugin.DataScopePlugin{Enabled: true})
+	find := func(ctx context.Context, dest interface{}) string {
+		return db.WithContext(ctx).Find(dest).Statement.SQL.String()
+	}
+
+	assert.Equal(t, "SELECT * FROM `tickets` WHERE (`tickets`.`dept_id` IN (?,?) OR `tickets`.`owner_id` = ?)",
+		find(dataScopeCtx(6), &[]Ticket{}))
+	assert.Equal(t, "SELECT * FROM `shops` WHERE (`shops`.`dept_id` = ?)", find(dataScopeCtx(3), &[]Shop{}))
+	assert.Equal(t, "SELECT * FROM `shops` WHERE (1 = 0)", find(dataScopeCtx(5), &[]Shop{}))
+	assert.Equal(t, "SELECT * FROM `settings`", find(dataScopeCtx(5), &[]Setting{}))
+	assert.Equal(t, "SELECT * FROM `order_items` WHERE (`order_items`.`order_id` IN "+
+		"(SELECT `orders`.`id` FROM `orders` WHERE `orders`.`create_by` = ?))", find(dataScopeCtx(5), &[]OrderItem{}))
+	assert.Equal(t, "SELECT * FROM `shop_items` WHERE (`shop_items`.`order_id` IN "+
+		"(SELECT `orders`.`id` FROM `orders` WHERE `orders`.`shop_id` IN "+
+		"(SELECT `shops`.`id` FROM `shops` WHERE `shops`.`dept_id` = ?)))", find(dataScopeCtx(3), &[]ShopItem{}))
+}
+
+func TestDataScopePluginJoinQuery(t *testing.T) {
+	db, _ := newSQLiteDB(t, &plugin.DataScopePlugin{Enabled: true})
+	require.NoError(t, db.AutoMigrate(&Shop{}, &Order{}, &OrderItem{}))
+	require.NoError(t, db.Create(&[]Order{
+		{ID: 1, CreateDept: "100", CreateBy: "1"},
+		{ID: 2, CreateDept: "200", CreateBy: "2"},
+	}).Error)
+	require.NoError(t, db.Create(&[]OrderItem{{ID: 1, OrderID: 1}, {ID: 2, OrderID: 2}}).Error)
+
+	var items []OrderItem
+	require.NoError(t, db.WithContext(dataScopeCtx(3)).Find(&items).Error)
+	require.Len(t, items, 1)
+	assert.Equal(t, int64(1), items[0].ID)
+
+	ctx := context.WithValue(dataScopeCtx(1), auth.UserIDKey, "2")
+	require.NoError(t, db.WithContext(ctx).Find(&items).Error)
+	assert.Len(t, items, 2)
+}
@@ -1,21 +1,67 @@
 package plugin
 
 //1：全部数据权限 2：自定数据权限 3：本部门数据权限 4：本部门及以下数据权限 5：仅本人数据权限 6：部门及以下或本人数据权限
+//其他数据权限通过 RegisterDataScope 注册
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"strings"
+	"sync"
 
 	"github.com/ovra-cloud/ovra-toolkit/auth"
+	"github.com/ovra-cloud/ovra-toolkit/errx"
 
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
+// ErrDataScopeDenied 严格模式下更新、删除超出数据权限的数据, 使用 errors.Is 判断
+//
+// 插件返回的是包装该错误的 *errx.Error（CodeNoPerm）, 每次均为新值
+var ErrDataScopeDenied = errors.New("data scope: denied")
+
+func dataScopeDeniedErr() error {
+	return errx.New(errx.CodeNoPerm, "无权操作该数据").WithCause(ErrDataScopeDenied)
+}
+
+// dataScopeProbe 严格模式下判断数据是否存在的探测查询, 不追加数据权限
+const dataScopeProbe = "data_scope:probe"
+
 // DataScopePlugin 数据权限插件
 type DataScopePlugin struct {
 	Enabled      bool
 	IgnoreTables []string
+	Update       bool // 更新语句追加数据权限
+	Delete       bool // 删除语句追加数据权限
+	Row          bool // Row / Rows 查询追加数据权限
+	// Strict 更新、删除超出数据权限的数据时返回 errx.CodeNoPerm, 否则仅影响行数为 0;
+	// Save 更新被拦截时 GORM 会回退为 upsert, 开启 Update 时建议同时开启 Strict
+	Strict bool
+	// DeptTree 部门层级配置, 为空时本部门及以下权限使用 auth.BellowDeptKey 中的部门列表
+	DeptTree *DeptTree
+	// Models db.Table 按这些模型的数据权限列过滤, 使用过的模型会自动登记; 未登记的表不追加数据权限
+	Models []interface{}
+
+	columns sync.Map // *schema.Schema => DataScopeColumns
+	tables  sync.Map // 小写表名 => *schema.Schema
+}
+
+// dataScopeCond 数据权限条件, 用于与业务条件区分
+type dataScopeCond struct {
+	clause.Expression
+}
+
+// Build 始终用括号包裹条件, 避免提供者返回的 OR 条件与业务条件混合
+func (c dataScopeCond) Build(builder clause.Builder) {
+	// 多个 OR 条件自带括号
+	if or, ok := c.Expression.(clause.OrConditions); ok && len(or.Exprs) > 1 {
+		or.Build(builder)
+		return
+	}
+	builder.WriteByte('(')
+	c.Expression.Build(builder)
+	builder.WriteByte(')')
 }
 
 // 插件名称
@@ -25,27 +71,113 @@ func (dsp *DataScopePlugin) Name() string {
 
 // 注册插件
 func (dsp *DataScopePlugin) Initialize(db *gorm.DB) error {
-	return db.Callback().Query().Before("gorm:query").
-		Register("data_scope:query", func(db *gorm.DB) {
-			if dsp.shouldSkip(db) {
-				return
-			}
-
-			dataScope, _ := db.Statement.Context.Value(auth.DataScopeKey).(int)
-			userID, _ := db.Statement.Context.Value(auth.UserIDKey).(string)
-			deptID, _ := db.Statement.Context.Value(auth.CurrentDeptKey).(string)
-			bellowDeptID, _ := db.Statement.Context.Value(auth.BellowDeptKey).(string)
-			customerDeptID, _ := db.Statement.Context.Value(auth.CustomerDeptKey).(string)
-
-			sql := dsp.genWhereSQL(db.Statement.Table, dataScope, userID, deptID, bellowDeptID, customerDeptID)
-			if sql != "" {
-				db.Statement.AddClause(clause.Where{
-					Exprs: []clause.Expression{
-						clause.Expr{SQL: sql},
-					},
-				})
-			}
+	for _, model := range dsp.Models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+		dsp.scopeColumns(stmt.Schema)
+	}
+	if err := db.Callback().Query().Before("gorm:query").
+		Register("data_scope:query", dsp.addScope); err != nil {
+		return err
+	}
+	if dsp.Row {
+		if err := db.Callback().Row().Before("gorm:row").
+			Register("data_scope:row", dsp.addScope); err != nil {
+			return err
+		}
+	}
+	if dsp.Update {
+		if err := db.Callback().Update().Before("gorm:update").
+			Register("data_scope:update", dsp.addScope); err != nil {
+			return err
+		}
+		if err := db.Callback().Update().After("gorm:update").
+			Register("data_scope:update_check", dsp.checkDenied); err != nil {
+			return err
+		}
+	}
+	if dsp.Delete {
+		if err := db.Callback().Delete().Before("gorm:delete").
+			Register("data_scope:delete", dsp.addScope); err != nil {
+			return err
+		}
+		if err := db.Callback().Delete().After("gorm:delete").
+			Register("data_scope:delete_check", dsp.checkDenied); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addScope 追加当前用户的数据权限条件
+func (dsp *DataScopePlugin) addScope(db *gorm.DB) {
+	if _, ok := db.Get(dataScopeProbe); ok || dsp.shouldSkip(db) {
+		return
+	}
+
+	s, cols, ok := dsp.statementColumns(db.Statement)
+	if !ok {
+		return
+	}
+	user := dataScopeUserFrom(db.Statement.Context)
+	build := func(table string, cols DataScopeColumns) clause.Expression {
+		return dsp.scopeExpr(db.Statement, table, cols, user)
+	}
+	var expr clause.Expression
+	if cols.Join != "" {
+		expr = dsp.joinScopeExpr(db.Statement, s, cols, build)
+	} else {
+		expr = build(db.Statement.Table, cols)
+	}
+	if expr != nil {
+		db.Statement.AddClause(clause.Where{
+			Exprs: []clause.Expression{dataScopeCond{expr}},
 		})
+	}
+}
+
+// checkDenied 严格模式下, 未影响任何行且去掉数据权限后存在匹配数据时返回无权限错误
+func (dsp *DataScopePlugin) checkDenied(db *gorm.DB) {
+	if !dsp.Strict || db.Error != nil || db.RowsAffected > 0 || db.DryRun {
+		return
+	}
+	c, ok := db.Statement.Clauses["WHERE"]
+	if !ok {
+		return
+	}
+	where, ok := c.Expression.(clause.Where)
+	if !ok {
+		return
+	}
+	exprs := make([]clause.Expression, 0, len(where.Exprs))
+	scoped := false
+	for _, expr := range where.Exprs {
+		if _, ok := expr.(dataScopeCond); ok {
+			scoped = true
+			continue
+		}
+		exprs = append(exprs, expr)
+	}
+	if !scoped {
+		return
+	}
+
+	var count int64
+	// 保留 Model 以解析主键等依赖模型的条件
+	probe := db.Session(&gorm.Session{NewDB: true}).Set(dataScopeProbe, true).
+		Model(db.Statement.Model).Table(db.Statement.Table)
+	if len(exprs) > 0 {
+		probe = probe.Clauses(clause.Where{Exprs: exprs})
+	}
+	if err := probe.Limit(1).Count(&count).Error; err != nil {
+		_ = db.AddError(err)
+		return
+	}
+	if count > 0 {
+		_ = db.AddError(dataScopeDeniedErr())
+	}
 }
 
 // 判断是否跳过
@@ -59,37 +191,86 @@ func (dsp *DataScopePlugin) shouldSkip(db *gorm.DB) bool {
 			return true
 		}
 	}
-	return false
+	return isBypassed(db, BypassDataScope)
+}
+
+// dataScopeUser 当前用户的数据权限上下文
+type dataScopeUser struct {
+	UserID        string
+	DeptID        string
+	BellowDeptIDs []string
+	Roles         []auth.RoleScope
 }
 
-func formatINList(csv string) string {
-	ids := strings.Split(csv, ",")
-	for i := range ids {
-		ids[i] = fmt.Sprintf("'%s'", strings.TrimSpace(ids[i]))
+// dataScopeUserFrom 从 context 读取数据权限, 未设置 auth.RoleScopesKey 时使用
+// auth.DataScopeKey 与 auth.CustomerDeptKey 组成单个角色
+func dataScopeUserFrom(ctx context.Context) dataScopeUser {
+	user := dataScopeUser{Roles: auth.GetRoleScopes(ctx)}
+	user.UserID, _ = ctx.Value(auth.UserIDKey).(string)
+	user.DeptID, _ = ctx.Value(auth.CurrentDeptKey).(string)
+	bellowDeptID, _ := ctx.Value(auth.BellowDeptKey).(string)
+	user.BellowDeptIDs = splitIDs(bellowDeptID)
+	if len(user.Roles) == 0 {
+		dataScope, _ := ctx.Value(auth.DataScopeKey).(int)
+		customerDeptID, _ := ctx.Value(auth.CustomerDeptKey).(string)
+		user.Roles = []auth.RoleScope{{DataScope: dataScope, DeptIds: splitIDs(customerDeptID)}}
 	}
-	return fmt.Sprintf("(%s)", strings.Join(ids, ","))
+	return user
 }
 
-func (dsp *DataScopePlugin) genWhereSQL(table string, scope int, userID, deptID, bellowDeptID, customerDeptID string) string {
-	colPrefix := table
-	if colPrefix != "" {
-		colPrefix += "."
+// splitIDs 拆分逗号分隔的ID列表
+func splitIDs(csv string) []string {
+	ids := make([]string, 0)
+	for _, id := range strings.Split(csv, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
 	}
+	return ids
+}
+
+// denyExpr 模型缺少所需的数据权限列时不返回任何数据
+var denyExpr = clause.Expr{SQL: "1 = 0"}
 
-	switch scope {
+// scopeExpr 按角色调用数据权限提供者生成条件, 列名由当前方言转义, 取值均为绑定参数; 全部数据权限返回 nil
+//
+// 多个角色按 OR 合并, 任一角色为全部数据权限时不追加条件; 各角色的部门合并为一个 IN 条件.
+// 模型未声明所需的列时该条件视为不满足, 如仅有用户列的表在本部门权限下不返回数据
+func (dsp *DataScopePlugin) scopeExpr(stmt *gorm.Statement, table string, cols DataScopeColumns, user dataScopeUser) clause.Expression {
+	scope := DataScopeContext{
+		Table:         table,
+		Columns:       cols,
+		UserID:        user.UserID,
+		DeptID:        user.DeptID,
+		BellowDeptIDs: user.BellowDeptIDs,
+		DeptTree:      dsp.DeptTree,
+	}
+	exprs := make([]clause.Expression, 0, len(user.Roles))
+	for _, role := range user.Roles {
+		scope.Role = role
+		expr := lookupDataScope(role.DataScope)(stmt.Context, stmt, scope)
+		if _, ok := expr.(allData); ok {
+			return nil
+		}
+		exprs = append(exprs, expr)
+	}
+	return mergeScopeExprs(exprs)
+}
+
+// orExprs 合并非空条件, 全部为空时返回 denyExpr
+func orExprs(exprs []clause.Expression) clause.Expression {
+	valid := make([]clause.Expression, 0, len(exprs))
+	for _, e := range exprs {
+		if e != nil {
+			valid = append(valid, e)
+		}
+	}
+	switch len(valid) {
+	case 0:
+		return denyExpr
 	case 1:
-		return ""
-	case 2:
-		return fmt.Sprintf("%screate_dept IN %s", colPrefix, formatINList(customerDeptID))
-	case 3:
-		return fmt.Sprintf("%screate_dept = '%s'", colPrefix, deptID)
-	case 4:
-		return fmt.Sprintf("%screate_dept IN %s", colPrefix, formatINList(bellowDeptID))
-	case 5:
-		return fmt.Sprintf("%screate_by = '%s'", colPrefix, userID)
-	case 6:
-		return fmt.Sprintf("(%screate_dept IN %s OR %screate_by = '%s')", colPrefix, formatINList(bellowDeptID), colPrefix, userID)
+		return valid[0]
 	default:
-		return fmt.Sprintf("%screate_by = '%s'", colPrefix, userID)
+		return clause.Or(valid...)
 	}
 }
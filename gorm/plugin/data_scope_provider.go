@@ -0,0 +1,273 @@
+package plugin
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 内置数据权限
+const (
+	DataScopeAll             = 1 // 全部数据权限
+	DataScopeCustom          = 2 // 自定数据权限
+	DataScopeDept            = 3 // 本部门数据权限
+	DataScopeDeptAndChild    = 4 // 本部门及以下数据权限
+	DataScopeSelf            = 5 // 仅本人数据权限
+	DataScopeDeptChildOrSelf = 6 // 部门及以下或本人数据权限
+)
+
+// AllDataExpr 提供者返回该条件表示可见全部数据, 不再追加任何条件
+var AllDataExpr clause.Expression = allData{}
+
+type allData struct{}
+
+func (allData) Build(builder clause.Builder) {
+	_, _ = builder.WriteString("1 = 1")
+}
+
+// DataScopeContext 数据权限提供者的入参
+type DataScopeContext struct {
+	Table         string           // 条件作用的表, 按关联路径过滤时为关联表
+	Columns       DataScopeColumns // 表的数据权限列
+	Role          auth.RoleScope   // 当前角色
+	UserID        string
+	DeptID        string
+	BellowDeptIDs []string
+	DeptTree      *DeptTree
+}
+
+// DeptColumn 返回部门列, 未声明时返回 false
+func (c DataScopeContext) DeptColumn() (clause.Column, bool) {
+	return clause.Column{Table: c.Table, Name: c.Columns.Dept}, c.Columns.Dept != ""
+}
+
+// UserColumn 返回用户列, 未声明时返回 false
+func (c DataScopeContext) UserColumn() (clause.Column, bool) {
+	return clause.Column{Table: c.Table, Name: c.Columns.User}, c.Columns.User != ""
+}
+
+// DataScopeProvider 数据权限提供者, 返回当前角色可见数据的条件
+//
+// 返回 AllDataExpr 表示全部数据, 返回 nil 表示该角色不可见任何数据.
+// 多个角色的条件按 OR 合并, 同一列上的 clause.IN / clause.Eq 会合并为一个 IN 条件
+type DataScopeProvider func(ctx context.Context, stmt *gorm.Statement, scope DataScopeContext) clause.Expression
+
+var (
+	builtinDataScopes = map[int]DataScopeProvider{
+		DataScopeAll:             allDataScope,
+		DataScopeCustom:          customDataScope,
+		DataScopeDept:            deptDataScope,
+		DataScopeDeptAndChild:    deptAndChildDataScope,
+		DataScopeSelf:            selfDataScope,
+		DataScopeDeptChildOrSelf: deptChildOrSelfDataScope,
+	}
+
+	dataScopeMu        sync.RWMutex
+	dataScopeProviders = map[int]DataScopeProvider{}
+)
+
+// RegisterDataScope 按ID注册数据权限提供者, 可覆盖内置的 1-6
+func RegisterDataScope(id int, provider DataScopeProvider) {
+	dataScopeMu.Lock()
+	defer dataScopeMu.Unlock()
+	dataScopeProviders[id] = provider
+}
+
+// UnregisterDataScope 移除注册的数据权限提供者, 内置的 1-6 恢复为默认实现
+func UnregisterDataScope(id int) {
+	dataScopeMu.Lock()
+	defer dataScopeMu.Unlock()
+	delete(dataScopeProviders, id)
+}
+
+// lookupDataScope 查找提供者, 未注册的ID按仅本人数据权限处理
+func lookupDataScope(id int) DataScopeProvider {
+	dataScopeMu.RLock()
+	defer dataScopeMu.RUnlock()
+	if p, ok := dataScopeProviders[id]; ok {
+		return p
+	}
+	if p, ok := builtinDataScopes[id]; ok {
+		return p
+	}
+	return selfDataScope
+}
+
+func allDataScope(context.Context, *gorm.Statement, DataScopeContext) clause.Expression {
+	return AllDataExpr
+}
+
+func customDataScope(_ context.Context, _ *gorm.Statement, scope DataScopeContext) clause.Expression {
+	col, ok := scope.DeptColumn()
+	if !ok {
+		return nil
+	}
+	return clause.IN{Column: col, Values: toValues(scope.Role.DeptIds)}
+}
+
+func deptDataScope(_ context.Context, _ *gorm.Statement, scope DataScopeContext) clause.Expression {
+	col, ok := scope.DeptColumn()
+	if !ok {
+		return nil
+	}
+	return clause.Eq{Column: col, Value: scope.DeptID}
+}
+
+func deptAndChildDataScope(_ context.Context, stmt *gorm.Statement, scope DataScopeContext) clause.Expression {
+	col, ok := scope.DeptColumn()
+	if !ok {
+		return nil
+	}
+	if scope.DeptTree != nil {
+		return subtreeCond{
+			Expression: scope.DeptTree.subtreeExpr(stmt, col, scope.DeptID),
+			column:     col,
+			deptID:     scope.DeptID,
+		}
+	}
+	return clause.IN{Column: col, Values: toValues(scope.BellowDeptIDs)}
+}
+
+func selfDataScope(_ context.Context, _ *gorm.Statement, scope DataScopeContext) clause.Expression {
+	col, ok := scope.UserColumn()
+	if !ok {
+		return nil
+	}
+	return clause.Eq{Column: col, Value: scope.UserID}
+}
+
+func deptChildOrSelfDataScope(ctx context.Context, stmt *gorm.Statement, scope DataScopeContext) clause.Expression {
+	var exprs []clause.Expression
+	for _, e := range []clause.Expression{
+		deptAndChildDataScope(ctx, stmt, scope),
+		selfDataScope(ctx, stmt, scope),
+	} {
+		if e != nil {
+			exprs = append(exprs, e)
+		}
+	}
+	if len(exprs) == 0 {
+		return nil
+	}
+	return clause.Or(exprs...)
+}
+
+// subtreeCond 部门及下级部门条件, 合并时从同列的 IN 条件中去除该部门
+type subtreeCond struct {
+	clause.Expression
+	column clause.Column
+	deptID string
+}
+
+func toValues(ids []string) []interface{} {
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		values[i] = id
+	}
+	return values
+}
+
+// mergeScopeExprs 按 OR 合并各角色的条件, 同一列的取值合并为一个 IN 条件并去重, 条件按首次出现的顺序输出
+func mergeScopeExprs(exprs []clause.Expression) clause.Expression {
+	var (
+		flat   []clause.Expression
+		slots  []interface{} // clause.Column 或 clause.Expression
+		values = make(map[clause.Column][]interface{})
+		trees  []subtreeCond
+	)
+	for _, e := range exprs {
+		if or, ok := e.(clause.OrConditions); ok {
+			flat = append(flat, or.Exprs...)
+		} else if e != nil {
+			flat = append(flat, e)
+		}
+	}
+	addValues := func(col clause.Column, vs ...interface{}) {
+		if _, ok := values[col]; !ok {
+			slots = append(slots, col)
+			values[col] = []interface{}{}
+		}
+		for _, v := range vs {
+			if !containsValue(values[col], v) {
+				values[col] = append(values[col], v)
+			}
+		}
+	}
+	for _, e := range flat {
+		switch v := e.(type) {
+		case clause.IN:
+			if col, ok := v.Column.(clause.Column); ok && mergeable(v.Values...) {
+				addValues(col, v.Values...)
+				continue
+			}
+		case clause.Eq:
+			if col, ok := v.Column.(clause.Column); ok && v.Value != nil && mergeable(v.Value) {
+				addValues(col, v.Value)
+				continue
+			}
+		case subtreeCond:
+			trees = append(trees, v)
+		}
+		duplicated := false
+		for _, slot := range slots {
+			duplicated = duplicated || reflect.DeepEqual(slot, e)
+		}
+		if !duplicated {
+			slots = append(slots, e)
+		}
+	}
+
+	// 下级部门条件已包含本部门
+	for _, t := range trees {
+		if vs, ok := values[t.column]; ok {
+			values[t.column] = removeValue(vs, t.deptID)
+		}
+	}
+	result := make([]clause.Expression, 0, len(slots))
+	for _, slot := range slots {
+		switch v := slot.(type) {
+		case clause.Column:
+			if len(values[v]) > 0 {
+				result = append(result, clause.IN{Column: v, Values: values[v]})
+			}
+		case clause.Expression:
+			result = append(result, v)
+		}
+	}
+	return orExprs(result)
+}
+
+// mergeable 仅合并标量取值
+func mergeable(values ...interface{}) bool {
+	for _, v := range values {
+		switch reflect.ValueOf(v).Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map, reflect.Struct, reflect.Ptr, reflect.Invalid:
+			return false
+		}
+	}
+	return true
+}
+
+func containsValue(values []interface{}, v interface{}) bool {
+	for _, item := range values {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+func removeValue(values []interface{}, v interface{}) []interface{} {
+	out := values[:0:0]
+	for _, item := range values {
+		if item != v {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,106 @@
+package plugin
+
+import (
+	"strings"
+
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	DeptTreeAncestors = "ancestors" // 按部门表的祖级列表列查询下级部门
+	DeptTreeClosure   = "closure"   // 按闭包表查询下级部门
+)
+
+// DeptTree 部门层级配置, 设置后本部门及以下权限（4、6）通过子查询计算下级部门,
+// 不再依赖 auth.BellowDeptKey 传入部门列表
+type DeptTree struct {
+	Mode string // DeptTreeAncestors / DeptTreeClosure, 默认 DeptTreeAncestors
+
+	Table           string // 部门表, 默认 sys_dept
+	IDColumn        string // 部门ID列, 默认 dept_id
+	AncestorsColumn string // 祖级列表列, 逗号分隔且不含自身（如 0,100,101）, 默认 ancestors
+
+	// 闭包表需包含每个部门到自身的记录
+	ClosureTable     string // 闭包表, 默认 sys_dept_closure
+	AncestorColumn   string // 祖先部门列, 默认 ancestor_id
+	DescendantColumn string // 后代部门列, 默认 descendant_id
+
+	// 上下文中存在租户时, 部门表、闭包表按租户列过滤
+	TenantColumn string // 租户列, 默认 tenant_id
+	NoTenant     bool   // 部门表、闭包表不区分租户时设置
+}
+
+func (t *DeptTree) withDefaults() DeptTree {
+	tree := *t
+	if tree.Mode == "" {
+		tree.Mode = DeptTreeAncestors
+	}
+	if tree.Table == "" {
+		tree.Table = "sys_dept"
+	}
+	if tree.IDColumn == "" {
+		tree.IDColumn = "dept_id"
+	}
+	if tree.AncestorsColumn == "" {
+		tree.AncestorsColumn = "ancestors"
+	}
+	if tree.ClosureTable == "" {
+		tree.ClosureTable = "sys_dept_closure"
+	}
+	if tree.AncestorColumn == "" {
+		tree.AncestorColumn = "ancestor_id"
+	}
+	if tree.DescendantColumn == "" {
+		tree.DescendantColumn = "descendant_id"
+	}
+	if tree.TenantColumn == "" {
+		tree.TenantColumn = DefaultTenantColumn
+	}
+	return tree
+}
+
+// subtreeExpr 生成 column 属于 deptID 及其下级部门的条件
+func (t *DeptTree) subtreeExpr(stmt *gorm.Statement, column clause.Column, deptID string) clause.Expression {
+	tree := t.withDefaults()
+	if tree.Mode == DeptTreeClosure {
+		return clause.Expr{
+			SQL: "? IN (SELECT ? FROM ? WHERE ?)",
+			Vars: []interface{}{
+				column,
+				clause.Column{Name: tree.DescendantColumn},
+				clause.Table{Name: tree.ClosureTable},
+				tree.withTenant(stmt, clause.Eq{Column: clause.Column{Name: tree.AncestorColumn}, Value: deptID}),
+			},
+		}
+	}
+
+	id := clause.Column{Name: tree.IDColumn}
+	ancestors := clause.Column{Name: tree.AncestorsColumn}
+	var match clause.Expression
+	if stmt.Dialector.Name() == "mysql" {
+		match = clause.Expr{SQL: "? = ? OR FIND_IN_SET(?, ?)", Vars: []interface{}{id, deptID, deptID, ancestors}}
+	} else {
+		// LIKE 通配符会扩大匹配范围, 部门ID包含时不返回数据
+		if strings.ContainsAny(deptID, "%_,\\") {
+			return denyExpr
+		}
+		match = clause.Expr{SQL: "? = ? OR (',' || ? || ',') LIKE ?", Vars: []interface{}{id, deptID, ancestors,
+			"%," + deptID + ",%"}}
+	}
+	return clause.Expr{
+		SQL:  "? IN (SELECT ? FROM ? WHERE ?)",
+		Vars: []interface{}{column, id, clause.Table{Name: tree.Table}, tree.withTenant(stmt, match)},
+	}
+}
+
+// withTenant 为部门子查询追加当前租户条件, 忽略租户或未设置租户时原样返回
+func (t *DeptTree) withTenant(stmt *gorm.Statement, cond clause.Expression) clause.Expression {
+	tenantID, ok := auth.LookupTenantId(stmt.Context)
+	if t.NoTenant || !ok || bypassed(stmt, BypassTenant) {
+		return cond
+	}
+	return clause.And(cond, clause.Eq{Column: clause.Column{Name: t.TenantColumn}, Value: tenantID})
+}
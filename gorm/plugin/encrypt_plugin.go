@@ -0,0 +1,504 @@
+package plugin
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/ovra-cloud/ovra-toolkit/errx"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	// EncryptTag 加密字段标签, 支持 string 与 *string:
+	//
+	//	Phone      string `encrypt:"true"`               // 仅加密
+	//	Phone      string `encrypt:"index:PhoneIndex"`   // 加密并写入盲索引字段, 可按 phone = ? 查询
+	//	PhoneIndex string `gorm:"size:64;index"`
+	EncryptTag = "encrypt"
+
+	// 密文格式: $enc$<密钥ID>$<base64(nonce+密文)>, 不含前缀的值视为未加密的历史数据
+	encryptPrefix = "$enc$"
+)
+
+// ErrDecrypt 密文无法解密（密钥ID不存在或数据被篡改）, 使用 errors.Is 判断
+//
+// 返回的是包装该错误的 *errx.Error（CodeInternal）, 每次均为新值
+var ErrDecrypt = errors.New("encrypt: decrypt failed")
+
+func decryptErr() error {
+	return errx.New(errx.CodeInternal, "数据解密失败").WithCause(ErrDecrypt)
+}
+
+// EncryptPlugin 字段加密插件, 写入时使用 AES-GCM 加密标记字段, 查询后自动解密
+//
+// 密文中保存密钥ID, 轮换密钥时新增密钥并修改 CurrentKey, 旧数据仍可解密, 再次保存时使用新密钥.
+// 配置 IndexKey 后, 带 index 选项的字段同时写入 HMAC-SHA256 盲索引,
+// 查询、更新、删除条件中对该字段的 =、<>、IN 自动改写为对盲索引列的比较.
+// Row / Scan 等不经过模型的查询不做解密
+type EncryptPlugin struct {
+	Keys       map[string][]byte // 密钥ID => AES 密钥（16 / 24 / 32 字节）
+	CurrentKey string            // 加密使用的密钥ID
+	IndexKey   []byte            // 盲索引 HMAC 密钥, 为空时不生成盲索引
+
+	aeads  map[string]cipher.AEAD
+	fields sync.Map // *schema.Schema => []encryptField
+}
+
+// encryptField 加密字段及其盲索引字段
+type encryptField struct {
+	field *schema.Field
+	index *schema.Field
+}
+
+func (ep *EncryptPlugin) Name() string {
+	return "EncryptPlugin"
+}
+
+func (ep *EncryptPlugin) Initialize(db *gorm.DB) error {
+	if _, ok := ep.Keys[ep.CurrentKey]; !ok {
+		return fmt.Errorf("encrypt plugin: current key %q not found", ep.CurrentKey)
+	}
+	ep.aeads = make(map[string]cipher.AEAD, len(ep.Keys))
+	for id, key := range ep.Keys {
+		if id == "" || strings.Contains(id, "$") {
+			return fmt.Errorf("encrypt plugin: invalid key id %q", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return fmt.Errorf("encrypt plugin: key %s: %w", id, err)
+		}
+		if ep.aeads[id], err = cipher.NewGCM(block); err != nil {
+			return err
+		}
+	}
+
+	// ===== Create =====
+	if err := db.Callback().Create().Before("gorm:create").
+		Register("encrypt:create", func(db *gorm.DB) {
+			ep.encryptDest(db, db.Statement.ReflectValue)
+		}); err != nil {
+		return err
+	}
+	if err := db.Callback().Create().After("gorm:create").
+		Register("encrypt:after_create", ep.decryptDest); err != nil {
+		return err
+	}
+	// ===== Query =====
+	if err := db.Callback().Query().Before("gorm:query").
+		Register("encrypt:query", ep.rewriteWhere); err != nil {
+		return err
+	}
+	if err := db.Callback().Query().After("gorm:query").
+		Register("encrypt:after_query", ep.decryptDest); err != nil {
+		return err
+	}
+	// ===== Update =====
+	if err := db.Callback().Update().Before("gorm:update").
+		Register("encrypt:update", func(db *gorm.DB) {
+			ep.rewriteWhere(db)
+			// Updates 传入非指针结构体时复制为可寻址的值
+			dest := reflect.ValueOf(db.Statement.Dest)
+			if dest.Kind() == reflect.Struct {
+				ptr := reflect.New(dest.Type())
+				ptr.Elem().Set(dest)
+				db.Statement.Dest, dest = ptr.Interface(), ptr
+			}
+			ep.encryptDest(db, dest)
+		}); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().After("gorm:update").
+		Register("encrypt:after_update", ep.decryptDest); err != nil {
+		return err
+	}
+	// ===== Delete =====
+	return db.Callback().Delete().Before("gorm:delete").
+		Register("encrypt:delete", ep.rewriteWhere)
+}
+
+// Encrypt 使用当前密钥加密, 空字符串不加密
+//
+// 写入的值总是重新加密, 客户端提交的密文按明文处理, 避免借写入读取他人数据的明文
+func (ep *EncryptPlugin) Encrypt(plain string) (string, error) {
+	if plain == "" {
+		return plain, nil
+	}
+	aead := ep.aeads[ep.CurrentKey]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plain), nil)
+	return encryptPrefix + ep.CurrentKey + "$" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt 按密文中的密钥ID解密, 未加密的值原样返回
+func (ep *EncryptPlugin) Decrypt(value string) (string, error) {
+	if !strings.HasPrefix(value, encryptPrefix) {
+		return value, nil
+	}
+	id, data, ok := strings.Cut(strings.TrimPrefix(value, encryptPrefix), "$")
+	aead, found := ep.aeads[id]
+	if !ok || !found {
+		return "", decryptErr()
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil || len(raw) < aead.NonceSize() {
+		return "", decryptErr()
+	}
+	plain, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], nil)
+	if err != nil {
+		return "", decryptErr()
+	}
+	return string(plain), nil
+}
+
+// BlindIndex 计算盲索引, 未配置 IndexKey 时返回空字符串
+func (ep *EncryptPlugin) BlindIndex(plain string) string {
+	if len(ep.IndexKey) == 0 || plain == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, ep.IndexKey)
+	mac.Write([]byte(plain))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// encryptFields 返回模型的加密字段
+func (ep *EncryptPlugin) encryptFields(s *schema.Schema) []encryptField {
+	if s == nil {
+		return nil
+	}
+	if v, ok := ep.fields.Load(s); ok {
+		return v.([]encryptField)
+	}
+	var fields []encryptField
+	for _, f := range s.Fields {
+		tag, ok := f.Tag.Lookup(EncryptTag)
+		if !ok || tag == "-" || f.DBName == "" || f.IndirectFieldType.Kind() != reflect.String {
+			continue
+		}
+		ef := encryptField{field: f}
+		if name := encryptIndexName(tag); name != "" && len(ep.IndexKey) > 0 {
+			ef.index = s.LookUpField(name)
+		}
+		fields = append(fields, ef)
+	}
+	ep.fields.Store(s, fields)
+	return fields
+}
+
+// encryptIndexName 返回加密标签中的盲索引字段名
+func encryptIndexName(tag string) string {
+	for _, opt := range strings.Split(tag, ";") {
+		if name, ok := strings.CutPrefix(strings.TrimSpace(opt), "index:"); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// encryptDest 加密待写入的数据, map 复制后再修改, 不影响调用方;
+// 结构体在 rv 上原地加密, 创建使用 ReflectValue, 更新使用 Dest
+func (ep *EncryptPlugin) encryptDest(db *gorm.DB, rv reflect.Value) {
+	stmt := db.Statement
+	fields := ep.encryptFields(stmt.Schema)
+	if len(fields) == 0 || db.Error != nil {
+		return
+	}
+	switch dest := stmt.Dest.(type) {
+	case map[string]interface{}:
+		stmt.Dest = ep.encryptMap(db, dest, fields)
+	case *map[string]interface{}:
+		stmt.Dest = ep.encryptMap(db, *dest, fields)
+	case []map[string]interface{}:
+		maps := make([]map[string]interface{}, 0, len(dest))
+		for _, m := range dest {
+			maps = append(maps, ep.encryptMap(db, m, fields))
+		}
+		stmt.Dest = maps
+	default:
+		WalkStruct(rv, func(v reflect.Value) {
+			if v.Type() == stmt.Schema.ModelType && v.CanAddr() {
+				ep.encryptStruct(db, v, fields)
+			}
+		})
+	}
+
+	// Select 指定了加密列时同时更新盲索引列
+	var indexes []*schema.Field
+	for _, ef := range fields {
+		for _, s := range stmt.Selects {
+			if ef.index != nil && (s == ef.field.Name || s == ef.field.DBName) {
+				indexes = append(indexes, ef.index)
+			}
+		}
+	}
+	appendSelects(stmt, indexes)
+}
+
+func (ep *EncryptPlugin) encryptMap(db *gorm.DB, m map[string]interface{}, fields []encryptField) map[string]interface{} {
+	result := make(map[string]interface{}, len(m)+len(fields))
+	for k, v := range m {
+		result[k] = v
+	}
+	for _, ef := range fields {
+		for _, key := range []string{ef.field.Name, ef.field.DBName} {
+			v, ok := m[key]
+			if !ok {
+				continue
+			}
+			plain, ok := stringValue(v)
+			if !ok {
+				break
+			}
+			enc, err := ep.Encrypt(plain)
+			if db.AddError(err) != nil {
+				return m
+			}
+			result[key] = enc
+			if ef.index != nil {
+				result[ef.index.DBName] = ep.BlindIndex(plain)
+			}
+			break
+		}
+	}
+	return result
+}
+
+func (ep *EncryptPlugin) encryptStruct(db *gorm.DB, v reflect.Value, fields []encryptField) {
+	ctx := db.Statement.Context
+	for _, ef := range fields {
+		value, _ := ef.field.ValueOf(ctx, v)
+		plain, ok := stringValue(value)
+		if !ok {
+			continue
+		}
+		enc, err := ep.Encrypt(plain)
+		if db.AddError(err) != nil {
+			return
+		}
+		_ = db.AddError(ef.field.Set(ctx, v, enc))
+		if ef.index != nil {
+			_ = db.AddError(ef.index.Set(ctx, v, ep.BlindIndex(plain)))
+		}
+	}
+}
+
+// decryptDest 解密查询结果, 以及写入后恢复模型中的明文
+func (ep *EncryptPlugin) decryptDest(db *gorm.DB) {
+	stmt := db.Statement
+	fields := ep.encryptFields(stmt.Schema)
+	if len(fields) == 0 {
+		return
+	}
+	ctx := stmt.Context
+	decryptMap := func(m map[string]interface{}) {
+		for _, ef := range fields {
+			for _, key := range []string{ef.field.DBName, ef.field.Name} {
+				if s, ok := stringValue(m[key]); ok {
+					plain, err := ep.Decrypt(s)
+					if db.AddError(err) == nil {
+						m[key] = plain
+					}
+				}
+			}
+		}
+	}
+	// Dest 与 ReflectValue 通常指向同一数据, 每个结构体只解密一次, 避免再次解密以 $enc$ 开头的明文
+	done := make(map[uintptr]bool)
+	walk := func(rv reflect.Value) {
+		WalkStruct(rv, func(v reflect.Value) {
+			if v.Type() != stmt.Schema.ModelType || !v.CanAddr() || done[v.Addr().Pointer()] {
+				return
+			}
+			done[v.Addr().Pointer()] = true
+			for _, ef := range fields {
+				value, _ := ef.field.ValueOf(ctx, v)
+				if s, ok := stringValue(value); ok && strings.HasPrefix(s, encryptPrefix) {
+					plain, err := ep.Decrypt(s)
+					if db.AddError(err) == nil {
+						_ = db.AddError(ef.field.Set(ctx, v, plain))
+					}
+				}
+			}
+		})
+	}
+
+	switch dest := stmt.Dest.(type) {
+	case map[string]interface{}:
+		decryptMap(dest)
+	case *map[string]interface{}:
+		decryptMap(*dest)
+	case *[]map[string]interface{}:
+		for _, m := range *dest {
+			decryptMap(m)
+		}
+	case []map[string]interface{}:
+		for _, m := range dest {
+			decryptMap(m)
+		}
+	default:
+		walk(reflect.ValueOf(stmt.Dest))
+	}
+	// 更新时 GORM 会将写入的值回填到 Model
+	walk(stmt.ReflectValue)
+}
+
+// rewriteWhere 将加密字段的等值条件改写为盲索引条件
+func (ep *EncryptPlugin) rewriteWhere(db *gorm.DB) {
+	stmt := db.Statement
+	if stmt.Schema == nil || stmt.SQL.Len() > 0 {
+		return
+	}
+	indexes := make(map[string]string)
+	for _, ef := range ep.encryptFields(stmt.Schema) {
+		if ef.index != nil {
+			indexes[ef.field.DBName] = ef.index.DBName
+		}
+	}
+	c, ok := stmt.Clauses["WHERE"]
+	if !ok || len(indexes) == 0 {
+		return
+	}
+	where, ok := c.Expression.(clause.Where)
+	if !ok {
+		return
+	}
+	exprs := make([]clause.Expression, 0, len(where.Exprs))
+	for _, expr := range where.Exprs {
+		exprs = append(exprs, ep.rewriteExpr(expr, indexes))
+	}
+	c.Expression = clause.Where{Exprs: exprs}
+	stmt.Clauses["WHERE"] = c
+}
+
+func (ep *EncryptPlugin) rewriteExpr(expr clause.Expression, indexes map[string]string) clause.Expression {
+	switch e := expr.(type) {
+	case clause.Eq:
+		if col, ok := indexColumn(e.Column, indexes); ok {
+			return clause.Eq{Column: col, Value: ep.indexValue(e.Value)}
+		}
+	case clause.Neq:
+		if col, ok := indexColumn(e.Column, indexes); ok {
+			return clause.Neq{Column: col, Value: ep.indexValue(e.Value)}
+		}
+	case clause.IN:
+		if col, ok := indexColumn(e.Column, indexes); ok {
+			values := make([]interface{}, 0, len(e.Values))
+			for _, v := range e.Values {
+				values = append(values, ep.indexValue(v))
+			}
+			return clause.IN{Column: col, Values: values}
+		}
+	case clause.Expr:
+		return ep.rewriteSQL(e, indexes)
+	case clause.AndConditions:
+		return clause.AndConditions{Exprs: ep.rewriteExprs(e.Exprs, indexes)}
+	case clause.OrConditions:
+		return clause.OrConditions{Exprs: ep.rewriteExprs(e.Exprs, indexes)}
+	case clause.NotConditions:
+		return clause.NotConditions{Exprs: ep.rewriteExprs(e.Exprs, indexes)}
+	}
+	return expr
+}
+
+func (ep *EncryptPlugin) rewriteExprs(exprs []clause.Expression, indexes map[string]string) []clause.Expression {
+	result := make([]clause.Expression, 0, len(exprs))
+	for _, expr := range exprs {
+		result = append(result, ep.rewriteExpr(expr, indexes))
+	}
+	return result
+}
+
+// rewriteSQL 改写 Where("phone = ?", x) 形式的条件, 仅支持单列的 =、<>、!=、IN
+func (ep *EncryptPlugin) rewriteSQL(e clause.Expr, indexes map[string]string) clause.Expression {
+	if len(e.Vars) != 1 {
+		return e
+	}
+	sql := strings.TrimSpace(e.SQL)
+	pos := strings.IndexAny(sql, " =<!")
+	if pos <= 0 {
+		return e
+	}
+	column, op := sql[:pos], strings.ToUpper(strings.Join(strings.Fields(sql[pos:]), " "))
+	switch op {
+	case "= ?", "=?", "<> ?", "<>?", "!= ?", "!=?", "IN ?", "IN (?)":
+	default:
+		return e
+	}
+	table, name := "", strings.Trim(column, "`\"")
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		table, name = strings.Trim(name[:i], "`\""), strings.Trim(name[i+1:], "`\"")
+	}
+	index, ok := indexes[name]
+	if !ok {
+		return e
+	}
+	col := clause.Column{Table: table, Name: index}
+	if strings.HasPrefix(op, "IN") {
+		rv := reflect.ValueOf(e.Vars[0])
+		if rv.Kind() != reflect.Slice {
+			return e
+		}
+		values := make([]interface{}, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			values = append(values, ep.indexValue(rv.Index(i).Interface()))
+		}
+		return clause.IN{Column: col, Values: values}
+	}
+	if strings.HasPrefix(op, "=") {
+		return clause.Eq{Column: col, Value: ep.indexValue(e.Vars[0])}
+	}
+	return clause.Neq{Column: col, Value: ep.indexValue(e.Vars[0])}
+}
+
+func (ep *EncryptPlugin) indexValue(v interface{}) interface{} {
+	if s, ok := stringValue(v); ok {
+		return ep.BlindIndex(s)
+	}
+	return v
+}
+
+// indexColumn 加密字段对应的盲索引列
+func indexColumn(column interface{}, indexes map[string]string) (clause.Column, bool) {
+	var col clause.Column
+	switch c := column.(type) {
+	case string:
+		col.Name = c
+		if i := strings.LastIndex(c, "."); i >= 0 {
+			col.Table, col.Name = c[:i], c[i+1:]
+		}
+	case clause.Column:
+		col = c
+	default:
+		return col, false
+	}
+	index, ok := indexes[col.Name]
+	col.Name = index
+	return col, ok
+}
+
+// stringValue 取 string / *string 的值
+func stringValue(v interface{}) (string, bool) {
+	switch s := v.(type) {
+	case string:
+		return s, true
+	case *string:
+		if s != nil {
+			return *s, true
+		}
+	}
+	return "", false
+}
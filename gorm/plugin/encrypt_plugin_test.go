@@ -0,0 +1,175 @@
+package plugin_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ovra-cloud/ovra-toolkit/gorm/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Customer struct {
+	ID         int64 `gorm:"primaryKey"`
+	Name       string
+	Phone      string  `encrypt:"index:PhoneIndex"`
+	PhoneIndex string  `gorm:"size:64"`
+	IDCard     *string `encrypt:"true"`
+}
+
+var (
+	testKey1 = []byte("0123456789abcdef0123456789abcdef")
+	testKey2 = []byte("fedcba9876543210")
+)
+
+func TestEncryptPlugin(t *testing.T) {
+	ep := &plugin.EncryptPlugin{
+		Keys:       map[string][]byte{"k1": testKey1},
+		CurrentKey: "k1",
+		IndexKey:   []byte("index-key"),
+	}
+	db, rec := newSQLiteDB(t, ep)
+	require.NoError(t, db.AutoMigrate(&Customer{}))
+
+	raw := func(id int64) (phone, index string, idCard *string) {
+		row := db.Table("customers").Select("phone", "phone_index", "id_card").Where("id = ?", id).Row()
+		require.NoError(t, row.Scan(&phone, &index, &idCard))
+		return
+	}
+
+	idCard := "110101199001011234"
+	c := Customer{ID: 1, Name: "a", Phone: "13800000001", IDCard: &idCard}
+	require.NoError(t, db.Create(&c).Error)
+	require.NoError(t, db.Create(&[]Customer{{ID: 2, Phone: "13800000002"}, {ID: 3}}).Error)
+
+	t.Run("写入加密", func(t *testing.T) {
+		assert.Equal(t, "13800000001", c.Phone)
+		assert.Equal(t, idCard, *c.IDCard)
+
+		phone, index, card := raw(1)
+		assert.True(t, strings.HasPrefix(phone, "$enc$k1$"), phone)
+		assert.Equal(t, ep.BlindIndex("13800000001"), index)
+		assert.Len(t, index, 64)
+		require.NotNil(t, card)
+		assert.True(t, strings.HasPrefix(*card, "$enc$k1$"))
+		assert.NotContains(t, rec.All(), "13800000001")
+
+		phone, index, card = raw(3)
+		assert.Empty(t, phone)
+		assert.Empty(t, index)
+		assert.Nil(t, card)
+	})
+
+	t.Run("查询解密与条件改写", func(t *testing.T) {
+		var got Customer
+		require.NoError(t, db.Where("phone = ?", "13800000001").First(&got).Error)
+		assert.Equal(t, int64(1), got.ID)
+		assert.Equal(t, "13800000001", got.Phone)
+		assert.Equal(t, idCard, *got.IDCard)
+		assert.Contains(t, rec.Last(), "`phone_index` = \""+ep.BlindIndex("13800000001")+"\"")
+
+		var list []Customer
+		require.NoError(t, db.Where("phone IN ?", []string{"13800000001", "13800000002"}).Order("id").Find(&list).Error)
+		require.Len(t, list, 2)
+		assert.Equal(t, "13800000002", list[1].Phone)
+
+		list = nil
+		require.NoError(t, db.Where(&Customer{Phone: "13800000002"}).Find(&list).Error)
+		require.Len(t, list, 1)
+		assert.Equal(t, int64(2), list[0].ID)
+
+		var count int64
+		require.NoError(t, db.Model(&Customer{}).Where(map[string]interface{}{"phone": "13800000001"}).Count(&count).Error)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("更新", func(t *testing.T) {
+		require.NoError(t, db.Model(&c).Update("phone", "13900000001").Error)
+		assert.Equal(t, "13900000001", c.Phone)
+		phone, index, _ := raw(1)
+		assert.True(t, strings.HasPrefix(phone, "$enc$k1$"))
+		assert.Equal(t, ep.BlindIndex("13900000001"), index)
+
+		c.Name = "b"
+		require.NoError(t, db.Save(&c).Error)
+		assert.Equal(t, "13900000001", c.Phone)
+
+		require.NoError(t, db.Model(&Customer{ID: 2}).Select("phone").Updates(Customer{Phone: "13900000002"}).Error)
+		var got Customer
+		require.NoError(t, db.Where("phone = ?", "13900000002").First(&got).Error)
+		assert.Equal(t, int64(2), got.ID)
+
+		require.NoError(t, db.Where("phone = ?", "13900000002").Delete(&Customer{}).Error)
+		assert.Error(t, db.First(&got, 2).Error)
+	})
+
+	t.Run("历史明文与篡改", func(t *testing.T) {
+		require.NoError(t, db.Exec("INSERT INTO customers (id, phone) VALUES (10, '13700000000')").Error)
+		var got Customer
+		require.NoError(t, db.First(&got, 10).Error)
+		assert.Equal(t, "13700000000", got.Phone)
+
+		require.NoError(t, db.Exec("UPDATE customers SET phone = '$enc$k1$AAAA' WHERE id = 10").Error)
+		assert.ErrorIs(t, db.First(&got, 10).Error, plugin.ErrDecrypt)
+	})
+
+	t.Run("前缀相同的明文", func(t *testing.T) {
+		fake := "$enc$k1$AAAA"
+		require.NoError(t, db.Create(&Customer{ID: 20, Phone: fake}).Error)
+		phone, index, _ := raw(20)
+		assert.NotEqual(t, fake, phone)
+		assert.Equal(t, ep.BlindIndex(fake), index)
+		require.NoError(t, db.Model(&Customer{}).Create(map[string]interface{}{"id": 21, "phone": fake}).Error)
+		phone, index, _ = raw(21)
+		assert.NotEqual(t, fake, phone)
+		assert.Equal(t, ep.BlindIndex(fake), index)
+
+		var got Customer
+		require.NoError(t, db.Where("phone = ?", fake).First(&got).Error)
+		assert.Equal(t, int64(20), got.ID)
+		assert.Equal(t, fake, got.Phone)
+
+		// 提交他人的密文时按明文加密, 读取到的仍是提交的密文
+		enc, err := ep.Encrypt("13600000000")
+		require.NoError(t, err)
+		require.NoError(t, db.Create(&Customer{ID: 22, Phone: enc}).Error)
+		phone, index, _ = raw(22)
+		assert.NotEqual(t, enc, phone)
+		assert.Equal(t, ep.BlindIndex(enc), index)
+		var stored Customer
+		require.NoError(t, db.First(&stored, 22).Error)
+		assert.Equal(t, enc, stored.Phone)
+	})
+
+	t.Run("保存时重算盲索引", func(t *testing.T) {
+		require.NoError(t, db.Exec("INSERT INTO customers (id, phone) VALUES (30, '13500000000')").Error)
+		var got Customer
+		require.NoError(t, db.First(&got, 30).Error)
+		require.NoError(t, db.Save(&got).Error)
+		phone, index, _ := raw(30)
+		assert.True(t, strings.HasPrefix(phone, "$enc$k1$"))
+		assert.Equal(t, ep.BlindIndex("13500000000"), index)
+
+		require.NoError(t, db.Where("phone = ?", "13500000000").First(&got).Error)
+		assert.Equal(t, int64(30), got.ID)
+	})
+
+	t.Run("密钥轮换", func(t *testing.T) {
+		rotated := &plugin.EncryptPlugin{
+			Keys:       map[string][]byte{"k1": testKey1, "k2": testKey2},
+			CurrentKey: "k2",
+		}
+		newSQLiteDB(t, rotated)
+		old, err := ep.Encrypt("secret")
+		require.NoError(t, err)
+		plain, err := rotated.Decrypt(old)
+		require.NoError(t, err)
+		assert.Equal(t, "secret", plain)
+
+		enc, err := rotated.Encrypt("secret")
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(enc, "$enc$k2$"))
+		_, err = ep.Decrypt(enc)
+		assert.ErrorIs(t, err, plugin.ErrDecrypt)
+	})
+}
@@ -0,0 +1,94 @@
+package plugin_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newDryRunDB 创建不连接数据库的 MySQL DryRun 实例, 用于校验生成的 SQL
+func newDryRunDB(t *testing.T, plugins ...gorm.Plugin) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		DSN:                       "root:root@tcp(127.0.0.1:3306)/dry_run",
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+		Logger:               logger.Discard,
+	})
+	if err != nil {
+		t.Fatalf("failed to open dry run db: %v", err)
+	}
+	for _, p := range plugins {
+		if err = db.Use(p); err != nil {
+			t.Fatalf("failed to use plugin %s: %v", p.Name(), err)
+		}
+	}
+	return db
+}
+
+// newSQLiteDB 创建内存 SQLite 实例, 并记录执行的 SQL
+func newSQLiteDB(t *testing.T, plugins ...gorm.Plugin) (*gorm.DB, *sqlRecorder) {
+	t.Helper()
+	rec := &sqlRecorder{}
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: rec})
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	for _, p := range plugins {
+		if err = db.Use(p); err != nil {
+			t.Fatalf("failed to use plugin %s: %v", p.Name(), err)
+		}
+	}
+	return db, rec
+}
+
+// sqlRecorder 记录执行过的 SQL
+type sqlRecorder struct {
+	mu   sync.Mutex
+	sqls []string
+}
+
+func (r *sqlRecorder) LogMode(logger.LogLevel) logger.Interface      { return r }
+func (r *sqlRecorder) Info(context.Context, string, ...interface{})  {}
+func (r *sqlRecorder) Warn(context.Context, string, ...interface{})  {}
+func (r *sqlRecorder) Error(context.Context, string, ...interface{}) {}
+func (r *sqlRecorder) Trace(_ context.Context, _ time.Time, fc func() (string, int64), _ error) {
+	sql, _ := fc()
+	r.mu.Lock()
+	r.sqls = append(r.sqls, sql)
+	r.mu.Unlock()
+}
+
+// Reset 清空记录
+func (r *sqlRecorder) Reset() {
+	r.mu.Lock()
+	r.sqls = nil
+	r.mu.Unlock()
+}
+
+// Last 返回最后一条 SQL
+func (r *sqlRecorder) Last() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.sqls) == 0 {
+		return ""
+	}
+	return r.sqls[len(r.sqls)-1]
+}
+
+// All 返回全部 SQL
+func (r *sqlRecorder) All() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.sqls...)
+}
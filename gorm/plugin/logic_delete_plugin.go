@@ -0,0 +1,257 @@
+package plugin
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	DefaultDelFlagColumn = "del_flag" // 默认删除标志列
+	DelFlagNormal        = "0"        // 默认正常标志
+	DelFlagDeleted       = "2"        // 默认删除标志
+)
+
+// LogicDeletePlugin 逻辑删除插件, 适用于含删除标志列的模型
+//
+// Delete 转换为更新删除标志并填充删除人、删除时间; 查询、更新自动排除已删除数据,
+// Save 回退的 upsert 不修改删除标志, 也不覆盖已删除数据.
+// 使用 db.Unscoped() 时不做处理, 可用于物理删除和回收站查询, 恢复数据使用 Restore
+type LogicDeletePlugin struct {
+	Column       string // 删除标志列, 默认 del_flag
+	NormalValue  string // 正常标志, 默认 "0"
+	DeletedValue string // 删除标志, 默认 "2"
+	DeleteBy     string // 删除人字段, 默认 DeleteBy, 模型不含该字段时不填充
+	DeleteTime   string // 删除时间字段, 默认 DeleteTime, 支持 time.Time 与 Unix 秒
+	IgnoreTables []string
+}
+
+func (lp *LogicDeletePlugin) Name() string {
+	return "LogicDeletePlugin"
+}
+
+func (lp *LogicDeletePlugin) Initialize(db *gorm.DB) error {
+	lp.setDefaults()
+
+	// ===== Query / Row =====
+	if err := db.Callback().Query().Before("gorm:query").
+		Register("logic_delete:query", lp.filterDeleted); err != nil {
+		return err
+	}
+	if err := db.Callback().Row().Before("gorm:row").
+		Register("logic_delete:row", lp.filterDeleted); err != nil {
+		return err
+	}
+	// ===== Update =====
+	if err := db.Callback().Update().Before("gorm:update").
+		Register("logic_delete:update", lp.filterDeleted); err != nil {
+		return err
+	}
+	db.ClauseBuilders["ON CONFLICT"] = lp.buildOnConflict(db.ClauseBuilders["ON CONFLICT"])
+	// ===== Delete =====
+	// 替换 gorm:delete, 保证在其他插件追加租户、数据权限条件之后再生成语句
+	hardDelete := db.Callback().Delete().Get("gorm:delete")
+	return db.Callback().Delete().Replace("gorm:delete", func(db *gorm.DB) {
+		if field := lp.flagField(db); field != nil && db.Error == nil {
+			lp.softDelete(db, field)
+			return
+		}
+		hardDelete(db)
+	})
+}
+
+// Restore 恢复逻辑删除的数据, 用法 lp.Restore(db, &SysUser{}, "user_id = ?", 1)
+func (lp *LogicDeletePlugin) Restore(db *gorm.DB, model interface{}, conds ...interface{}) *gorm.DB {
+	lp.setDefaults()
+	tx := db.Unscoped().Model(model).Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: lp.Column}, Value: lp.DeletedValue})
+	if len(conds) > 0 {
+		tx = tx.Where(conds[0], conds[1:]...)
+	}
+	return tx.Update(lp.Column, lp.NormalValue)
+}
+
+func (lp *LogicDeletePlugin) setDefaults() {
+	if lp.Column == "" {
+		lp.Column = DefaultDelFlagColumn
+	}
+	if lp.NormalValue == "" {
+		lp.NormalValue = DelFlagNormal
+	}
+	if lp.DeletedValue == "" {
+		lp.DeletedValue = DelFlagDeleted
+	}
+	if lp.DeleteBy == "" {
+		lp.DeleteBy = "DeleteBy"
+	}
+	if lp.DeleteTime == "" {
+		lp.DeleteTime = "DeleteTime"
+	}
+}
+
+// flagField 返回当前语句的删除标志字段, 未使用逻辑删除时返回 nil
+func (lp *LogicDeletePlugin) flagField(db *gorm.DB) *schema.Field {
+	stmt := db.Statement
+	if stmt == nil || stmt.Unscoped || stmt.Schema == nil || stmt.Schema.Table != stmt.Table {
+		return nil
+	}
+	for _, t := range lp.IgnoreTables {
+		if strings.EqualFold(t, stmt.Table) {
+			return nil
+		}
+	}
+	return stmt.Schema.LookUpField(lp.Column)
+}
+
+// filterDeleted 查询、更新排除已删除数据
+func (lp *LogicDeletePlugin) filterDeleted(db *gorm.DB) {
+	if db.Statement.SQL.Len() > 0 {
+		return
+	}
+	if field := lp.flagField(db); field != nil {
+		addWhereIfAbsent(db, field.DBName, lp.NormalValue)
+	}
+	lp.filterJoins(db)
+}
+
+// filterJoins 关联 Joins 的关联链均使用逻辑删除时, 在 JOIN ON 中排除已删除数据
+func (lp *LogicDeletePlugin) filterJoins(db *gorm.DB) {
+	stmt := db.Statement
+	if stmt.Unscoped || len(stmt.Joins) == 0 {
+		return
+	}
+	if v, ok := stmt.Settings.Load("logic_delete:joins"); ok && v == &stmt.Joins[0] {
+		return
+	}
+	stmt.Settings.Store("logic_delete:joins", &stmt.Joins[0])
+
+	for i := range stmt.Joins {
+		join := &stmt.Joins[i]
+		rels := joinRelations(stmt.Schema, join.Name)
+		if join.Expression != nil || rels == nil {
+			continue
+		}
+		all := true
+		for _, rel := range rels {
+			all = all && rel.FieldSchema.LookUpField(lp.Column) != nil
+		}
+		if !all {
+			continue
+		}
+		cond := clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: lp.Column}, Value: lp.NormalValue}
+		on := &clause.Where{Exprs: []clause.Expression{cond}}
+		if join.On != nil {
+			on.Exprs = append(append([]clause.Expression{}, join.On.Exprs...), cond)
+		}
+		join.On = on
+	}
+}
+
+// buildOnConflict 限制 upsert 只更新未删除数据
+//
+// Save 更新已删除数据时影响行数为 0, GORM 会回退为 INSERT ... ON CONFLICT 并覆盖全部列,
+// 这里移除删除标志的更新, 并在冲突行已删除时保持原值, 避免恢复已删除数据
+func (lp *LogicDeletePlugin) buildOnConflict(next clause.ClauseBuilder) clause.ClauseBuilder {
+	return func(c clause.Clause, builder clause.Builder) {
+		if stmt, ok := builder.(*gorm.Statement); ok && stmt.DB != nil {
+			if onConflict, ok := c.Expression.(clause.OnConflict); ok && !onConflict.DoNothing {
+				if field := lp.flagField(stmt.DB); field != nil {
+					c.Expression = lp.guardOnConflict(stmt, field, onConflict)
+				}
+			}
+		}
+		if next != nil {
+			next(c, builder)
+			return
+		}
+		c.Build(builder)
+	}
+}
+
+func (lp *LogicDeletePlugin) guardOnConflict(stmt *gorm.Statement, flag *schema.Field, onConflict clause.OnConflict) clause.OnConflict {
+	onConflict.DoUpdates = withoutAssignments(onConflict.DoUpdates, []string{flag.DBName, flag.Name})
+	if stmt.Dialector.Name() == "mysql" {
+		// ON DUPLICATE KEY UPDATE 不支持 WHERE, 冲突行已删除时保持原值
+		flagCol := clause.Column{Name: flag.DBName}
+		for i, a := range onConflict.DoUpdates {
+			current := clause.Column{Name: a.Column.Name}
+			if c, ok := a.Value.(clause.Column); ok && c.Table == "excluded" {
+				a.Value = clause.Expr{SQL: "IF(? = ?, VALUES(?), ?)", Vars: []interface{}{flagCol, lp.NormalValue, current, current}}
+			} else {
+				a.Value = clause.Expr{SQL: "IF(? = ?, ?, ?)", Vars: []interface{}{flagCol, lp.NormalValue, a.Value, current}}
+			}
+			onConflict.DoUpdates[i] = a
+		}
+		return onConflict
+	}
+	if len(onConflict.DoUpdates) == 0 {
+		onConflict.DoNothing = true
+		return onConflict
+	}
+	onConflict.Where.Exprs = append(append([]clause.Expression{}, onConflict.Where.Exprs...),
+		clause.Eq{Column: clause.Column{Table: stmt.Table, Name: flag.DBName}, Value: lp.NormalValue})
+	return onConflict
+}
+
+// softDelete 将删除转换为更新删除标志
+func (lp *LogicDeletePlugin) softDelete(db *gorm.DB, flag *schema.Field) {
+	stmt := db.Statement
+	if exprs := primaryKeyExprs(stmt); len(exprs) > 0 {
+		stmt.AddClause(clause.Where{Exprs: exprs})
+	}
+	if _, ok := stmt.Clauses["WHERE"]; !db.AllowGlobalUpdate && !ok {
+		_ = db.AddError(gorm.ErrMissingWhereClause)
+		return
+	}
+
+	set := clause.Set{{Column: clause.Column{Name: flag.DBName}, Value: lp.DeletedValue}}
+	if userID, ok := getUserID(db); ok && userID != "" {
+		if field := stmt.Schema.LookUpField(lp.DeleteBy); field != nil && field.DBName != "" {
+			set = append(set, clause.Assignment{Column: clause.Column{Name: field.DBName}, Value: idValue(field, userID)})
+		}
+	}
+	if field := stmt.Schema.LookUpField(lp.DeleteTime); field != nil && field.DBName != "" {
+		now := db.NowFunc()
+		var value interface{} = now
+		if t := field.IndirectFieldType; t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64 {
+			value = now.Unix()
+		} else if t != reflect.TypeOf(time.Time{}) {
+			value = nil
+		}
+		if value != nil {
+			set = append(set, clause.Assignment{Column: clause.Column{Name: field.DBName}, Value: value})
+		}
+	}
+	addWhereIfAbsent(db, flag.DBName, lp.NormalValue)
+
+	stmt.AddClauseIfNotExists(clause.Update{})
+	stmt.AddClause(set)
+	stmt.Build("UPDATE", "SET", "WHERE")
+	if db.DryRun || db.Error != nil {
+		return
+	}
+	result, err := stmt.ConnPool.ExecContext(stmt.Context, stmt.SQL.String(), stmt.Vars...)
+	if db.AddError(err) == nil {
+		db.RowsAffected, _ = result.RowsAffected()
+	}
+}
+
+// primaryKeyExprs 与 gorm:delete 一致, 按模型主键生成条件
+func primaryKeyExprs(stmt *gorm.Statement) []clause.Expression {
+	var exprs []clause.Expression
+	add := func(rv reflect.Value) {
+		_, queryValues := schema.GetIdentityFieldValuesMap(stmt.Context, rv, stmt.Schema.PrimaryFields)
+		column, values := schema.ToQueryValues(stmt.Table, stmt.Schema.PrimaryFieldDBNames, queryValues)
+		if len(values) > 0 {
+			exprs = append(exprs, clause.IN{Column: column, Values: values})
+		}
+	}
+	add(stmt.ReflectValue)
+	if stmt.ReflectValue.CanAddr() && stmt.Dest != stmt.Model && stmt.Model != nil {
+		add(reflect.ValueOf(stmt.Model))
+	}
+	return exprs
+}
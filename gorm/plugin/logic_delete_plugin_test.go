@@ -0,0 +1,125 @@
+package plugin_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+	"github.com/ovra-cloud/ovra-toolkit/gorm/plugin"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type Notify struct {
+	ID         int64
+	Title      string
+	TenantID   string
+	TypeID     int64
+	Type       NotifyType
+	DelFlag    string `gorm:"default:0"`
+	DeleteBy   int64
+	DeleteTime *time.Time
+}
+
+type NotifyType struct {
+	ID      int64
+	Name    string
+	DelFlag string `gorm:"default:0"`
+}
+
+func TestLogicDeletePlugin(t *testing.T) {
+	lp := &plugin.LogicDeletePlugin{}
+	db, rec := newSQLiteDB(t, lp, &plugin.TenantPlugin{Enabled: true})
+	require.NoError(t, db.AutoMigrate(&NotifyType{}, &Notify{}))
+
+	ctx := context.WithValue(context.Background(), auth.TenantIDKey, "1")
+	ctx = context.WithValue(ctx, auth.UserIDKey, "9")
+	require.NoError(t, db.WithContext(ctx).Create(&[]NotifyType{{ID: 1, Name: "t1"}, {ID: 2, Name: "t2"}}).Error)
+	require.NoError(t, db.WithContext(ctx).Create(&[]Notify{
+		{ID: 1, Title: "a", TypeID: 1}, {ID: 2, Title: "b", TypeID: 2}, {ID: 3, Title: "c", TypeID: 1},
+	}).Error)
+
+	t.Run("删除转换为更新", func(t *testing.T) {
+		res := db.WithContext(ctx).Delete(&Notify{ID: 1})
+		require.NoError(t, res.Error)
+		assert.Equal(t, int64(1), res.RowsAffected)
+		assert.Contains(t, rec.Last(), "UPDATE `notifies` SET `del_flag`=\"2\",`delete_by`=9,`delete_time`=")
+		assert.Contains(t, rec.Last(), "`notifies`.`tenant_id` = \"1\"")
+
+		var n Notify
+		require.NoError(t, db.WithContext(ctx).Unscoped().First(&n, 1).Error)
+		assert.Equal(t, "2", n.DelFlag)
+		assert.Equal(t, int64(9), n.DeleteBy)
+		assert.NotNil(t, n.DeleteTime)
+
+		assert.ErrorIs(t, db.Delete(&Notify{}).Error, gorm.ErrMissingWhereClause)
+	})
+
+	t.Run("查询排除已删除数据", func(t *testing.T) {
+		var list []Notify
+		require.NoError(t, db.WithContext(ctx).Find(&list).Error)
+		assert.Len(t, list, 2)
+
+		var count int64
+		require.NoError(t, db.WithContext(ctx).Unscoped().Model(&Notify{}).Count(&count).Error)
+		assert.Equal(t, int64(3), count)
+
+		// 已删除数据不可更新
+		res := db.WithContext(ctx).Model(&Notify{ID: 1}).Update("title", "x")
+		require.NoError(t, res.Error)
+		assert.Zero(t, res.RowsAffected)
+	})
+
+	t.Run("Save 不恢复已删除数据", func(t *testing.T) {
+		require.NoError(t, db.WithContext(ctx).Save(&Notify{ID: 1, Title: "x", TypeID: 1, DelFlag: "0"}).Error)
+		assert.Contains(t, rec.Last(), "ON CONFLICT")
+		assert.Contains(t, rec.Last(), "`notifies`.`del_flag` = \"0\"")
+		assert.NotContains(t, rec.Last(), "`del_flag`=`excluded`.`del_flag`")
+
+		var n Notify
+		require.NoError(t, db.WithContext(ctx).Unscoped().First(&n, 1).Error)
+		assert.Equal(t, "2", n.DelFlag)
+		assert.Equal(t, "a", n.Title)
+
+		require.NoError(t, db.WithContext(ctx).Save(&Notify{ID: 4, Title: "d", TypeID: 1, DelFlag: "0"}).Error)
+		var created Notify
+		require.NoError(t, db.WithContext(ctx).First(&created, 4).Error)
+		assert.Equal(t, "d", created.Title)
+		require.NoError(t, db.WithContext(ctx).Unscoped().Delete(&Notify{ID: 4}).Error)
+	})
+
+	t.Run("关联 Joins", func(t *testing.T) {
+		require.NoError(t, db.WithContext(ctx).Delete(&NotifyType{ID: 2}).Error)
+		var list []Notify
+		require.NoError(t, db.WithContext(ctx).Joins("Type").Order("notifies.id").Find(&list).Error)
+		require.Len(t, list, 2)
+		assert.Empty(t, list[0].Type.Name)
+		assert.Equal(t, "t1", list[1].Type.Name)
+	})
+
+	t.Run("恢复与物理删除", func(t *testing.T) {
+		require.NoError(t, lp.Restore(db.WithContext(ctx), &Notify{}, "id = ?", 1).Error)
+		var n Notify
+		require.NoError(t, db.WithContext(ctx).First(&n, 1).Error)
+		assert.Equal(t, "0", n.DelFlag)
+
+		require.NoError(t, db.WithContext(ctx).Unscoped().Delete(&Notify{ID: 3}).Error)
+		assert.Contains(t, rec.Last(), "DELETE FROM `notifies`")
+		var count int64
+		require.NoError(t, db.WithContext(ctx).Unscoped().Model(&Notify{}).Count(&count).Error)
+		assert.Equal(t, int64(2), count)
+	})
+}
+
+func TestLogicDeletePluginIgnoreTables(t *testing.T) {
+	db, rec := newSQLiteDB(t, &plugin.LogicDeletePlugin{IgnoreTables: []string{"Notify_Types"}})
+	require.NoError(t, db.AutoMigrate(&NotifyType{}))
+	require.NoError(t, db.Create(&NotifyType{ID: 1, Name: "t1"}).Error)
+
+	// 表名不区分大小写
+	require.NoError(t, db.Delete(&NotifyType{ID: 1}).Error)
+	assert.Contains(t, rec.Last(), "DELETE FROM `notify_types`")
+}
@@ -0,0 +1,41 @@
+package plugin
+
+import (
+	"github.com/ovra-cloud/ovra-toolkit/mask"
+	"gorm.io/gorm"
+)
+
+const maskSetting = "plugin:mask"
+
+// MaskPlugin 查询后按 mask 标签对结果脱敏
+//
+// 脱敏后的值写回数据库会覆盖原数据, 默认仅对 db.Scopes(plugin.WithMask) 的查询脱敏,
+// 只读场景可开启 Always
+type MaskPlugin struct {
+	Permission string // 查看明文的权限, 拥有该权限或 *:*:* 时不脱敏
+	Always     bool   // 对所有查询脱敏
+}
+
+// WithMask 对当前查询结果脱敏, 用法 db.Scopes(plugin.WithMask)
+func WithMask(db *gorm.DB) *gorm.DB {
+	return db.Set(maskSetting, true)
+}
+
+func (mp *MaskPlugin) Name() string {
+	return "MaskPlugin"
+}
+
+func (mp *MaskPlugin) Initialize(db *gorm.DB) error {
+	return db.Callback().Query().After("gorm:query").
+		Register("mask:query", mp.maskResult)
+}
+
+func (mp *MaskPlugin) maskResult(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Dest == nil {
+		return
+	}
+	if v, ok := db.Get(maskSetting); !mp.Always && (!ok || v != true) {
+		return
+	}
+	mask.Apply(db.Statement.Context, db.Statement.Dest, mp.Permission)
+}
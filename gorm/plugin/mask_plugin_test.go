@@ -0,0 +1,41 @@
+package plugin_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+	"github.com/ovra-cloud/ovra-toolkit/gorm/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Staff struct {
+	ID    int64 `gorm:"primaryKey"`
+	Name  string
+	Phone string `mask:"phone"`
+}
+
+func TestMaskPlugin(t *testing.T) {
+	db, _ := newSQLiteDB(t, &plugin.MaskPlugin{Permission: "system:staff:phone"})
+	require.NoError(t, db.AutoMigrate(&Staff{}))
+	require.NoError(t, db.Create(&Staff{ID: 1, Name: "a", Phone: "13812345678"}).Error)
+
+	var s Staff
+	require.NoError(t, db.First(&s, 1).Error)
+	assert.Equal(t, "13812345678", s.Phone, "默认不脱敏")
+
+	var list []Staff
+	require.NoError(t, db.Scopes(plugin.WithMask).Find(&list).Error)
+	assert.Equal(t, "138****5678", list[0].Phone)
+
+	ctx := context.WithValue(context.Background(), auth.PermissionsKey, []string{"system:staff:phone"})
+	require.NoError(t, db.WithContext(ctx).Scopes(plugin.WithMask).First(&s, 1).Error)
+	assert.Equal(t, "13812345678", s.Phone)
+
+	always, _ := newSQLiteDB(t, &plugin.MaskPlugin{Always: true})
+	require.NoError(t, always.AutoMigrate(&Staff{}))
+	require.NoError(t, always.Create(&Staff{ID: 1, Phone: "13812345678"}).Error)
+	require.NoError(t, always.First(&s, 1).Error)
+	assert.Equal(t, "138****5678", s.Phone)
+}
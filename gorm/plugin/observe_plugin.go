@@ -0,0 +1,304 @@
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/metric"
+	"github.com/zeromicro/go-zero/core/trace"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultSlowThreshold = 500 * time.Millisecond // 默认慢语句阈值
+
+	observeStart = "observe:start"
+	observeSpan  = "observe:span"
+	observePool  = "observe:pool"
+)
+
+var (
+	metricGormDur = metric.NewHistogramVec(&metric.HistogramVecOpts{
+		Namespace: "gorm_client",
+		Subsystem: "requests",
+		Name:      "duration_ms",
+		Help:      "gorm client requests duration(ms).",
+		Labels:    []string{"table", "operation"},
+		Buckets:   []float64{0.25, 0.5, 1, 1.5, 2, 3, 5, 10, 25, 50, 100, 250, 500, 1000, 2000, 5000, 10000, 15000},
+	})
+	metricGormErr = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: "gorm_client",
+		Subsystem: "requests",
+		Name:      "error_total",
+		Help:      "gorm client requests error count.",
+		Labels:    []string{"table", "operation"},
+	})
+	metricGormSlow = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: "gorm_client",
+		Subsystem: "requests",
+		Name:      "slow_total",
+		Help:      "gorm client requests slow count.",
+		Labels:    []string{"table", "operation"},
+	})
+
+	// 注释中的值仅保留安全字符, 防止提前闭合注释
+	commentUnsafe = regexp.MustCompile(`[^0-9A-Za-z_.:@-]`)
+	// 慢语句中的字符串、数字字面量替换为 ?
+	sqlLiteral = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'|\b\d+(?:\.\d+)?\b`)
+)
+
+// SlowStatement 慢语句记录, SQL 中的参数已脱敏
+type SlowStatement struct {
+	Table     string
+	Operation string
+	SQL       string
+	Duration  time.Duration
+	Rows      int64
+	TenantId  string
+	UserId    string
+	RequestId string
+	Err       error
+}
+
+// ObservePlugin SQL 观测插件, 统计每条语句的耗时
+//
+// Comment 在 SQL 前追加 /* tenant=.. user=.. req=.. */ 注释, 便于在数据库慢日志中定位来源,
+// 使用 PrepareStmt 时不追加; Trace 为每条语句创建 OpenTelemetry span;
+// Metrics 按表名和操作类型输出 Prometheus 指标; 超过 SlowThreshold 的语句输出到 SlowLog
+type ObservePlugin struct {
+	Comment       bool
+	Trace         bool
+	Metrics       bool
+	SlowThreshold time.Duration                              // 慢语句阈值, 默认 500ms, 小于 0 时不记录
+	SlowLog       func(ctx context.Context, s SlowStatement) // 慢语句输出, 默认 logx Slowf
+}
+
+func (op *ObservePlugin) Name() string {
+	return "ObservePlugin"
+}
+
+func (op *ObservePlugin) Initialize(db *gorm.DB) error {
+	if op.SlowThreshold == 0 {
+		op.SlowThreshold = DefaultSlowThreshold
+	}
+	if op.SlowLog == nil {
+		op.SlowLog = logSlowStatement
+	}
+
+	type processor interface {
+		Register(name string, fn func(*gorm.DB)) error
+	}
+	callback := db.Callback()
+	for _, p := range []struct {
+		operation string
+		before    processor
+		comment   processor
+		restore   processor
+		after     processor
+	}{
+		{"create", callback.Create().Before("*"), callback.Create().After("gorm:begin_transaction").Before("gorm:create"),
+			callback.Create().After("gorm:create").Before("gorm:commit_or_rollback_transaction"), callback.Create().After("*")},
+		{"query", callback.Query().Before("*"), callback.Query().Before("gorm:query"),
+			callback.Query().After("gorm:query"), callback.Query().After("*")},
+		{"update", callback.Update().Before("*"), callback.Update().After("gorm:begin_transaction").Before("gorm:update"),
+			callback.Update().After("gorm:update").Before("gorm:commit_or_rollback_transaction"), callback.Update().After("*")},
+		{"delete", callback.Delete().Before("*"), callback.Delete().After("gorm:begin_transaction").Before("gorm:delete"),
+			callback.Delete().After("gorm:delete").Before("gorm:commit_or_rollback_transaction"), callback.Delete().After("*")},
+		{"row", callback.Row().Before("*"), callback.Row().Before("gorm:row"),
+			callback.Row().After("gorm:row"), callback.Row().After("*")},
+		{"raw", callback.Raw().Before("*"), callback.Raw().Before("gorm:raw"),
+			callback.Raw().After("gorm:raw"), callback.Raw().After("*")},
+	} {
+		operation := p.operation
+		if err := p.before.Register("observe:before", func(db *gorm.DB) { op.before(db, operation) }); err != nil {
+			return err
+		}
+		if op.Comment {
+			if err := p.comment.Register("observe:comment", op.wrapPool); err != nil {
+				return err
+			}
+			if err := p.restore.Register("observe:restore", restorePool); err != nil {
+				return err
+			}
+		}
+		if err := p.after.Register("observe:after", func(db *gorm.DB) { op.after(db, operation) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (op *ObservePlugin) before(db *gorm.DB, operation string) {
+	db.InstanceSet(observeStart, time.Now())
+	if !op.Trace {
+		return
+	}
+	stmt := db.Statement
+	ctx, span := trace.TracerFromContext(stmt.Context).Start(stmt.Context, "gorm:"+operation,
+		oteltrace.WithSpanKind(oteltrace.SpanKindClient))
+	stmt.Context = ctx
+	db.InstanceSet(observeSpan, span)
+}
+
+func (op *ObservePlugin) after(db *gorm.DB, operation string) {
+	v, ok := db.InstanceGet(observeStart)
+	if !ok {
+		return
+	}
+	duration := time.Since(v.(time.Time))
+	stmt := db.Statement
+	ctx := stmt.Context
+	table := stmt.Table
+	if table == "" {
+		table = "-"
+	}
+	err := db.Error
+	if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+
+	if span, ok := db.InstanceGet(observeSpan); ok {
+		endObserveSpan(span.(oteltrace.Span), db, table, operation, err)
+	}
+	label := metricTable(table)
+	if op.Metrics {
+		// 按微秒计算, 保留毫秒以下的精度
+		metricGormDur.ObserveFloat(float64(duration.Microseconds())/1000, label, operation)
+		if err != nil {
+			metricGormErr.Inc(label, operation)
+		}
+	}
+	if op.SlowThreshold > 0 && duration > op.SlowThreshold {
+		if op.Metrics {
+			metricGormSlow.Inc(label, operation)
+		}
+		op.SlowLog(ctx, SlowStatement{
+			Table:     stmt.Table,
+			Operation: operation,
+			SQL:       RedactSQL(stmt.SQL.String()),
+			Duration:  duration,
+			Rows:      db.RowsAffected,
+			TenantId:  contextString(ctx, auth.TenantIDKey),
+			UserId:    contextString(ctx, auth.UserIDKey),
+			RequestId: auth.GetRequestId(ctx),
+			Err:       err,
+		})
+	}
+}
+
+// metricTable 去掉表名中的库名（如 tenant_2.biz_order）, 避免指标标签随租户增长
+func metricTable(table string) string {
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		table = table[i+1:]
+	}
+	if t := strings.Trim(table, "`\""); t != "" {
+		return t
+	}
+	return "-"
+}
+
+func endObserveSpan(span oteltrace.Span, db *gorm.DB, table, operation string, err error) {
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("db.system", db.Dialector.Name()),
+		attribute.String("db.sql.table", table),
+		attribute.String("db.operation", operation),
+		attribute.String("db.statement", RedactSQL(db.Statement.SQL.String())),
+		attribute.Int64("db.rows_affected", db.RowsAffected),
+	)
+	if err == nil {
+		span.SetStatus(codes.Ok, "")
+		return
+	}
+	span.SetStatus(codes.Error, err.Error())
+	span.RecordError(err)
+}
+
+func logSlowStatement(ctx context.Context, s SlowStatement) {
+	logx.WithContext(ctx).WithDuration(s.Duration).Slowf("[SQL] %s: slowcall - tenant=%s user=%s req=%s rows=%d - %s",
+		s.Operation, s.TenantId, s.UserId, s.RequestId, s.Rows, s.SQL)
+}
+
+// RedactSQL 将 SQL 中的字符串、数字字面量替换为 ?, 用于输出慢语句
+func RedactSQL(sql string) string {
+	return sqlLiteral.ReplaceAllString(sql, "?")
+}
+
+// sqlComment 根据上下文生成注释, 无身份信息时返回空字符串
+func sqlComment(ctx context.Context) string {
+	var parts []string
+	for _, kv := range [][2]string{
+		{"tenant", contextString(ctx, auth.TenantIDKey)},
+		{"user", contextString(ctx, auth.UserIDKey)},
+		{"req", auth.GetRequestId(ctx)},
+	} {
+		if v := commentUnsafe.ReplaceAllString(kv[1], ""); v != "" {
+			parts = append(parts, kv[0]+"="+v)
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "/* " + strings.Join(parts, " ") + " */ "
+}
+
+func contextString(ctx context.Context, key string) string {
+	v, _ := ctx.Value(key).(string)
+	return v
+}
+
+// commentPool 在执行的 SQL 前追加注释
+type commentPool struct {
+	gorm.ConnPool
+	comment string
+}
+
+func (p *commentPool) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return p.ConnPool.PrepareContext(ctx, p.comment+query)
+}
+
+func (p *commentPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return p.ConnPool.ExecContext(ctx, p.comment+query, args...)
+}
+
+func (p *commentPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return p.ConnPool.QueryContext(ctx, p.comment+query, args...)
+}
+
+func (p *commentPool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return p.ConnPool.QueryRowContext(ctx, p.comment+query, args...)
+}
+
+// wrapPool 仅在执行语句的回调期间替换连接, 不影响事务的开启与提交
+func (op *ObservePlugin) wrapPool(db *gorm.DB) {
+	stmt := db.Statement
+	pool := stmt.ConnPool
+	if p, ok := pool.(*commentPool); ok {
+		pool = p.ConnPool
+	}
+	switch pool.(type) {
+	case *gorm.PreparedStmtDB, *gorm.PreparedStmtTX:
+		return
+	}
+	comment := sqlComment(stmt.Context)
+	if comment == "" {
+		return
+	}
+	db.InstanceSet(observePool, stmt.ConnPool)
+	stmt.ConnPool = &commentPool{ConnPool: pool, comment: comment}
+}
+
+func restorePool(db *gorm.DB) {
+	if pool, ok := db.InstanceGet(observePool); ok {
+		db.Statement.ConnPool = pool.(gorm.ConnPool)
+	}
+}
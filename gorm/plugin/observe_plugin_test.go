@@ -0,0 +1,186 @@
+package plugin_test
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+	"github.com/ovra-cloud/ovra-toolkit/gorm/plugin"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zeromicro/go-zero/core/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gorm.io/gorm"
+)
+
+type Metering struct {
+	ID    string `gorm:"primaryKey"`
+	Name  string
+	Usage int
+}
+
+// execRecorder 记录实际发送到数据库的 SQL, 事务内的连接共用同一份记录
+type execRecorder struct {
+	gorm.ConnPool
+	log *execLog
+}
+
+type execLog struct {
+	mu   sync.Mutex
+	sqls []string
+}
+
+func (r *execRecorder) record(query string) {
+	r.log.mu.Lock()
+	r.log.sqls = append(r.log.sqls, query)
+	r.log.mu.Unlock()
+}
+
+func (r *execRecorder) last() string {
+	r.log.mu.Lock()
+	defer r.log.mu.Unlock()
+	return r.log.sqls[len(r.log.sqls)-1]
+}
+
+func (r *execRecorder) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	r.record(query)
+	return r.ConnPool.ExecContext(ctx, query, args...)
+}
+
+func (r *execRecorder) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	r.record(query)
+	return r.ConnPool.QueryContext(ctx, query, args...)
+}
+
+func (r *execRecorder) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	r.record(query)
+	return r.ConnPool.QueryRowContext(ctx, query, args...)
+}
+
+func (r *execRecorder) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	tx, err := r.ConnPool.(gorm.TxBeginner).BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &execRecorder{ConnPool: tx, log: r.log}, nil
+}
+
+func (r *execRecorder) Commit() error {
+	return r.ConnPool.(gorm.TxCommitter).Commit()
+}
+
+func (r *execRecorder) Rollback() error {
+	return r.ConnPool.(gorm.TxCommitter).Rollback()
+}
+
+func TestObservePlugin(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	var slows []plugin.SlowStatement
+	db, _ := newSQLiteDB(t, &plugin.ObservePlugin{
+		Comment:       true,
+		Trace:         true,
+		Metrics:       true,
+		SlowThreshold: time.Nanosecond,
+		SlowLog:       func(_ context.Context, s plugin.SlowStatement) { slows = append(slows, s) },
+	})
+	require.NoError(t, db.AutoMigrate(&Metering{}))
+	rec := &execRecorder{ConnPool: db.ConnPool, log: &execLog{}}
+	db.ConnPool, db.Statement.ConnPool = rec, rec
+
+	ctx := context.WithValue(context.Background(), auth.TenantIDKey, "t1")
+	ctx = context.WithValue(ctx, auth.UserIDKey, "u1*/ DROP")
+	ctx = context.WithValue(ctx, auth.RequestIDKey, "req-1")
+	tx := db.WithContext(ctx)
+
+	t.Run("SQL 注释", func(t *testing.T) {
+		require.NoError(t, tx.Create(&Metering{ID: "1", Name: "a"}).Error)
+		var m Metering
+		require.NoError(t, tx.First(&m, "id = ?", "1").Error)
+		assert.Contains(t, rec.last(), "/* tenant=t1 user=u1DROP req=req-1 */ SELECT")
+
+		require.NoError(t, tx.Exec("UPDATE meterings SET usage = usage + 1").Error)
+		assert.Contains(t, rec.last(), "/* tenant=t1 user=u1DROP req=req-1 */ UPDATE")
+
+		require.NoError(t, db.First(&m, "id = ?", "1").Error)
+		assert.NotContains(t, rec.last(), "tenant=")
+	})
+
+	t.Run("事务内追加注释", func(t *testing.T) {
+		require.NoError(t, tx.Model(&Metering{ID: "1"}).Update("name", "b").Error)
+		var m Metering
+		require.NoError(t, db.First(&m, "id = ?", "1").Error)
+		assert.Equal(t, "b", m.Name)
+	})
+
+	t.Run("慢语句脱敏", func(t *testing.T) {
+		slows = nil
+		require.NoError(t, tx.Exec("UPDATE meterings SET name = 'secret', usage = 42 WHERE id = ?", "1").Error)
+		require.Len(t, slows, 1)
+		s := slows[0]
+		assert.Equal(t, "raw", s.Operation)
+		assert.Equal(t, "UPDATE meterings SET name = ?, usage = ? WHERE id = ?", s.SQL)
+		assert.Equal(t, "t1", s.TenantId)
+		assert.Equal(t, "req-1", s.RequestId)
+		assert.Equal(t, int64(1), s.Rows)
+	})
+
+	t.Run("指标", func(t *testing.T) {
+		prometheus.Enable()
+		var list []Metering
+		require.NoError(t, tx.Table("main.meterings").Find(&list).Error)
+
+		families, err := prom.DefaultGatherer.Gather()
+		require.NoError(t, err)
+		var found bool
+		for _, f := range families {
+			if f.GetName() != "gorm_client_requests_duration_ms" {
+				continue
+			}
+			for _, m := range f.GetMetric() {
+				labels := map[string]string{}
+				for _, l := range m.GetLabel() {
+					labels[l.GetName()] = l.GetValue()
+				}
+				assert.NotEqual(t, "main.meterings", labels["table"])
+				if labels["table"] == "meterings" && labels["operation"] == "query" {
+					found = true
+					// 毫秒以下的耗时不被截断为 0
+					assert.Greater(t, m.GetHistogram().GetSampleSum(), float64(0))
+				}
+			}
+		}
+		assert.True(t, found)
+	})
+
+	t.Run("链路追踪", func(t *testing.T) {
+		exporter.Reset()
+		var m Metering
+		err := tx.First(&m, "id = ?", "404").Error
+		require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		require.Error(t, tx.Exec("SELECT * FROM missing").Error)
+
+		spans := exporter.GetSpans()
+		require.Len(t, spans, 2)
+		assert.Equal(t, "gorm:query", spans[0].Name)
+		assert.Equal(t, codes.Ok, spans[0].Status.Code)
+		assert.Equal(t, "gorm:raw", spans[1].Name)
+		assert.Equal(t, codes.Error, spans[1].Status.Code)
+	})
+}
+
+func TestRedactSQL(t *testing.T) {
+	assert.Equal(t, "SELECT * FROM t1 WHERE name = ? AND age > ? AND note = ?",
+		plugin.RedactSQL("SELECT * FROM t1 WHERE name = 'it''s' AND age > 18.5 AND note = ?"))
+}
@@ -0,0 +1,254 @@
+package plugin
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/ovra-cloud/ovra-toolkit/errx"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	DefaultVersionField = "Version" // 默认版本字段
+	// VersionTag 版本字段标签, 如 `version:"true"`, 优先于字段名
+	VersionTag = "version"
+
+	versionLock = "optimistic_lock:version"
+)
+
+// ErrVersionConflict 更新时版本号不一致, 数据已被他人修改或已不存在, 使用 errors.Is 判断
+//
+// 插件返回的是包装该错误的 *errx.Error（CodeConflict）, 每次均为新值
+var ErrVersionConflict = errors.New("optimistic lock: version conflict")
+
+func versionConflictErr() error {
+	return errx.New(errx.CodeConflict, "数据已被修改, 请刷新后重试").WithCause(ErrVersionConflict)
+}
+
+// OptimisticLockPlugin 乐观锁插件, 适用于含整数版本字段的模型
+//
+// 创建时版本为空则置为 1; 更新时追加 SET version = version + 1,
+// 更新单条记录且已知版本号时追加 WHERE version = ?, 影响行数为 0 返回 ErrVersionConflict,
+// 成功后回写模型的版本号. Updates(map) 显式指定版本列时不做处理
+type OptimisticLockPlugin struct {
+	Field        string // 版本字段名, 默认 Version
+	IgnoreTables []string
+
+	fields sync.Map // *schema.Schema => *schema.Field
+}
+
+// versionState 当前更新语句的版本信息
+type versionState struct {
+	field    *schema.Field
+	expected int64 // 期望的版本号, 0 表示未知
+}
+
+func (op *OptimisticLockPlugin) Name() string {
+	return "OptimisticLockPlugin"
+}
+
+func (op *OptimisticLockPlugin) Initialize(db *gorm.DB) error {
+	if op.Field == "" {
+		op.Field = DefaultVersionField
+	}
+	// ===== Create =====
+	if err := db.Callback().Create().Before("gorm:create").
+		Register("optimistic_lock:create", op.initVersion); err != nil {
+		return err
+	}
+	// ===== Update =====
+	if err := db.Callback().Update().Before("gorm:update").
+		Register("optimistic_lock:update", op.lockVersion); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().After("gorm:update").
+		Register("optimistic_lock:check", op.checkVersion); err != nil {
+		return err
+	}
+	db.ClauseBuilders["SET"] = op.buildSet(db.ClauseBuilders["SET"])
+	return nil
+}
+
+// versionField 返回模型的版本字段, 不使用乐观锁时返回 nil
+func (op *OptimisticLockPlugin) versionField(stmt *gorm.Statement) *schema.Field {
+	if stmt.Schema == nil || stmt.Schema.Table != stmt.Table {
+		return nil
+	}
+	for _, t := range op.IgnoreTables {
+		if strings.EqualFold(t, stmt.Table) {
+			return nil
+		}
+	}
+	if v, ok := op.fields.Load(stmt.Schema); ok {
+		return v.(*schema.Field)
+	}
+	var field *schema.Field
+	for _, f := range stmt.Schema.Fields {
+		if _, ok := f.Tag.Lookup(VersionTag); ok {
+			field = f
+			break
+		}
+	}
+	if field == nil {
+		field = stmt.Schema.LookUpField(op.Field)
+	}
+	if field != nil {
+		if k := field.IndirectFieldType.Kind(); field.DBName == "" || k < reflect.Int || k > reflect.Uint64 {
+			field = nil
+		}
+	}
+	op.fields.Store(stmt.Schema, field)
+	return field
+}
+
+// initVersion 创建时初始化版本号
+func (op *OptimisticLockPlugin) initVersion(db *gorm.DB) {
+	stmt := db.Statement
+	field := op.versionField(stmt)
+	if field == nil {
+		return
+	}
+	value := []auditValue{{field: field, value: 1}}
+	switch dest := stmt.Dest.(type) {
+	case map[string]interface{}:
+		setMapValues(dest, value)
+		return
+	case *map[string]interface{}:
+		setMapValues(*dest, value)
+		return
+	case []map[string]interface{}:
+		for _, m := range dest {
+			setMapValues(m, value)
+		}
+		return
+	}
+	WalkStruct(stmt.ReflectValue, func(v reflect.Value) {
+		if v.Type() != stmt.Schema.ModelType {
+			return
+		}
+		if _, zero := field.ValueOf(stmt.Context, v); zero {
+			_ = db.AddError(field.Set(stmt.Context, v, 1))
+		}
+	})
+}
+
+// lockVersion 追加版本条件, SET 由 buildSet 处理
+func (op *OptimisticLockPlugin) lockVersion(db *gorm.DB) {
+	stmt := db.Statement
+	field := op.versionField(stmt)
+	if field == nil || db.Error != nil || stmt.SQL.Len() > 0 {
+		return
+	}
+	m, ok := stmt.Dest.(map[string]interface{})
+	if p, isPtr := stmt.Dest.(*map[string]interface{}); isPtr && p != nil {
+		m, ok = *p, true
+	}
+	if ok {
+		if _, ok := m[field.Name]; ok {
+			return
+		}
+		if _, ok := m[field.DBName]; ok {
+			return
+		}
+	}
+
+	state := &versionState{field: field, expected: versionOf(stmt, field)}
+	if state.expected != 0 {
+		stmt.AddClause(clause.Where{Exprs: []clause.Expression{
+			clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: state.expected},
+		}})
+	}
+	db.InstanceSet(versionLock, state)
+}
+
+// checkVersion 校验影响行数并回写版本号
+func (op *OptimisticLockPlugin) checkVersion(db *gorm.DB) {
+	v, ok := db.InstanceGet(versionLock)
+	if !ok || db.Error != nil || db.DryRun {
+		return
+	}
+	state := v.(*versionState)
+	if state.expected == 0 {
+		return
+	}
+	if db.RowsAffected == 0 {
+		_ = db.AddError(versionConflictErr())
+		return
+	}
+	stmt := db.Statement
+	next := state.expected + 1
+	if stmt.ReflectValue.Kind() == reflect.Struct && stmt.ReflectValue.CanAddr() {
+		_ = db.AddError(state.field.Set(stmt.Context, stmt.ReflectValue, next))
+	}
+	if dest := reflect.ValueOf(stmt.Dest); dest.Kind() == reflect.Ptr && dest.Elem().Kind() == reflect.Struct {
+		if f := dest.Elem().FieldByName(state.field.Name); f.IsValid() && f.CanSet() && f.CanInt() {
+			f.SetInt(next)
+		} else if f.IsValid() && f.CanSet() && f.CanUint() {
+			f.SetUint(uint64(next))
+		}
+	}
+}
+
+// buildSet 将版本列的赋值替换为 version = version + 1
+func (op *OptimisticLockPlugin) buildSet(next clause.ClauseBuilder) clause.ClauseBuilder {
+	return func(c clause.Clause, builder clause.Builder) {
+		if stmt, ok := builder.(*gorm.Statement); ok && stmt.DB != nil {
+			if v, ok := stmt.DB.InstanceGet(versionLock); ok {
+				if set, ok := c.Expression.(clause.Set); ok {
+					c.Expression = incrVersion(set, v.(*versionState).field.DBName)
+				}
+			}
+		}
+		if next != nil {
+			next(c, builder)
+			return
+		}
+		c.Build(builder)
+	}
+}
+
+func incrVersion(set clause.Set, column string) clause.Set {
+	result := make(clause.Set, 0, len(set)+1)
+	for _, a := range set {
+		if a.Column.Name != column {
+			result = append(result, a)
+		}
+	}
+	return append(result, clause.Assignment{
+		Column: clause.Column{Name: column},
+		Value:  clause.Expr{SQL: "? + 1", Vars: []interface{}{clause.Column{Name: column}}},
+	})
+}
+
+// versionOf 返回更新语句中的版本号, 优先取 Updates 传入的结构体, 其次为 Model
+func versionOf(stmt *gorm.Statement, field *schema.Field) int64 {
+	dest := reflect.Indirect(reflect.ValueOf(stmt.Dest))
+	if dest.Kind() == reflect.Struct {
+		if f := dest.FieldByName(field.Name); f.IsValid() {
+			if v := intValue(reflect.Indirect(f)); v != 0 {
+				return v
+			}
+		}
+	}
+	if stmt.ReflectValue.Kind() != reflect.Struct {
+		return 0
+	}
+	v, _ := field.ValueOf(stmt.Context, stmt.ReflectValue)
+	return intValue(reflect.Indirect(reflect.ValueOf(v)))
+}
+
+func intValue(v reflect.Value) int64 {
+	switch {
+	case !v.IsValid():
+		return 0
+	case v.CanInt():
+		return v.Int()
+	case v.CanUint():
+		return int64(v.Uint())
+	}
+	return 0
+}
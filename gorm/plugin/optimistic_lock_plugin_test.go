@@ -0,0 +1,120 @@
+package plugin_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+	"github.com/ovra-cloud/ovra-toolkit/errx"
+	"github.com/ovra-cloud/ovra-toolkit/gorm/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Article struct {
+	ID         int64 `gorm:"primaryKey"`
+	TenantID   string
+	Title      string
+	Version    int64
+	UpdateBy   string
+	UpdateTime time.Time
+}
+
+type ArticleForm struct {
+	Title   string
+	Version int64
+}
+
+type Draft struct {
+	ID    int64 `gorm:"primaryKey"`
+	Title string
+	Rev   uint32 `version:"true"`
+}
+
+func TestOptimisticLockPlugin(t *testing.T) {
+	db, rec := newSQLiteDB(t, &plugin.TenantPlugin{Enabled: true}, &plugin.AuditPlugin{}, &plugin.OptimisticLockPlugin{})
+	require.NoError(t, db.AutoMigrate(&Article{}, &Draft{}))
+
+	ctx := context.WithValue(context.Background(), auth.TenantIDKey, "1")
+	ctx = context.WithValue(ctx, auth.UserIDKey, "9")
+	require.NoError(t, db.WithContext(ctx).Create(&Article{ID: 1, Title: "a"}).Error)
+
+	var a1, a2 Article
+	require.NoError(t, db.WithContext(ctx).First(&a1, 1).Error)
+	require.NoError(t, db.WithContext(ctx).First(&a2, 1).Error)
+	assert.Equal(t, int64(1), a1.Version)
+
+	t.Run("更新成功", func(t *testing.T) {
+		a1.Title = "b"
+		require.NoError(t, db.WithContext(ctx).Save(&a1).Error)
+		assert.Equal(t, int64(2), a1.Version)
+		assert.Contains(t, rec.Last(), "`version`=`version` + 1")
+		assert.Contains(t, rec.Last(), "`articles`.`version` = 1")
+		assert.Contains(t, rec.Last(), "`articles`.`tenant_id` = \"1\"")
+		assert.Contains(t, rec.Last(), "`update_by`=\"9\"")
+	})
+
+	t.Run("版本冲突", func(t *testing.T) {
+		err := db.WithContext(ctx).Model(&a2).Update("title", "c").Error
+		assert.ErrorIs(t, err, plugin.ErrVersionConflict)
+		assert.Equal(t, int32(errx.CodeConflict), errx.GORMErr(err).Code)
+		assert.Equal(t, int64(1), a2.Version)
+
+		a2.Title = "c"
+		assert.ErrorIs(t, db.WithContext(ctx).Save(&a2).Error, plugin.ErrVersionConflict)
+	})
+
+	t.Run("表单携带版本号", func(t *testing.T) {
+		form := ArticleForm{Title: "d", Version: 2}
+		require.NoError(t, db.WithContext(ctx).Model(&Article{ID: 1}).Updates(&form).Error)
+		assert.Equal(t, int64(3), form.Version)
+
+		form = ArticleForm{Title: "e", Version: 2}
+		assert.ErrorIs(t, db.WithContext(ctx).Model(&Article{ID: 1}).Updates(&form).Error, plugin.ErrVersionConflict)
+	})
+
+	t.Run("批量更新与显式版本", func(t *testing.T) {
+		require.NoError(t, db.WithContext(ctx).Model(&Article{}).Where("title = ?", "d").Update("title", "f").Error)
+		assert.NotContains(t, rec.Last(), "`articles`.`version` =")
+		var a Article
+		require.NoError(t, db.WithContext(ctx).First(&a, 1).Error)
+		assert.Equal(t, int64(4), a.Version)
+
+		require.NoError(t, db.WithContext(ctx).Model(&Article{}).Where("id = ?", 1).
+			Updates(map[string]interface{}{"version": 10}).Error)
+		require.NoError(t, db.WithContext(ctx).First(&a, 1).Error)
+		assert.Equal(t, int64(10), a.Version)
+
+		require.NoError(t, db.WithContext(ctx).Model(&Article{}).Where("id = ?", 1).
+			Updates(&map[string]interface{}{"version": 20}).Error)
+		require.NoError(t, db.WithContext(ctx).First(&a, 1).Error)
+		assert.Equal(t, int64(20), a.Version)
+	})
+
+	t.Run("标签", func(t *testing.T) {
+		d := Draft{ID: 1, Title: "a"}
+		require.NoError(t, db.Create(&d).Error)
+		assert.Equal(t, uint32(1), d.Rev)
+		require.NoError(t, db.Model(&d).Update("title", "b").Error)
+		assert.Equal(t, uint32(2), d.Rev)
+		assert.Contains(t, rec.Last(), "`rev`=`rev` + 1")
+	})
+
+	t.Run("错误不共享", func(t *testing.T) {
+		err1 := db.WithContext(ctx).Model(&a2).Update("title", "x").Error
+		err2 := db.WithContext(ctx).Model(&a2).Update("title", "y").Error
+		assert.ErrorIs(t, err1, plugin.ErrVersionConflict)
+		assert.NotSame(t, errx.GORMErr(err1), errx.GORMErr(err2))
+	})
+}
+
+func TestOptimisticLockPluginIgnoreTables(t *testing.T) {
+	db, rec := newSQLiteDB(t, &plugin.OptimisticLockPlugin{IgnoreTables: []string{"DRAFTS"}})
+	require.NoError(t, db.AutoMigrate(&Draft{}))
+	d := Draft{ID: 1, Title: "a"}
+	require.NoError(t, db.Create(&d).Error)
+	assert.Equal(t, uint32(0), d.Rev)
+	require.NoError(t, db.Model(&d).Update("title", "b").Error)
+	assert.NotContains(t, rec.Last(), "`rev`")
+}
@@ -8,11 +8,14 @@ import (
 	"time"
 
 	"github.com/ovra-cloud/ovra-toolkit/auth"
+	"github.com/ovra-cloud/ovra-toolkit/errx"
 	"github.com/ovra-cloud/ovra-toolkit/gorm/plugin"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 	"gorm.io/gorm/schema"
 )
@@ -101,3 +104,252 @@ func TestDataScopePlugin(t *testing.T) {
 		assert.Len(t, users, 2)
 	})
 }
+
+func dataScopeCtx(scope int) context.Context {
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, auth.DataScopeKey, scope)
+	ctx = context.WithValue(ctx, auth.UserIDKey, "1")
+	ctx = context.WithValue(ctx, auth.CurrentDeptKey, "100")
+	ctx = context.WithValue(ctx, auth.BellowDeptKey, "100, 101")
+	ctx = context.WithValue(ctx, auth.CustomerDeptKey, "200,300")
+	return ctx
+}
+
+func TestDataScopePluginSQL(t *testing.T) {
+	db := newDryRunDB(t, &plugin.DataScopePlugin{Enabled: true})
+	cases := []struct {
+		scope int
+		sql   string
+		vars  []interface{}
+	}{
+		{1, "SELECT * FROM `test_user`", nil},
+		{2, "SELECT * FROM `test_user` WHERE (`test_user`.`create_dept` IN (?,?))", []interface{}{"200", "300"}},
+		{3, "SELECT * FROM `test_user` WHERE (`test_user`.`create_dept` = ?)", []interface{}{"100"}},
+		{4, "SELECT * FROM `test_user` WHERE (`test_user`.`create_dept` IN (?,?))", []interface{}{"100", "101"}},
+		{5, "SELECT * FROM `test_user` WHERE (`test_user`.`create_by` = ?)", []interface{}{"1"}},
+		{6, "SELECT * FROM `test_user` WHERE (`test_user`.`create_dept` IN (?,?) OR `test_user`.`create_by` = ?)", []interface{}{"100", "101", "1"}},
+	}
+	for _, c := range cases {
+		stmt := db.WithContext(dataScopeCtx(c.scope)).Find(&[]User{}).Statement
+		assert.Equal(t, c.sql, stmt.SQL.String(), "scope %d", c.scope)
+		assert.Equal(t, c.vars, stmt.Vars, "scope %d", c.scope)
+	}
+
+	// 数据权限条件与业务中的 OR 条件分别加括号
+	stmt := db.WithContext(dataScopeCtx(5)).Where("name = ? OR id = ?", "a", 1).Find(&[]User{}).Statement
+	assert.Equal(t, "SELECT * FROM `test_user` WHERE (name = ? OR id = ?) AND (`test_user`.`create_by` = ?)", stmt.SQL.String())
+}
+
+func TestDataScopePluginInjection(t *testing.T) {
+	db, _ := newSQLiteDB(t, &plugin.DataScopePlugin{Enabled: true})
+	require.NoError(t, db.AutoMigrate(&User{}))
+	require.NoError(t, db.Create(&[]User{
+		{ID: 1, Name: "a", CreateDept: "100", CreateBy: "1"},
+		{ID: 2, Name: "b", CreateDept: "200", CreateBy: "2"},
+	}).Error)
+
+	ctx := context.WithValue(dataScopeCtx(3), auth.CurrentDeptKey, "100' OR '1'='1")
+	var users []User
+	require.NoError(t, db.WithContext(ctx).Find(&users).Error)
+	assert.Empty(t, users)
+
+	ctx = context.WithValue(dataScopeCtx(2), auth.CustomerDeptKey, "")
+	require.NoError(t, db.WithContext(ctx).Find(&users).Error)
+	assert.Empty(t, users)
+
+	require.NoError(t, db.WithContext(dataScopeCtx(6)).Find(&users).Error)
+	assert.Len(t, users, 1)
+}
+
+func TestDataScopePluginWrite(t *testing.T) {
+	seed := func(t *testing.T, p *plugin.DataScopePlugin) *gorm.DB {
+		db, _ := newSQLiteDB(t, p)
+		require.NoError(t, db.AutoMigrate(&User{}))
+		require.NoError(t, db.Create(&[]User{
+			{ID: 1, Name: "a", CreateDept: "100", CreateBy: "1"},
+			{ID: 2, Name: "b", CreateDept: "200", CreateBy: "2"},
+		}).Error)
+		return db
+	}
+	ctx := dataScopeCtx(3)
+
+	t.Run("未开启时不限制", func(t *testing.T) {
+		db := seed(t, &plugin.DataScopePlugin{Enabled: true})
+		res := db.WithContext(ctx).Model(&User{ID: 2}).Update("name", "x")
+		require.NoError(t, res.Error)
+		assert.Equal(t, int64(1), res.RowsAffected)
+	})
+
+	t.Run("影响行数为 0", func(t *testing.T) {
+		db := seed(t, &plugin.DataScopePlugin{Enabled: true, Update: true, Delete: true})
+		res := db.WithContext(ctx).Model(&User{ID: 2}).Update("name", "x")
+		require.NoError(t, res.Error)
+		assert.Zero(t, res.RowsAffected)
+
+		res = db.WithContext(ctx).Delete(&User{}, 2)
+		require.NoError(t, res.Error)
+		assert.Zero(t, res.RowsAffected)
+
+		res = db.WithContext(ctx).Model(&User{ID: 1}).Update("name", "x")
+		require.NoError(t, res.Error)
+		assert.Equal(t, int64(1), res.RowsAffected)
+	})
+
+	t.Run("严格模式", func(t *testing.T) {
+		db := seed(t, &plugin.DataScopePlugin{Enabled: true, Update: true, Delete: true, Strict: true})
+		err := db.WithContext(ctx).Model(&User{ID: 2}).Update("name", "x").Error
+		assert.ErrorIs(t, err, plugin.ErrDataScopeDenied)
+		assert.Equal(t, int32(errx.CodeNoPerm), errx.FromError(err).Code)
+
+		err = db.WithContext(ctx).Where("name = ?", "b").Delete(&User{}).Error
+		assert.ErrorIs(t, err, plugin.ErrDataScopeDenied)
+
+		// 数据不存在时不视为越权
+		assert.NoError(t, db.WithContext(ctx).Delete(&User{}, 99).Error)
+	})
+
+	t.Run("Row", func(t *testing.T) {
+		db := seed(t, &plugin.DataScopePlugin{Enabled: true, Row: true})
+		var count int64
+		require.NoError(t, db.WithContext(ctx).Model(&User{}).Select("count(*)").Row().Scan(&count))
+		assert.Equal(t, int64(1), count)
+	})
+}
+
+func TestDataScopePluginRoles(t *testing.T) {
+	db := newDryRunDB(t, &plugin.DataScopePlugin{Enabled: true})
+	find := func(scopes ...auth.RoleScope) *gorm.Statement {
+		ctx := context.WithValue(dataScopeCtx(5), auth.RoleScopesKey, scopes)
+		return db.WithContext(ctx).Find(&[]User{}).Statement
+	}
+
+	stmt := find(auth.RoleScope{DataScope: 3}, auth.RoleScope{DataScope: 2, DeptIds: []string{"7", "9", "100"}})
+	assert.Equal(t, "SELECT * FROM `test_user` WHERE (`test_user`.`create_dept` IN (?,?,?))", stmt.SQL.String())
+	assert.Equal(t, []interface{}{"100", "7", "9"}, stmt.Vars)
+
+	stmt = find(auth.RoleScope{DataScope: 5}, auth.RoleScope{DataScope: 4}, auth.RoleScope{DataScope: 6})
+	assert.Equal(t, "SELECT * FROM `test_user` WHERE (`test_user`.`create_by` = ? OR `test_user`.`create_dept` IN (?,?))", stmt.SQL.String())
+	assert.Equal(t, []interface{}{"1", "100", "101"}, stmt.Vars)
+
+	stmt = find(auth.RoleScope{DataScope: 5}, auth.RoleScope{DataScope: 1})
+	assert.Equal(t, "SELECT * FROM `test_user`", stmt.SQL.String())
+}
+
+type SysDept struct {
+	DeptID    string `gorm:"primaryKey"`
+	Ancestors string
+}
+
+func (SysDept) TableName() string {
+	return "sys_dept"
+}
+
+func TestDataScopePluginDeptTree(t *testing.T) {
+	t.Run("MySQL FIND_IN_SET", func(t *testing.T) {
+		db := newDryRunDB(t, &plugin.DataScopePlugin{Enabled: true, DeptTree: &plugin.DeptTree{}})
+		stmt := db.WithContext(dataScopeCtx(6)).Find(&[]User{}).Statement
+		assert.Equal(t, "SELECT * FROM `test_user` WHERE (`test_user`.`create_dept` IN "+
+			"(SELECT `dept_id` FROM `sys_dept` WHERE `dept_id` = ? OR FIND_IN_SET(?, `ancestors`)) "+
+			"OR `test_user`.`create_by` = ?)", stmt.SQL.String())
+		assert.Equal(t, []interface{}{"100", "100", "1"}, stmt.Vars)
+	})
+
+	t.Run("闭包表", func(t *testing.T) {
+		db := newDryRunDB(t, &plugin.DataScopePlugin{Enabled: true, DeptTree: &plugin.DeptTree{Mode: plugin.DeptTreeClosure}})
+		ctx := context.WithValue(dataScopeCtx(5), auth.RoleScopesKey, []auth.RoleScope{
+			{DataScope: 4}, {DataScope: 2, DeptIds: []string{"100", "7"}},
+		})
+		stmt := db.WithContext(ctx).Find(&[]User{}).Statement
+		assert.Equal(t, "SELECT * FROM `test_user` WHERE (`test_user`.`create_dept` IN "+
+			"(SELECT `descendant_id` FROM `sys_dept_closure` WHERE `ancestor_id` = ?) OR `test_user`.`create_dept` = ?)", stmt.SQL.String())
+		assert.Equal(t, []interface{}{"100", "7"}, stmt.Vars)
+	})
+
+	t.Run("部门表按租户过滤", func(t *testing.T) {
+		db := newDryRunDB(t, &plugin.DataScopePlugin{Enabled: true, DeptTree: &plugin.DeptTree{}})
+		ctx := context.WithValue(dataScopeCtx(4), auth.TenantIDKey, "9")
+		stmt := db.WithContext(ctx).Find(&[]User{}).Statement
+		assert.Equal(t, "SELECT * FROM `test_user` WHERE (`test_user`.`create_dept` IN "+
+			"(SELECT `dept_id` FROM `sys_dept` WHERE ((`dept_id` = ? OR FIND_IN_SET(?, `ancestors`)) AND `tenant_id` = ?)))", stmt.SQL.String())
+		assert.Equal(t, []interface{}{"100", "100", "9"}, stmt.Vars)
+
+		db = newDryRunDB(t, &plugin.DataScopePlugin{Enabled: true, DeptTree: &plugin.DeptTree{Mode: plugin.DeptTreeClosure, TenantColumn: "org_id"}})
+		stmt = db.WithContext(ctx).Find(&[]User{}).Statement
+		assert.Equal(t, "SELECT * FROM `test_user` WHERE (`test_user`.`create_dept` IN "+
+			"(SELECT `descendant_id` FROM `sys_dept_closure` WHERE (`ancestor_id` = ? AND `org_id` = ?)))", stmt.SQL.String())
+
+		db = newDryRunDB(t, &plugin.DataScopePlugin{Enabled: true, DeptTree: &plugin.DeptTree{NoTenant: true}})
+		stmt = db.WithContext(ctx).Find(&[]User{}).Statement
+		assert.NotContains(t, stmt.SQL.String(), "tenant_id")
+	})
+
+	t.Run("SQLite LIKE", func(t *testing.T) {
+		db, _ := newSQLiteDB(t, &plugin.DataScopePlugin{Enabled: true, DeptTree: &plugin.DeptTree{}})
+		require.NoError(t, db.AutoMigrate(&User{}, &SysDept{}))
+		require.NoError(t, db.Create(&[]SysDept{
+			{DeptID: "100", Ancestors: "0"},
+			{DeptID: "101", Ancestors: "0,100"},
+			{DeptID: "102", Ancestors: "0,100,101"},
+			{DeptID: "1000", Ancestors: "0"},
+		}).Error)
+		require.NoError(t, db.Create(&[]User{
+			{ID: 1, CreateDept: "100"}, {ID: 2, CreateDept: "102"}, {ID: 3, CreateDept: "1000"},
+		}).Error)
+
+		var users []User
+		ctx := context.WithValue(dataScopeCtx(4), auth.BellowDeptKey, "")
+		require.NoError(t, db.WithContext(ctx).Order("id").Find(&users).Error)
+		require.Len(t, users, 2)
+		assert.Equal(t, int64(2), users[1].ID)
+	})
+}
+
+func TestDataScopePluginProvider(t *testing.T) {
+	// 我负责的项目: project_member 表中存在当前用户
+	plugin.RegisterDataScope(100, func(ctx context.Context, stmt *gorm.Statement, scope plugin.DataScopeContext) clause.Expression {
+		return clause.Expr{
+			SQL:  "? IN (SELECT project_id FROM project_member WHERE user_id = ?)",
+			Vars: []interface{}{clause.Column{Table: scope.Table, Name: "id"}, scope.UserID},
+		}
+	})
+	// 我创建的或指派给我的: 提供者返回带 OR 的条件
+	plugin.RegisterDataScope(101, func(ctx context.Context, stmt *gorm.Statement, scope plugin.DataScopeContext) clause.Expression {
+		col, _ := scope.UserColumn()
+		return clause.Expr{
+			SQL:  "? = ? OR ? = ?",
+			Vars: []interface{}{col, scope.UserID, clause.Column{Table: scope.Table, Name: "name"}, scope.UserID},
+		}
+	})
+	t.Cleanup(func() {
+		plugin.UnregisterDataScope(100)
+		plugin.UnregisterDataScope(101)
+	})
+	db := newDryRunDB(t, &plugin.DataScopePlugin{Enabled: true})
+	find := func(scopes ...auth.RoleScope) *gorm.Statement {
+		ctx := context.WithValue(dataScopeCtx(5), auth.RoleScopesKey, scopes)
+		return db.WithContext(ctx).Where("id = ? OR id = ?", 1, 2).Find(&[]User{}).Statement
+	}
+
+	stmt := find(auth.RoleScope{DataScope: 100}, auth.RoleScope{DataScope: 3}, auth.RoleScope{DataScope: 100})
+	assert.Equal(t, "SELECT * FROM `test_user` WHERE (id = ? OR id = ?) AND (`test_user`.`id` IN (SELECT project_id FROM project_member WHERE user_id = ?) "+
+		"OR `test_user`.`create_dept` = ?)", stmt.SQL.String())
+	assert.Equal(t, []interface{}{1, 2, "1", "100"}, stmt.Vars)
+
+	stmt = find(auth.RoleScope{DataScope: 101})
+	assert.Equal(t, "SELECT * FROM `test_user` WHERE (id = ? OR id = ?) AND (`test_user`.`create_by` = ? OR `test_user`.`name` = ?)", stmt.SQL.String())
+
+	stmt = find(auth.RoleScope{DataScope: 101}, auth.RoleScope{DataScope: 3})
+	assert.Equal(t, "SELECT * FROM `test_user` WHERE (id = ? OR id = ?) AND "+
+		"((`test_user`.`create_by` = ? OR `test_user`.`name` = ?) OR `test_user`.`create_dept` = ?)", stmt.SQL.String())
+
+	// 移除后未注册的ID按仅本人处理, 内置ID恢复默认实现
+	plugin.UnregisterDataScope(101)
+	stmt = find(auth.RoleScope{DataScope: 101})
+	assert.Equal(t, "SELECT * FROM `test_user` WHERE (id = ? OR id = ?) AND (`test_user`.`create_by` = ?)", stmt.SQL.String())
+	plugin.RegisterDataScope(plugin.DataScopeDept, func(context.Context, *gorm.Statement, plugin.DataScopeContext) clause.Expression {
+		return plugin.AllDataExpr
+	})
+	plugin.UnregisterDataScope(plugin.DataScopeDept)
+	stmt = find(auth.RoleScope{DataScope: plugin.DataScopeDept})
+	assert.Equal(t, "SELECT * FROM `test_user` WHERE (id = ? OR id = ?) AND (`test_user`.`create_dept` = ?)", stmt.SQL.String())
+}
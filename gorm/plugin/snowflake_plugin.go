@@ -0,0 +1,111 @@
+package plugin
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/ovra-cloud/ovra-toolkit/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	gormutils "gorm.io/gorm/utils"
+)
+
+// SnowflakeTag 主键标签, `snowflake:"-"` 表示不自动生成（如自增主键）
+const SnowflakeTag = "snowflake"
+
+// SnowflakePlugin 创建时为空主键填充雪花ID, 支持 string 与整数主键
+//
+// 包括按名称识别的 ID 主键; 联合主键、声明 autoIncrement 或 snowflake:"-" 的主键不做处理
+type SnowflakePlugin struct {
+	// Generator ID 生成器, 默认 utils.GetIDInt64, 使用默认值时需先调用 utils.Init
+	Generator    func() int64
+	IgnoreTables []string
+}
+
+func (sp *SnowflakePlugin) Name() string {
+	return "SnowflakePlugin"
+}
+
+func (sp *SnowflakePlugin) Initialize(db *gorm.DB) error {
+	if sp.Generator == nil {
+		if !utils.Initialized() {
+			return errors.New("snowflake plugin: utils.Init must be called before using the default generator")
+		}
+		sp.Generator = utils.GetIDInt64
+	}
+	return db.Callback().Create().Before("gorm:create").
+		Register("snowflake:create", sp.fillID)
+}
+
+// primaryField 返回需要生成ID的主键字段
+func (sp *SnowflakePlugin) primaryField(stmt *gorm.Statement) *schema.Field {
+	if stmt.Schema == nil || len(stmt.Schema.PrimaryFields) != 1 {
+		return nil
+	}
+	for _, t := range sp.IgnoreTables {
+		if strings.EqualFold(t, stmt.Table) {
+			return nil
+		}
+	}
+	field := stmt.Schema.PrimaryFields[0]
+	if field.Tag.Get(SnowflakeTag) == "-" {
+		return nil
+	}
+	// GORM 会将未声明的整数主键视为自增, 这里只认显式声明的 autoIncrement
+	if v, ok := field.TagSettings["AUTOINCREMENT"]; ok && gormutils.CheckTruth(v) {
+		return nil
+	}
+	switch field.FieldType.Kind() {
+	case reflect.String, reflect.Int64, reflect.Uint64, reflect.Int, reflect.Uint:
+		return field
+	}
+	return nil
+}
+
+// nextID 按主键类型生成ID
+func (sp *SnowflakePlugin) nextID(field *schema.Field) interface{} {
+	id := sp.Generator()
+	if field.FieldType.Kind() == reflect.String {
+		return strconv.FormatInt(id, 10)
+	}
+	return id
+}
+
+func (sp *SnowflakePlugin) fillID(db *gorm.DB) {
+	stmt := db.Statement
+	field := sp.primaryField(stmt)
+	if field == nil || db.Error != nil {
+		return
+	}
+	fillMap := func(m map[string]interface{}) {
+		for _, key := range []string{field.Name, field.DBName} {
+			if v, ok := m[key]; ok && v != nil && !reflect.ValueOf(v).IsZero() {
+				return
+			}
+		}
+		m[field.DBName] = sp.nextID(field)
+	}
+	switch dest := stmt.Dest.(type) {
+	case map[string]interface{}:
+		fillMap(dest)
+		return
+	case *map[string]interface{}:
+		fillMap(*dest)
+		return
+	case []map[string]interface{}:
+		for _, m := range dest {
+			fillMap(m)
+		}
+		return
+	}
+	WalkStruct(stmt.ReflectValue, func(v reflect.Value) {
+		if v.Type() != stmt.Schema.ModelType {
+			return
+		}
+		if _, zero := field.ValueOf(stmt.Context, v); zero {
+			_ = db.AddError(field.Set(stmt.Context, v, sp.nextID(field)))
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package plugin_test
+
+import (
+	"testing"
+
+	"github.com/ovra-cloud/ovra-toolkit/gorm/plugin"
+	"github.com/ovra-cloud/ovra-toolkit/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type Device struct {
+	ID   int64 `gorm:"primaryKey"`
+	Name string
+}
+
+type Firmware struct {
+	ID   string `gorm:"primaryKey"`
+	Name string
+}
+
+type DeviceLog struct {
+	ID   int64 `gorm:"primaryKey;autoIncrement"`
+	Name string
+}
+
+type DeviceTag struct {
+	ID   uint `gorm:"primaryKey" snowflake:"-"`
+	Name string
+}
+
+type DeviceGroup struct {
+	ID   int64 `gorm:"primaryKey;autoIncrement:false"`
+	Name string
+}
+
+// DeviceModel 按名称识别的主键
+type DeviceModel struct {
+	ID   int64
+	Name string
+}
+
+type DeviceKey struct {
+	ID   int64 `snowflake:""`
+	Name string
+}
+
+func TestSnowflakePlugin(t *testing.T) {
+	var seq int64 = 1000
+	db, _ := newSQLiteDB(t, &plugin.SnowflakePlugin{Generator: func() int64 { seq++; return seq }})
+	require.NoError(t, db.AutoMigrate(&Device{}, &Firmware{}, &DeviceLog{}, &DeviceTag{},
+		&DeviceGroup{}, &DeviceModel{}, &DeviceKey{}))
+
+	t.Run("整数与字符串主键", func(t *testing.T) {
+		d := Device{Name: "a"}
+		require.NoError(t, db.Create(&d).Error)
+		assert.Equal(t, int64(1001), d.ID)
+
+		f := Firmware{Name: "v1"}
+		require.NoError(t, db.Create(&f).Error)
+		assert.Equal(t, "1002", f.ID)
+
+		kept := Device{ID: 7, Name: "b"}
+		require.NoError(t, db.Create(&kept).Error)
+		assert.Equal(t, int64(7), kept.ID)
+	})
+
+	t.Run("批量与 map", func(t *testing.T) {
+		list := []*Device{{Name: "c"}, {ID: 8, Name: "d"}, {Name: "e"}}
+		require.NoError(t, db.Create(&list).Error)
+		assert.Equal(t, []int64{1003, 8, 1004}, []int64{list[0].ID, list[1].ID, list[2].ID})
+
+		require.NoError(t, db.Model(&Firmware{}).Create([]map[string]interface{}{{"name": "v2"}, {"id": "9", "name": "v3"}}).Error)
+		var ids []string
+		require.NoError(t, db.Model(&Firmware{}).Where("name IN ?", []string{"v2", "v3"}).Order("name").Pluck("id", &ids).Error)
+		assert.Equal(t, []string{"1005", "9"}, ids)
+	})
+
+	t.Run("自增主键不处理", func(t *testing.T) {
+		l := DeviceLog{Name: "x"}
+		require.NoError(t, db.Create(&l).Error)
+		assert.Equal(t, int64(1), l.ID)
+
+		tag := DeviceTag{Name: "y"}
+		require.NoError(t, db.Create(&tag).Error)
+		assert.Equal(t, uint(1), tag.ID)
+		assert.Equal(t, int64(1005), seq)
+	})
+
+	t.Run("未声明标签的整数主键", func(t *testing.T) {
+		m := DeviceModel{Name: "z"}
+		require.NoError(t, db.Create(&m).Error)
+		assert.Equal(t, int64(1006), m.ID)
+
+		g := DeviceGroup{Name: "g"}
+		require.NoError(t, db.Create(&g).Error)
+		assert.Equal(t, int64(1007), g.ID)
+
+		k := DeviceKey{Name: "k"}
+		require.NoError(t, db.Create(&k).Error)
+		assert.Equal(t, int64(1008), k.ID)
+	})
+}
+
+func TestSnowflakePluginDefaultGenerator(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	if !utils.Initialized() {
+		assert.Error(t, db.Use(&plugin.SnowflakePlugin{}))
+	}
+	require.NoError(t, utils.Init("2024-01-01", 1))
+	require.NoError(t, db.Use(&plugin.SnowflakePlugin{}))
+}
@@ -0,0 +1,287 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+	"github.com/ovra-cloud/ovra-toolkit/errx"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// ErrCrossTenant 写入数据的租户与当前租户不一致, 使用 errors.Is 判断
+//
+// 插件返回的是包装该错误的 *errx.Error（CodeCrossTenant）, 每次均为新值
+var ErrCrossTenant = errors.New("tenant: cross tenant write")
+
+func crossTenantErr() error {
+	return errx.New(errx.CodeCrossTenant, "禁止跨租户写入数据").WithCause(ErrCrossTenant)
+}
+
+// guardCreate 填充空租户字段, 显式指定其他租户时拒绝写入
+func (tp *TenantPlugin) guardCreate(db *gorm.DB) {
+	if tp.shouldSkip(db) {
+		return
+	}
+	tenantID, ok := getTenantID(db)
+	if !ok || tenantID == "" {
+		return
+	}
+	field, _ := tp.tableColumn(db.Statement)
+	switch dest := db.Statement.Dest.(type) {
+	case map[string]interface{}:
+		tp.guardCreateMap(db, field, dest, tenantID)
+		return
+	case *map[string]interface{}:
+		tp.guardCreateMap(db, field, *dest, tenantID)
+		return
+	case []map[string]interface{}:
+		for _, m := range dest {
+			tp.guardCreateMap(db, field, m, tenantID)
+		}
+		return
+	}
+	if field == nil {
+		return
+	}
+	ctx := db.Statement.Context
+	value := idValue(field, tenantID)
+	WalkStruct(db.Statement.ReflectValue, func(v reflect.Value) {
+		if db.Error != nil {
+			return
+		}
+		current, zero := field.ValueOf(ctx, v)
+		if zero {
+			_ = db.AddError(field.Set(ctx, v, value))
+		} else if !sameTenant(current, tenantID) {
+			_ = db.AddError(crossTenantErr())
+		}
+	})
+	if db.Error == nil {
+		tp.guardUpsert(db, field, value)
+	}
+}
+
+// guardUpsert 冲突更新（Save、OnConflict）的冲突行属于其他租户时拒绝写入
+//
+// 冲突条件为 OnConflict.Columns, 未指定时为主键; 执行 SQL 时仍会限制只更新当前租户的行
+func (tp *TenantPlugin) guardUpsert(db *gorm.DB, field *schema.Field, value interface{}) {
+	stmt := db.Statement
+	c, ok := stmt.Clauses["ON CONFLICT"]
+	if !ok || stmt.Schema == nil || db.DryRun {
+		return
+	}
+	onConflict, ok := c.Expression.(clause.OnConflict)
+	if !ok || onConflict.DoNothing || (!onConflict.UpdateAll && len(onConflict.DoUpdates) == 0) {
+		return
+	}
+	fields := stmt.Schema.PrimaryFields
+	if len(onConflict.Columns) > 0 {
+		fields = nil
+		for _, col := range onConflict.Columns {
+			f := stmt.Schema.LookUpField(col.Name)
+			if f == nil {
+				return
+			}
+			fields = append(fields, f)
+		}
+	}
+	if len(fields) == 0 {
+		return
+	}
+
+	var keys []clause.Expression
+	WalkStruct(stmt.ReflectValue, func(v reflect.Value) {
+		eqs := make([]clause.Expression, 0, len(fields))
+		for _, f := range fields {
+			fv, zero := f.ValueOf(stmt.Context, v)
+			if zero {
+				return
+			}
+			eqs = append(eqs, clause.Eq{Column: clause.Column{Name: f.DBName}, Value: fv})
+		}
+		keys = append(keys, clause.And(eqs...))
+	})
+	if len(keys) == 0 {
+		return
+	}
+
+	// 单个 OR 条件会被 WHERE 拼接为 OR, 因此仅在多行时使用
+	match := keys[0]
+	if len(keys) > 1 {
+		match = clause.Or(keys...)
+	}
+
+	// 直接在当前连接上查询, 不经过其他插件的过滤
+	col := clause.Column{Name: field.DBName}
+	check := &gorm.Statement{DB: db, Context: stmt.Context, Clauses: map[string]clause.Clause{}}
+	check.WriteString("SELECT COUNT(*) FROM ")
+	check.WriteQuoted(stmt.Table)
+	check.WriteString(" WHERE ")
+	clause.Where{Exprs: []clause.Expression{
+		match,
+		clause.Or(clause.Neq{Column: col, Value: value}, clause.Eq{Column: col, Value: nil}),
+	}}.Build(check)
+	var n int64
+	if err := stmt.ConnPool.QueryRowContext(stmt.Context, check.SQL.String(), check.Vars...).Scan(&n); err != nil {
+		_ = db.AddError(err)
+		return
+	}
+	if n > 0 {
+		_ = db.AddError(crossTenantErr())
+	}
+}
+
+// guardCreateMap 校验 map 形式写入的租户, 未指定时补充当前租户
+func (tp *TenantPlugin) guardCreateMap(db *gorm.DB, field *schema.Field, m map[string]interface{}, tenantID string) {
+	if db.Error != nil {
+		return
+	}
+	for _, key := range tp.tenantKeys(field) {
+		if v, ok := m[key]; ok {
+			if !sameTenant(v, tenantID) {
+				_ = db.AddError(crossTenantErr())
+			}
+			return
+		}
+	}
+	m[tp.fieldColumn(field)] = idValue(field, tenantID)
+}
+
+// guardUpdate 从更新内容中移除租户字段, 禁止修改数据所属租户
+func (tp *TenantPlugin) guardUpdate(db *gorm.DB) {
+	stmt := db.Statement
+	field, _ := tp.tableColumn(stmt)
+	keys := tp.tenantKeys(field)
+
+	// 复制切片, 避免写入共享的底层数组
+	stmt.Omits = append(stmt.Omits[:len(stmt.Omits):len(stmt.Omits)], tp.fieldColumn(field))
+	switch dest := stmt.Dest.(type) {
+	case map[string]interface{}:
+		stmt.Dest = withoutKeys(dest, keys)
+	case *map[string]interface{}:
+		stmt.Dest = withoutKeys(*dest, keys)
+	}
+	if c, ok := stmt.Clauses["SET"]; ok {
+		if set, ok := c.Expression.(clause.Set); ok {
+			c.Expression = withoutAssignments(set, keys)
+			stmt.Clauses["SET"] = c
+		}
+	}
+}
+
+// buildOnConflict 包装 ON CONFLICT 构建器
+//
+// UpdateAll 在 gorm:create 内展开, 因此在构建 SQL 时处理: 移除租户字段的更新,
+// 并限制冲突行必须属于当前租户, 避免覆盖其他租户的数据
+func (tp *TenantPlugin) buildOnConflict(next clause.ClauseBuilder) clause.ClauseBuilder {
+	return func(c clause.Clause, builder clause.Builder) {
+		if stmt, ok := builder.(*gorm.Statement); ok {
+			if onConflict, ok := c.Expression.(clause.OnConflict); ok && !onConflict.DoNothing {
+				c.Expression = tp.guardOnConflict(stmt, onConflict)
+			}
+		}
+		if next != nil {
+			next(c, builder)
+			return
+		}
+		c.Build(builder)
+	}
+}
+
+func (tp *TenantPlugin) guardOnConflict(stmt *gorm.Statement, onConflict clause.OnConflict) clause.OnConflict {
+	if !tp.Enabled || stmt.Table == "" || bypassed(stmt, BypassTenant) {
+		return onConflict
+	}
+	tenantID, ok := stmt.Context.Value(auth.TenantIDKey).(string)
+	if !ok || tenantID == "" {
+		return onConflict
+	}
+	field, ok := tp.tableColumn(stmt)
+	if !ok {
+		return onConflict
+	}
+	column := tp.fieldColumn(field)
+	value := idValue(field, tenantID)
+	onConflict.DoUpdates = withoutAssignments(onConflict.DoUpdates, tp.tenantKeys(field))
+
+	if stmt.Dialector.Name() == "mysql" {
+		// ON DUPLICATE KEY UPDATE 不支持 WHERE, 冲突行属于其他租户时保持原值
+		tenantCol := clause.Column{Name: column}
+		for i, a := range onConflict.DoUpdates {
+			current := clause.Column{Name: a.Column.Name}
+			if c, ok := a.Value.(clause.Column); ok && c.Table == "excluded" {
+				a.Value = clause.Expr{SQL: "IF(? = ?, VALUES(?), ?)", Vars: []interface{}{tenantCol, value, current, current}}
+			} else {
+				a.Value = clause.Expr{SQL: "IF(? = ?, ?, ?)", Vars: []interface{}{tenantCol, value, a.Value, current}}
+			}
+			onConflict.DoUpdates[i] = a
+		}
+		return onConflict
+	}
+	if len(onConflict.DoUpdates) == 0 {
+		onConflict.DoNothing = true
+		return onConflict
+	}
+	onConflict.Where.Exprs = append(append([]clause.Expression{}, onConflict.Where.Exprs...),
+		clause.Eq{Column: clause.Column{Table: stmt.Table, Name: column}, Value: value})
+	return onConflict
+}
+
+// tenantKeys 返回租户字段可能使用的名称（列名与字段名）
+func (tp *TenantPlugin) tenantKeys(field *schema.Field) []string {
+	if field == nil {
+		return []string{tp.column(), tp.fieldName()}
+	}
+	return []string{field.DBName, field.Name}
+}
+
+func withoutKeys(m map[string]interface{}, keys []string) map[string]interface{} {
+	found := false
+	for _, k := range keys {
+		if _, ok := m[k]; ok {
+			found = true
+		}
+	}
+	if !found {
+		return m
+	}
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	for _, k := range keys {
+		delete(out, k)
+	}
+	return out
+}
+
+func withoutAssignments(set clause.Set, keys []string) clause.Set {
+	out := make(clause.Set, 0, len(set))
+	for _, a := range set {
+		skip := false
+		for _, k := range keys {
+			skip = skip || a.Column.Name == k
+		}
+		if !skip {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// sameTenant 比较字段值与当前租户ID, 兼容字符串、整型及指针
+func sameTenant(v interface{}, tenantID string) bool {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return false
+		}
+		rv = rv.Elem()
+	}
+	return rv.IsValid() && fmt.Sprint(rv.Interface()) == tenantID
+}
@@ -0,0 +1,108 @@
+package plugin_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+	"github.com/ovra-cloud/ovra-toolkit/errx"
+	"github.com/ovra-cloud/ovra-toolkit/gorm/plugin"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func TestTenantPluginWriteGuard(t *testing.T) {
+	db, rec := newSQLiteDB(t, &plugin.TenantPlugin{Enabled: true})
+	require.NoError(t, db.AutoMigrate(&Role{}))
+	seed := plugin.IgnoreTenant(context.Background())
+	require.NoError(t, db.WithContext(seed).Create(&[]Role{
+		{ID: 1, Name: "r1", TenantID: "1"},
+		{ID: 2, Name: "r2", TenantID: "2"},
+	}).Error)
+
+	ctx := context.WithValue(context.Background(), auth.TenantIDKey, "1")
+	load := func(id int64) Role {
+		var r Role
+		require.NoError(t, db.WithContext(seed).First(&r, id).Error)
+		return r
+	}
+
+	t.Run("拒绝写入其他租户", func(t *testing.T) {
+		err := db.WithContext(ctx).Create(&Role{ID: 3, Name: "r3", TenantID: "2"}).Error
+		assert.ErrorIs(t, err, plugin.ErrCrossTenant)
+		assert.Equal(t, int32(errx.CodeCrossTenant), errx.GORMErr(err).Code)
+
+		err = db.WithContext(ctx).Model(&Role{}).Create(map[string]interface{}{"ID": 4, "Name": "r4", "TenantID": "2"}).Error
+		assert.ErrorIs(t, err, plugin.ErrCrossTenant)
+	})
+
+	t.Run("更新时忽略租户字段", func(t *testing.T) {
+		require.NoError(t, db.WithContext(ctx).Model(&Role{ID: 1}).
+			Updates(map[string]interface{}{"name": "a", "tenant_id": "2"}).Error)
+		require.NoError(t, db.WithContext(ctx).Model(&Role{ID: 1}).Update("tenant_id", "2").Error)
+		require.NoError(t, db.WithContext(ctx).Model(&Role{ID: 1}).Updates(Role{Name: "b", TenantID: "2"}).Error)
+		r := load(1)
+		assert.Equal(t, "b", r.Name)
+		assert.Equal(t, "1", r.TenantID)
+	})
+
+	t.Run("Save 不覆盖其他租户数据", func(t *testing.T) {
+		err := db.WithContext(ctx).Save(&Role{ID: 2, Name: "hijack", TenantID: "1"}).Error
+		assert.ErrorIs(t, err, plugin.ErrCrossTenant)
+		assert.Equal(t, int32(errx.CodeCrossTenant), errx.GORMErr(err).Code)
+		assert.Equal(t, "r2", load(2).Name)
+
+		err = db.WithContext(ctx).Save(&[]Role{{ID: 1, Name: "a", TenantID: "1"}, {ID: 2, Name: "hijack", TenantID: "1"}}).Error
+		assert.ErrorIs(t, err, plugin.ErrCrossTenant)
+		assert.Equal(t, "r2", load(2).Name)
+
+		err = db.WithContext(ctx).Save(&Role{ID: 2, Name: "hijack", TenantID: "2"}).Error
+		assert.ErrorIs(t, err, plugin.ErrCrossTenant)
+
+		// 冲突行属于当前租户时正常更新, 冲突更新仍限制为当前租户
+		require.NoError(t, db.WithContext(ctx).Save(&[]Role{{ID: 1, Name: "s", TenantID: "1"}, {ID: 5, Name: "r5"}}).Error)
+		assert.Contains(t, rec.Last(), "ON CONFLICT (`id`) DO UPDATE SET")
+		assert.Contains(t, rec.Last(), "WHERE `roles`.`tenant_id` = \"1\"")
+		assert.Equal(t, "s", load(1).Name)
+		assert.Equal(t, "1", load(5).TenantID)
+	})
+
+	t.Run("Upsert", func(t *testing.T) {
+		err := db.WithContext(ctx).Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"name", "tenant_id"}),
+		}).Create(&Role{ID: 2, Name: "hijack"}).Error
+		assert.ErrorIs(t, err, plugin.ErrCrossTenant)
+		r := load(2)
+		assert.Equal(t, "r2", r.Name)
+		assert.Equal(t, "2", r.TenantID)
+
+		// DO NOTHING 不更新冲突行
+		require.NoError(t, db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&Role{ID: 2, Name: "hijack"}).Error)
+		assert.Equal(t, "r2", load(2).Name)
+
+		require.NoError(t, db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).
+			Create(&Role{ID: 1, Name: "c"}).Error)
+		assert.Equal(t, "c", load(1).Name)
+	})
+
+	t.Run("错误不共享", func(t *testing.T) {
+		err1 := db.WithContext(ctx).Create(&Role{ID: 6, TenantID: "2"}).Error
+		err2 := db.WithContext(ctx).Create(&Role{ID: 7, TenantID: "2"}).Error
+		errx.GORMErr(err1).Message = "changed"
+		assert.Equal(t, "禁止跨租户写入数据", errx.GORMErr(err2).Message)
+	})
+}
+
+func TestTenantPluginUpsertMySQL(t *testing.T) {
+	db := newDryRunDB(t, &plugin.TenantPlugin{Enabled: true})
+	ctx := context.WithValue(context.Background(), auth.TenantIDKey, "1")
+	stmt := db.WithContext(ctx).Session(&gorm.Session{SkipDefaultTransaction: true}).
+		Clauses(clause.OnConflict{UpdateAll: true}).Create(&Role{ID: 1, Name: "a"}).Statement
+	assert.Equal(t, "INSERT INTO `roles` (`name`,`tenant_id`,`id`) VALUES (?,?,?) "+
+		"ON DUPLICATE KEY UPDATE `name`=IF(`tenant_id` = ?, VALUES(`name`), `name`)", stmt.SQL.String())
+}
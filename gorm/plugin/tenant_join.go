@@ -0,0 +1,155 @@
+package plugin
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/zeromicro/go-zero/core/logx"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// joinPlaceholder 改写字符串 Joins 时使用的占位主表
+const joinPlaceholder = "__tenant_join__"
+
+// addTenantJoins 为 Joins 追加租户条件
+//
+// 关联 Joins（Joins("Dept") / Joins("Manager.Company")）按关联模型字段判断是否为租户表,
+// 条件追加到 JOIN ON; 字符串 Joins 按原生 SQL 规则改写
+func (tp *TenantPlugin) addTenantJoins(db *gorm.DB, tenantID string) {
+	stmt := db.Statement
+	if len(stmt.Joins) == 0 {
+		return
+	}
+	// Settings 会随 Session 复制, 以 Joins 底层数组判断是否为同一语句
+	if v, ok := stmt.Settings.Load("tenant:joins"); ok && v == &stmt.Joins[0] {
+		return
+	}
+
+	joins := stmt.Joins[:0:0]
+	for _, join := range stmt.Joins {
+		if join.Expression != nil {
+			joins = append(joins, join)
+			continue
+		}
+		rels := joinRelations(stmt.Schema, join.Name)
+		if rels == nil {
+			tp.rewriteJoinSQL(db, &join.Name, &join.Conds, tenantID)
+			joins = append(joins, join)
+			continue
+		}
+
+		// 整条关联链均为租户表且租户列相同时, ON 条件会应用到链上的每个表
+		first := tp.relationField(rels[0])
+		all := first != nil
+		for _, rel := range rels[1:] {
+			f := tp.relationField(rel)
+			all = all && f != nil && f.DBName == first.DBName
+		}
+		if all {
+			join.On = tenantOn(join.On, first, tenantID)
+			joins = append(joins, join)
+			continue
+		}
+
+		// 关联链中存在非租户表时拆分为逐级的 Joins, 每级只在租户表的 ON 中追加条件;
+		// GORM 按别名去重, 前缀关联只生成一次 JOIN
+		names := strings.Split(join.Name, ".")
+		if len(rels) == 1 {
+			names = []string{join.Name}
+		}
+		for idx, rel := range rels {
+			level := join
+			if idx < len(rels)-1 {
+				level.Name, level.Alias = strings.Join(names[:idx+1], "."), ""
+			}
+			if field := tp.relationField(rel); field != nil {
+				level.On = tenantOn(join.On, field, tenantID)
+			}
+			joins = append(joins, level)
+		}
+	}
+	stmt.Joins = joins
+	stmt.Settings.Store("tenant:joins", &stmt.Joins[0])
+}
+
+// tenantOn 在 JOIN ON 条件中追加租户条件
+func tenantOn(on *clause.Where, field *schema.Field, tenantID string) *clause.Where {
+	cond := clause.Eq{
+		Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName},
+		Value:  idValue(field, tenantID),
+	}
+	res := &clause.Where{Exprs: []clause.Expression{cond}}
+	if on != nil {
+		res.Exprs = append(append([]clause.Expression{}, on.Exprs...), cond)
+	}
+	return res
+}
+
+// relationField 返回关联模型的租户字段, 非租户表返回 nil
+func (tp *TenantPlugin) relationField(rel *schema.Relationship) *schema.Field {
+	s := rel.FieldSchema
+	if tp.isIgnored(s.Table) {
+		return nil
+	}
+	return tp.tenantField(s)
+}
+
+// joinRelations 解析关联 Joins 对应的关联链, 非关联（字符串 Joins）返回 nil
+func joinRelations(s *schema.Schema, name string) []*schema.Relationship {
+	if s == nil {
+		return nil
+	}
+	if rel, ok := s.Relationships.Relations[name]; ok {
+		return []*schema.Relationship{rel}
+	}
+	names := strings.Split(name, ".")
+	if len(names) < 2 {
+		return nil
+	}
+	rels := make([]*schema.Relationship, 0, len(names))
+	current := s.Relationships.Relations
+	for _, n := range names {
+		rel, ok := current[n]
+		if !ok {
+			return nil
+		}
+		rels = append(rels, rel)
+		current = rel.FieldSchema.Relationships.Relations
+	}
+	return rels
+}
+
+// rewriteJoinSQL 改写字符串 Joins, 如 Joins("LEFT JOIN dept d ON d.id = user.dept_id")
+func (tp *TenantPlugin) rewriteJoinSQL(db *gorm.DB, joinSQL *string, conds *[]interface{}, tenantID string) {
+	for _, c := range *conds {
+		switch c.(type) {
+		case map[string]interface{}, sql.NamedArg:
+			tp.rejectJoin(db, ErrTenantUnsafeSQL, *joinSQL)
+			return
+		}
+	}
+	prefix := "SELECT * FROM " + joinPlaceholder + " "
+	resolve := tp.rawResolver(db.Statement, tenantID)
+	s, vars, err := rewriteTenantSQL(prefix+*joinSQL, *conds, func(table string) (string, interface{}, bool) {
+		if table == joinPlaceholder {
+			return "", nil, false
+		}
+		return resolve(table)
+	})
+	if err != nil {
+		tp.rejectJoin(db, err, *joinSQL)
+		return
+	}
+	*joinSQL = strings.TrimPrefix(s, prefix)
+	*conds = vars
+}
+
+func (tp *TenantPlugin) rejectJoin(db *gorm.DB, err error, joinSQL string) {
+	if tp.Strict {
+		_ = db.AddError(err)
+		return
+	}
+	logx.WithContext(db.Statement.Context).Errorf("[tenant] join not rewritten: %s, %v", joinSQL, err)
+}
@@ -0,0 +1,147 @@
+package plugin_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+	"github.com/ovra-cloud/ovra-toolkit/gorm/plugin"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Region struct {
+	ID   int64
+	Name string
+}
+
+type Dept struct {
+	ID       int64
+	Name     string
+	TenantID string
+	RegionID int64
+	Region   Region
+}
+
+type Role struct {
+	ID       int64
+	Name     string
+	TenantID string
+}
+
+type Comment struct {
+	ID       int64
+	PostID   int64
+	Body     string
+	TenantID string
+}
+
+type Post struct {
+	ID       int64
+	MemberID int64
+	Title    string
+	TenantID string
+	Comments []Comment
+}
+
+type Member struct {
+	ID       int64
+	Name     string
+	TenantID string
+	DeptID   int64
+	Dept     Dept
+	Posts    []Post
+	Roles    []Role `gorm:"many2many:member_roles"`
+}
+
+func TestTenantPluginJoins(t *testing.T) {
+	db, rec := newSQLiteDB(t, &plugin.TenantPlugin{Enabled: true, Strict: true})
+	require.NoError(t, db.AutoMigrate(&Region{}, &Dept{}, &Role{}, &Comment{}, &Post{}, &Member{}))
+
+	// 写入两个租户的数据, 其中包含跨租户的脏关联
+	seed := plugin.IgnoreTenant(context.Background())
+	require.NoError(t, db.WithContext(seed).Create(&[]Region{{ID: 1, Name: "east"}}).Error)
+	require.NoError(t, db.WithContext(seed).Create(&[]Dept{
+		{ID: 1, Name: "d1", TenantID: "1", RegionID: 1},
+		{ID: 2, Name: "d2", TenantID: "2", RegionID: 1},
+	}).Error)
+	require.NoError(t, db.WithContext(seed).Create(&[]Role{
+		{ID: 1, Name: "r1", TenantID: "1"},
+		{ID: 2, Name: "r2", TenantID: "2"},
+	}).Error)
+	require.NoError(t, db.WithContext(seed).Create(&[]Member{
+		{ID: 1, Name: "m1", TenantID: "1", DeptID: 1},
+		{ID: 2, Name: "m2", TenantID: "1", DeptID: 2},
+	}).Error)
+	require.NoError(t, db.WithContext(seed).Create(&[]Post{
+		{ID: 1, MemberID: 1, Title: "p1", TenantID: "1"},
+		{ID: 2, MemberID: 1, Title: "p2", TenantID: "2"},
+	}).Error)
+	require.NoError(t, db.WithContext(seed).Create(&[]Comment{
+		{ID: 1, PostID: 1, Body: "c1", TenantID: "1"},
+		{ID: 2, PostID: 1, Body: "c2", TenantID: "2"},
+	}).Error)
+	require.NoError(t, db.WithContext(seed).Exec("INSERT INTO member_roles (member_id, role_id) VALUES (1, 1), (1, 2)").Error)
+
+	ctx := context.WithValue(context.Background(), auth.TenantIDKey, "1")
+
+	t.Run("一对多与嵌套 Preload", func(t *testing.T) {
+		var m Member
+		require.NoError(t, db.WithContext(ctx).Preload("Posts.Comments").First(&m, 1).Error)
+		require.Len(t, m.Posts, 1)
+		assert.Equal(t, "p1", m.Posts[0].Title)
+		require.Len(t, m.Posts[0].Comments, 1)
+		assert.Equal(t, "c1", m.Posts[0].Comments[0].Body)
+	})
+
+	t.Run("多对多 Preload", func(t *testing.T) {
+		rec.Reset()
+		var m Member
+		require.NoError(t, db.WithContext(ctx).Preload("Roles").First(&m, 1).Error)
+		require.Len(t, m.Roles, 1)
+		assert.Equal(t, "r1", m.Roles[0].Name)
+		for _, s := range rec.All() {
+			if strings.HasPrefix(s, "SELECT * FROM `member_roles`") {
+				assert.NotContains(t, s, "tenant_id")
+			}
+		}
+	})
+
+	t.Run("关联查询", func(t *testing.T) {
+		var roles []Role
+		require.NoError(t, db.WithContext(ctx).Model(&Member{ID: 1}).Association("Roles").Find(&roles))
+		assert.Len(t, roles, 1)
+	})
+
+	t.Run("关联 Joins", func(t *testing.T) {
+		var members []Member
+		require.NoError(t, db.WithContext(ctx).Joins("Dept").Order("members.id").Find(&members).Error)
+		assert.Contains(t, rec.Last(), "`Dept`.`tenant_id` = \"1\"")
+		require.Len(t, members, 2)
+		assert.Equal(t, "d1", members[0].Dept.Name)
+		assert.Empty(t, members[1].Dept.Name)
+	})
+
+	t.Run("嵌套 Joins 包含非租户表", func(t *testing.T) {
+		var members []Member
+		require.NoError(t, db.WithContext(ctx).Joins("Dept.Region").Order("members.id").Find(&members).Error)
+		assert.NotContains(t, rec.Last(), "`Dept__Region`.`tenant_id`")
+		assert.Contains(t, rec.Last(), "ON `members`.`dept_id` = `Dept`.`id` AND `Dept`.`tenant_id` = \"1\" LEFT JOIN")
+		assert.NotContains(t, rec.Last(), "WHERE `Dept`")
+		require.Len(t, members, 2)
+		assert.Equal(t, "east", members[0].Dept.Region.Name)
+		// 其他租户的部门不关联, 但保留成员
+		assert.Empty(t, members[1].Dept.Name)
+		assert.Empty(t, members[1].Dept.Region.Name)
+	})
+
+	t.Run("字符串 Joins", func(t *testing.T) {
+		var names []string
+		require.NoError(t, db.WithContext(ctx).Model(&Member{}).
+			Joins("JOIN depts d ON d.id = members.dept_id AND d.name <> ?", "x").
+			Pluck("d.name", &names).Error)
+		assert.Equal(t, []string{"d1"}, names)
+	})
+}
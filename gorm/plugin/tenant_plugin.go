@@ -1,18 +1,48 @@
 package plugin
 
 import (
+	"errors"
+	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/ovra-cloud/ovra-toolkit/auth"
+	"github.com/zeromicro/go-zero/core/logx"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
 )
 
+const (
+	DefaultTenantColumn = "tenant_id" // 默认租户列名
+	DefaultTenantField  = "TenantID"  // 默认租户字段名
+
+	// TenantTag 租户字段标签, 如 `tenant:"scoped"`, 带该标签的字段作为租户字段
+	TenantTag = "tenant"
+)
+
+// TenantScoped 模型实现该接口并返回 true 时视为租户表, 配合 TenantPlugin.OptIn 使用
+type TenantScoped interface {
+	TenantScoped() bool
+}
+
 // TenantPlugin 多租户插件
 type TenantPlugin struct {
 	Enabled      bool     // 是否启用多租户
 	IgnoreTables []string // 忽略多租户处理的表名
+	Strict       bool     // 原生 SQL 无法安全改写时拒绝执行, 否则仅记录日志
+	Column       string   // 租户列名, 默认 tenant_id
+	Field        string   // 租户字段名, 默认 TenantID
+	OptIn        bool     // 仅处理实现 TenantScoped 或字段带 tenant 标签的模型
+
+	// Models 原生 SQL 与 db.Table 按这些模型判断表是否有租户列, 使用过的模型会自动登记;
+	// 未登记的表按 Column 追加条件, OptIn 时不处理
+	Models []interface{}
+
+	fields sync.Map // *schema.Schema => *schema.Field
+	tables sync.Map // 小写表名 => *schema.Field, 非租户表为 nil
 }
 
 // Name 插件名称
@@ -31,9 +61,130 @@ func (tp *TenantPlugin) shouldSkip(db *gorm.DB) bool {
 	if !tp.Enabled || db.Statement == nil || db.Statement.Table == "" {
 		return true
 	}
-	table := strings.ToLower(db.Statement.Table)
+	if _, ok := tp.tableColumn(db.Statement); !ok {
+		return true
+	}
+	return isBypassed(db, BypassTenant)
+}
+
+func (tp *TenantPlugin) column() string {
+	if tp.Column == "" {
+		return DefaultTenantColumn
+	}
+	return tp.Column
+}
+
+func (tp *TenantPlugin) fieldName() string {
+	if tp.Field == "" {
+		return DefaultTenantField
+	}
+	return tp.Field
+}
+
+// tenantField 返回模型的租户字段, 非租户模型返回 nil
+//
+// 优先使用带 tenant 标签的字段; OptIn 时模型需实现 TenantScoped;
+// 否则按 Field / Column 查找, 模型中不存在租户字段时自动跳过
+func (tp *TenantPlugin) tenantField(s *schema.Schema) *schema.Field {
+	if v, ok := tp.fields.Load(s); ok {
+		return v.(*schema.Field)
+	}
+	var field *schema.Field
+	for _, f := range s.Fields {
+		if _, ok := f.Tag.Lookup(TenantTag); ok && f.DBName != "" {
+			field = f
+			break
+		}
+	}
+	if field == nil {
+		scoped := !tp.OptIn
+		if tp.OptIn {
+			m, ok := reflect.New(s.ModelType).Interface().(TenantScoped)
+			scoped = ok && m.TenantScoped()
+		}
+		if scoped {
+			if field = s.LookUpField(tp.fieldName()); field == nil {
+				field = s.LookUpField(tp.column())
+			}
+		}
+	}
+	if field != nil && field.DBName == "" {
+		field = nil
+	}
+	tp.fields.Store(s, field)
+	tp.tables.Store(strings.ToLower(s.Table), field)
+	return field
+}
+
+// tableField 按表名返回租户字段, 未登记的表 field 为 nil, OptIn 时视为非租户表
+func (tp *TenantPlugin) tableField(table string) (*schema.Field, bool) {
+	if tp.isIgnored(table) {
+		return nil, false
+	}
+	if v, ok := tp.tables.Load(strings.ToLower(table)); ok {
+		f := v.(*schema.Field)
+		return f, f != nil
+	}
+	return nil, !tp.OptIn
+}
+
+// rawResolver 返回原生 SQL 中表的租户列与租户值
+func (tp *TenantPlugin) rawResolver(stmt *gorm.Statement, tenantID string) tenantResolver {
+	return func(table string) (string, interface{}, bool) {
+		f, ok := tp.tableField(table)
+		if !ok {
+			return "", nil, false
+		}
+		return stmt.Quote(tp.fieldColumn(f)), idValue(f, tenantID), true
+	}
+}
+
+// tableColumn 返回当前语句主表的租户列与字段, 表名与模型不一致（db.Table）时按表名判断
+func (tp *TenantPlugin) tableColumn(stmt *gorm.Statement) (*schema.Field, bool) {
+	if tp.isIgnored(stmt.Table) {
+		return nil, false
+	}
+	if stmt.Schema == nil || stmt.Schema.Table != stmt.Table {
+		return tp.tableField(stmt.Table)
+	}
+	f := tp.tenantField(stmt.Schema)
+	return f, f != nil
+}
+
+// idValue 按字段类型转换租户、用户等ID, 支持字符串与整型
+func idValue(f *schema.Field, id string) interface{} {
+	if f == nil {
+		return id
+	}
+	t := f.FieldType
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v, err := strconv.ParseInt(id, 10, 64); err == nil {
+			return v
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v, err := strconv.ParseUint(id, 10, 64); err == nil {
+			return v
+		}
+	}
+	return id
+}
+
+// fieldColumn 返回字段列名, 无字段时使用配置的列名
+func (tp *TenantPlugin) fieldColumn(f *schema.Field) string {
+	if f == nil {
+		return tp.column()
+	}
+	return f.DBName
+}
+
+// isIgnored 判断表是否在 IgnoreTables 中
+func (tp *TenantPlugin) isIgnored(table string) bool {
 	for _, t := range tp.IgnoreTables {
-		if table == strings.ToLower(t) {
+		if strings.EqualFold(table, t) {
 			return true
 		}
 	}
@@ -42,55 +193,52 @@ func (tp *TenantPlugin) shouldSkip(db *gorm.DB) bool {
 
 // Initialize 注册 GORM 插件回调
 func (tp *TenantPlugin) Initialize(db *gorm.DB) error {
+	for _, model := range tp.Models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+		tp.tenantField(stmt.Schema)
+	}
 	// ===== Query =====
 	if err := db.Callback().Query().Before("gorm:query").
-		Register("tenant:query", func(db *gorm.DB) {
-			if tp.shouldSkip(db) {
-				return
-			}
-			if tenantID, ok := getTenantID(db); ok {
-				addTenantWhereIfAbsent(db, tenantID)
-			}
-		}); err != nil {
+		Register("tenant:query", tp.queryCallback); err != nil {
+		return err
+	}
+	// ===== Row / Raw =====
+	if err := db.Callback().Row().Before("gorm:row").
+		Register("tenant:row", tp.queryCallback); err != nil {
+		return err
+	}
+	if err := db.Callback().Raw().Before("gorm:raw").
+		Register("tenant:raw", tp.rewriteRaw); err != nil {
 		return err
 	}
 
 	// ===== Create =====
 	if err := db.Callback().Create().Before("gorm:create").
-		Register("tenant:create", func(db *gorm.DB) {
-			if tp.shouldSkip(db) {
-				return
-			}
-			tenantID, ok := getTenantID(db)
-			if !ok || tenantID == "" {
-				return
-			}
-			WalkStruct(db.Statement.ReflectValue, func(v reflect.Value) {
-				setTenantIDIfEmpty(v, tenantID)
-			})
-		}); err != nil {
+		Register("tenant:create", tp.guardCreate); err != nil {
 		return err
 	}
+	db.ClauseBuilders["ON CONFLICT"] = tp.buildOnConflict(db.ClauseBuilders["ON CONFLICT"])
 	// ===== Update =====
 	if err := db.Callback().Update().Before("gorm:update").
 		Register("tenant:update", func(db *gorm.DB) {
 			if tp.shouldSkip(db) {
 				return
 			}
-			if tenantID, ok := getTenantID(db); ok {
-				addTenantWhereIfAbsent(db, tenantID)
+			if _, ok := getTenantID(db); ok {
+				tp.guardUpdate(db)
 			}
+			tp.whereCallback(db)
 		}); err != nil {
 		return err
 	}
 	// ===== Delete =====
 	if err := db.Callback().Delete().Before("gorm:delete").
 		Register("tenant:delete", func(db *gorm.DB) {
-			if tp.shouldSkip(db) {
-				return
-			}
-			if tenantID, ok := getTenantID(db); ok {
-				addTenantWhereIfAbsent(db, tenantID)
+			if !tp.shouldSkip(db) {
+				tp.whereCallback(db)
 			}
 		}); err != nil {
 		return err
@@ -98,33 +246,81 @@ func (tp *TenantPlugin) Initialize(db *gorm.DB) error {
 	return nil
 }
 
-func setTenantIDIfEmpty(v reflect.Value, tenantID string) {
-	field := v.FieldByName("TenantID")
-	if !field.IsValid() || !field.CanSet() {
+// whereCallback 更新、删除条件追加租户
+func (tp *TenantPlugin) whereCallback(db *gorm.DB) {
+	if tenantID, ok := getTenantID(db); ok {
+		field, _ := tp.tableColumn(db.Statement)
+		addWhereIfAbsent(db, tp.fieldColumn(field), idValue(field, tenantID))
+	}
+}
+
+// queryCallback 查询条件追加租户, 已有原生 SQL 时改写 SQL
+func (tp *TenantPlugin) queryCallback(db *gorm.DB) {
+	if db.Statement != nil && db.Statement.SQL.Len() > 0 {
+		tp.rewriteRaw(db)
 		return
 	}
-	if field.Kind() != reflect.String {
+	if !tp.Enabled || db.Statement == nil || db.Statement.Table == "" {
+		return
+	}
+	tenantID, ok := getTenantID(db)
+	if !ok || tenantID == "" || isBypassed(db, BypassTenant) {
 		return
 	}
-	if field.String() == "" {
-		field.SetString(tenantID)
+	if field, ok := tp.tableColumn(db.Statement); ok {
+		addWhereIfAbsent(db, tp.fieldColumn(field), idValue(field, tenantID))
 	}
+	tp.addTenantJoins(db, tenantID)
 }
 
-func addTenantWhereIfAbsent(db *gorm.DB, tenantID string) {
-	if _, ok := db.Statement.Clauses["tenant:where"]; ok {
+// rewriteRaw 为原生 SQL（Raw / Exec / Row）中的每个表引用追加租户条件
+func (tp *TenantPlugin) rewriteRaw(db *gorm.DB) {
+	if !tp.Enabled || db.Statement == nil || db.Statement.SQL.Len() == 0 {
+		return
+	}
+	if v, ok := db.Statement.Settings.Load("tenant:raw"); ok && v == db.Statement.SQL.String() {
 		return
 	}
+	tenantID, ok := getTenantID(db)
+	if !ok || tenantID == "" || isBypassed(db, BypassTenant) {
+		return
+	}
+	sql, vars, err := rewriteTenantSQL(db.Statement.SQL.String(), db.Statement.Vars, tp.rawResolver(db.Statement, tenantID))
+	if err != nil {
+		if tp.Strict {
+			_ = db.AddError(err)
+		} else if !errors.Is(err, errTenantNonDML) {
+			logx.WithContext(db.Statement.Context).Errorf("[tenant] raw sql not rewritten: %v", err)
+		}
+		return
+	}
+	db.Statement.SQL.Reset()
+	db.Statement.SQL.WriteString(sql)
+	db.Statement.Vars = vars
+	db.Statement.Settings.Store("tenant:raw", sql)
+}
+
+// addWhereIfAbsent 当前语句的 WHERE 中不存在该列等于当前租户的条件时追加
+//
+// 已有的其他租户条件不会跳过追加, 两个条件同时生效时查询结果为空
+func addWhereIfAbsent(db *gorm.DB, column string, value interface{}) {
+	// Settings 会随 Session 复制到 Preload 等子语句, 因此直接检查当前语句的 WHERE
+	col := clause.Column{Table: db.Statement.Table, Name: column}
+	if c, ok := db.Statement.Clauses["WHERE"]; ok {
+		if where, ok := c.Expression.(clause.Where); ok {
+			for _, expr := range where.Exprs {
+				if eq, ok := expr.(clause.Eq); ok && eq.Column == col && fmt.Sprint(eq.Value) == fmt.Sprint(value) {
+					return
+				}
+			}
+		}
+	}
 	db.Statement.AddClause(clause.Where{
 		Exprs: []clause.Expression{
 			clause.Eq{
-				Column: clause.Column{
-					Table: db.Statement.Table,
-					Name:  "tenant_id",
-				},
-				Value: tenantID,
+				Column: col,
+				Value:  value,
 			},
 		},
 	})
-	db.Statement.Settings.Store("tenant:where", true)
 }
@@ -11,9 +11,12 @@ import (
 	"github.com/ovra-cloud/ovra-toolkit/gorm/plugin"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/logx/logtest"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 	"gorm.io/gorm/schema"
 )
@@ -101,3 +104,147 @@ func TestTenantPlugin(t *testing.T) {
 		//assert.Equal(t, int64(0), user.TenantID)
 	})
 }
+
+func TestTenantPluginRaw(t *testing.T) {
+	db := newDryRunDB(t, &plugin.TenantPlugin{Enabled: true, Strict: true})
+	ctx := context.WithValue(context.Background(), auth.TenantIDKey, "1")
+
+	t.Run("Exec", func(t *testing.T) {
+		stmt := db.WithContext(ctx).Exec("UPDATE test_user SET name = ? WHERE id = ?", "a", 1).Statement
+		assert.Equal(t, "UPDATE test_user SET name = ? WHERE test_user.`tenant_id` = ? AND (id = ?)", stmt.SQL.String())
+		assert.Equal(t, []interface{}{"a", "1", 1}, stmt.Vars)
+	})
+
+	t.Run("Raw Scan", func(t *testing.T) {
+		var users []User
+		stmt := db.WithContext(ctx).Raw("SELECT * FROM test_user u JOIN test_dept d ON d.id = u.dept_id").Scan(&users).Statement
+		assert.Equal(t, "SELECT * FROM test_user u JOIN test_dept d ON d.`tenant_id` = ? AND (d.id = u.dept_id) WHERE u.`tenant_id` = ?", stmt.SQL.String())
+	})
+
+	t.Run("Row", func(t *testing.T) {
+		stmt := db.WithContext(ctx).Model(&User{}).Select("name").Where("id = ?", 1)
+		_ = stmt.Row()
+		assert.Contains(t, stmt.Statement.SQL.String(), "`test_user`.`tenant_id` = ?")
+	})
+
+	t.Run("已有租户条件", func(t *testing.T) {
+		tenantEq := func(v string) clause.Eq {
+			return clause.Eq{Column: clause.Column{Table: "test_user", Name: "tenant_id"}, Value: v}
+		}
+		stmt := db.WithContext(ctx).Where(tenantEq("1")).Find(&[]User{}).Statement
+		assert.Equal(t, "SELECT * FROM `test_user` WHERE `test_user`.`tenant_id` = ?", stmt.SQL.String())
+
+		// 其他租户的条件不能替代当前租户条件
+		stmt = db.WithContext(ctx).Where(tenantEq("2")).Find(&[]User{}).Statement
+		assert.Equal(t, "SELECT * FROM `test_user` WHERE `test_user`.`tenant_id` = ? AND `test_user`.`tenant_id` = ?", stmt.SQL.String())
+		assert.Equal(t, []interface{}{"2", "1"}, stmt.Vars)
+	})
+
+	t.Run("严格模式拒绝", func(t *testing.T) {
+		err := db.WithContext(ctx).Exec("TRUNCATE test_user").Error
+		assert.ErrorIs(t, err, plugin.ErrTenantUnsafeSQL)
+	})
+
+	t.Run("严格模式拒绝写入其他租户", func(t *testing.T) {
+		err := db.WithContext(ctx).Exec("INSERT INTO test_user (id, tenant_id) VALUES (?, ?)", 1, "2").Error
+		assert.ErrorIs(t, err, plugin.ErrTenantUnsafeSQL)
+		err = db.WithContext(ctx).Exec("INSERT INTO test_user (id, tenant_id) VALUES (?, ?) ON DUPLICATE KEY UPDATE tenant_id = ?", 1, "1", "2").Error
+		assert.ErrorIs(t, err, plugin.ErrTenantUnsafeSQL)
+		err = db.WithContext(ctx).Exec("UPDATE test_user SET tenant_id = ? WHERE id = ?", "2", 1).Error
+		assert.ErrorIs(t, err, plugin.ErrTenantUnsafeSQL)
+		assert.NoError(t, db.WithContext(ctx).Exec("INSERT INTO test_user (id, tenant_id) VALUES (?, ?)", 1, "1").Error)
+	})
+}
+
+type Org struct {
+	ID    int64
+	Name  string
+	OrgNo int64 `tenant:"scoped"`
+}
+
+type Notice struct {
+	ID       int64
+	Title    string
+	TenantID int64
+}
+
+func (Notice) TenantScoped() bool { return true }
+
+type Dict struct {
+	ID       int64
+	Label    string
+	TenantID int64
+}
+
+type Config struct {
+	ID  int64
+	Key string
+}
+
+func TestTenantPluginConfig(t *testing.T) {
+	ctx := context.WithValue(context.Background(), auth.TenantIDKey, "7")
+
+	t.Run("标签字段与整型租户", func(t *testing.T) {
+		db, _ := newSQLiteDB(t, &plugin.TenantPlugin{Enabled: true})
+		require.NoError(t, db.AutoMigrate(&Org{}))
+		require.NoError(t, db.WithContext(plugin.IgnoreTenant(ctx)).Create(&Org{Name: "other", OrgNo: 8}).Error)
+
+		org := Org{Name: "a"}
+		require.NoError(t, db.WithContext(ctx).Create(&org).Error)
+		assert.Equal(t, int64(7), org.OrgNo)
+
+		var orgs []Org
+		require.NoError(t, db.WithContext(ctx).Find(&orgs).Error)
+		require.Len(t, orgs, 1)
+		assert.Equal(t, "a", orgs[0].Name)
+	})
+
+	t.Run("OptIn 仅处理声明的模型", func(t *testing.T) {
+		db := newDryRunDB(t, &plugin.TenantPlugin{Enabled: true, OptIn: true})
+		stmt := db.WithContext(ctx).Find(&[]Notice{}).Statement
+		assert.Contains(t, stmt.SQL.String(), "`notices`.`tenant_id` = ?")
+		assert.Equal(t, []interface{}{int64(7)}, stmt.Vars)
+
+		stmt = db.WithContext(ctx).Find(&[]Dict{}).Statement
+		assert.NotContains(t, stmt.SQL.String(), "tenant_id")
+
+		// 未登记的表不处理, 已登记的租户表按模型处理
+		var dicts []map[string]interface{}
+		stmt = db.WithContext(ctx).Table("dicts").Find(&dicts).Statement
+		assert.Equal(t, "SELECT * FROM `dicts`", stmt.SQL.String())
+		stmt = db.WithContext(ctx).Table("notices").Find(&dicts).Statement
+		assert.Equal(t, "SELECT * FROM `notices` WHERE `notices`.`tenant_id` = ?", stmt.SQL.String())
+		assert.Equal(t, []interface{}{int64(7)}, stmt.Vars)
+		stmt = db.WithContext(ctx).Table("audit_logs").Find(&dicts).Statement
+		assert.Equal(t, "SELECT * FROM `audit_logs`", stmt.SQL.String())
+	})
+
+	t.Run("自定义列名与无租户列的表", func(t *testing.T) {
+		db, rec := newSQLiteDB(t, &plugin.TenantPlugin{Enabled: true, Column: "org_no", Field: "OrgNo"})
+		require.NoError(t, db.AutoMigrate(&Org{}, &Config{}))
+
+		require.NoError(t, db.WithContext(ctx).Delete(&Org{}, 1).Error)
+		assert.Contains(t, rec.Last(), "`orgs`.`org_no` = 7")
+
+		require.NoError(t, db.WithContext(ctx).Find(&[]Config{}).Error)
+		assert.NotContains(t, rec.Last(), "WHERE")
+
+		require.NoError(t, db.WithContext(ctx).Exec("DELETE FROM orgs WHERE id = ?", 1).Error)
+		assert.Contains(t, rec.Last(), "orgs.`org_no` = 7")
+
+		// 没有租户列的表不追加条件
+		require.NoError(t, db.WithContext(ctx).Exec("DELETE FROM configs WHERE id = ?", 1).Error)
+		assert.Equal(t, "DELETE FROM configs WHERE id = 1", rec.Last())
+	})
+
+	t.Run("登记模型与非严格模式", func(t *testing.T) {
+		db, rec := newSQLiteDB(t, &plugin.TenantPlugin{Enabled: true, Models: []interface{}{&Config{}}})
+		require.NoError(t, db.AutoMigrate(&Config{}))
+		logs := logtest.NewCollector(t)
+
+		require.NoError(t, db.WithContext(ctx).Exec("UPDATE configs SET key = ?", "k").Error)
+		assert.Equal(t, "UPDATE configs SET key = \"k\"", rec.Last())
+		require.NoError(t, db.WithContext(ctx).Exec("CREATE INDEX idx_key ON configs (key)").Error)
+		assert.Empty(t, logs.String())
+	})
+}
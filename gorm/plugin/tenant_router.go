@@ -0,0 +1,344 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/zeromicro/go-zero/core/syncx"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// DefaultCloseDelay 回收的租户连接默认延迟关闭时间
+const DefaultCloseDelay = time.Minute
+
+// IsolationMode 租户隔离方式
+type IsolationMode int
+
+const (
+	IsolationShared   IsolationMode = iota // 共享表, 通过 tenant_id 隔离
+	IsolationSchema                        // 独立 schema, 沿用默认库的连接参数, 独立连接池
+	IsolationDatabase                      // 独立数据库, 独立连接池
+)
+
+// TenantDBConfig 租户数据库配置
+type TenantDBConfig struct {
+	TenantId string
+	Mode     IsolationMode
+	DSN      string // IsolationDatabase 使用; IsolationSchema 为空时使用默认库的 DSN
+	Schema   string // IsolationSchema 使用, 作为连接的默认库
+}
+
+// TenantDBResolver 获取租户数据库配置, 返回 nil 表示使用共享表
+type TenantDBResolver func(ctx context.Context, tenantId string) (*TenantDBConfig, error)
+
+// TenantDBOpener 按租户配置打开数据库
+type TenantDBOpener func(cfg *TenantDBConfig) (*gorm.DB, error)
+
+// TenantRouter 根据上下文中的租户选择数据库
+//
+// 共享表租户使用 Default, 独立 schema / 数据库的租户在首次访问时打开连接,
+// 空闲超过 IdleTimeout 或超过 MaxTenants 时按最久未使用回收.
+// 回收的连接在 CloseDelay 后且没有使用中的连接时才关闭, 避免影响已取得连接的请求
+type TenantRouter struct {
+	Default  *gorm.DB         // 共享表使用的数据库
+	Resolver TenantDBResolver // 租户配置, 为 nil 时全部使用 Default
+	Opener   TenantDBOpener   // 为 nil 时使用 MySQLOpener(Default)
+	Plugins  []gorm.Plugin    // 新打开的数据库需要注册的插件
+
+	MaxOpenConns    int           // 独立数据库的最大连接数
+	MaxIdleConns    int           // 独立数据库的最大空闲连接数
+	ConnMaxLifetime time.Duration // 独立数据库连接最长存活时间
+	IdleTimeout     time.Duration // 租户连接空闲回收时间, 为 0 时不回收
+	MaxTenants      int           // 同时保持的租户连接数, 为 0 时不限制
+	CloseDelay      time.Duration // 回收后延迟关闭的时间, 为 0 时使用 DefaultCloseDelay
+
+	mu     sync.Mutex
+	pools  map[string]*tenantPool
+	flight syncx.SingleFlight
+}
+
+type tenantPool struct {
+	db       *gorm.DB
+	shared   bool // 共用 Default 的连接池, 回收时不关闭连接
+	lastUsed time.Time
+}
+
+// NewTenantRouter 创建租户数据库路由
+func NewTenantRouter(def *gorm.DB, resolver TenantDBResolver) *TenantRouter {
+	return &TenantRouter{Default: def, Resolver: resolver}
+}
+
+// DB 返回当前上下文租户对应的数据库
+func (r *TenantRouter) DB(ctx context.Context) (*gorm.DB, error) {
+	tenantId, ok := auth.LookupTenantId(ctx)
+	if !ok || r.Resolver == nil {
+		return r.Default.WithContext(ctx), nil
+	}
+	db, err := r.TenantDB(ctx, tenantId)
+	if err != nil {
+		return nil, err
+	}
+	return db.WithContext(ctx), nil
+}
+
+// TenantDB 返回指定租户的数据库
+func (r *TenantRouter) TenantDB(ctx context.Context, tenantId string) (*gorm.DB, error) {
+	now := time.Now()
+	r.mu.Lock()
+	if p, ok := r.pools[tenantId]; ok {
+		p.lastUsed = now
+		r.mu.Unlock()
+		return p.db, nil
+	}
+	r.mu.Unlock()
+
+	val, err := r.getFlight().Do(tenantId, func() (any, error) {
+		return r.open(ctx, tenantId)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return val.(*gorm.DB), nil
+}
+
+func (r *TenantRouter) open(ctx context.Context, tenantId string) (*gorm.DB, error) {
+	r.mu.Lock()
+	if p, ok := r.pools[tenantId]; ok {
+		r.mu.Unlock()
+		return p.db, nil
+	}
+	r.mu.Unlock()
+
+	cfg, err := r.Resolver(ctx, tenantId)
+	if err != nil {
+		return nil, err
+	}
+	p, err := r.newPool(tenantId, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	if r.pools == nil {
+		r.pools = make(map[string]*tenantPool)
+	}
+	r.pools[tenantId] = p
+	evicted := r.evictOverflow()
+	r.mu.Unlock()
+	r.closeLater(evicted)
+	return p.db, nil
+}
+
+func (r *TenantRouter) newPool(tenantId string, cfg *TenantDBConfig) (*tenantPool, error) {
+	if cfg == nil || cfg.Mode == IsolationShared {
+		return &tenantPool{db: r.Default, shared: true, lastUsed: time.Now()}, nil
+	}
+	cfg.TenantId = tenantId
+
+	opener := r.Opener
+	if opener == nil {
+		opener = MySQLOpener(r.Default)
+	}
+	db, err := opener(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("open tenant %s db failed: %v", tenantId, err)
+	}
+	for _, p := range r.Plugins {
+		if err = db.Use(p); err != nil {
+			return nil, err
+		}
+	}
+	p := &tenantPool{db: db, lastUsed: time.Now()}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	if r.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(r.MaxOpenConns)
+	}
+	if r.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(r.MaxIdleConns)
+	}
+	if r.ConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(r.ConnMaxLifetime)
+	}
+	return p, nil
+}
+
+func (r *TenantRouter) getFlight() syncx.SingleFlight {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.flight == nil {
+		r.flight = syncx.NewSingleFlight()
+	}
+	return r.flight
+}
+
+// evictOverflow 超过 MaxTenants 时移除最久未使用的租户, 需持有锁
+func (r *TenantRouter) evictOverflow() []*tenantPool {
+	var evicted []*tenantPool
+	for r.MaxTenants > 0 && len(r.pools) > r.MaxTenants {
+		var (
+			oldestId string
+			oldest   *tenantPool
+		)
+		for id, p := range r.pools {
+			if oldest == nil || p.lastUsed.Before(oldest.lastUsed) {
+				oldestId, oldest = id, p
+			}
+		}
+		delete(r.pools, oldestId)
+		evicted = append(evicted, oldest)
+	}
+	return evicted
+}
+
+// Evict 关闭并移除指定租户的连接, 租户配置变更后调用
+func (r *TenantRouter) Evict(tenantId string) {
+	r.mu.Lock()
+	p, ok := r.pools[tenantId]
+	delete(r.pools, tenantId)
+	r.mu.Unlock()
+	if ok {
+		r.closeLater([]*tenantPool{p})
+	}
+}
+
+// EvictIdle 关闭空闲超过 IdleTimeout 的租户连接, 返回回收数量
+func (r *TenantRouter) EvictIdle() int {
+	if r.IdleTimeout <= 0 {
+		return 0
+	}
+	deadline := time.Now().Add(-r.IdleTimeout)
+	var evicted []*tenantPool
+	r.mu.Lock()
+	for id, p := range r.pools {
+		if p.lastUsed.Before(deadline) {
+			delete(r.pools, id)
+			evicted = append(evicted, p)
+		}
+	}
+	r.mu.Unlock()
+	r.closeLater(evicted)
+	return len(evicted)
+}
+
+// StartEvictor 定时回收空闲租户连接, 调用返回的函数停止
+func (r *TenantRouter) StartEvictor(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				r.EvictIdle()
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// Close 立即关闭全部租户连接, 不关闭 Default, 用于服务退出
+func (r *TenantRouter) Close() {
+	r.mu.Lock()
+	evicted := make([]*tenantPool, 0, len(r.pools))
+	for _, p := range r.pools {
+		evicted = append(evicted, p)
+	}
+	r.pools = nil
+	r.mu.Unlock()
+	closePools(evicted)
+}
+
+// Len 当前保持的租户连接数量
+func (r *TenantRouter) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.pools)
+}
+
+func closePools(pools []*tenantPool) {
+	for _, p := range pools {
+		if p.shared {
+			continue
+		}
+		if sqlDB, err := p.db.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	}
+}
+
+// closeLater 延迟关闭回收的连接, 仍有使用中的连接（如未结束的事务）时继续等待
+func (r *TenantRouter) closeLater(pools []*tenantPool) {
+	delay := r.CloseDelay
+	if delay <= 0 {
+		delay = DefaultCloseDelay
+	}
+	for _, p := range pools {
+		if p.shared {
+			continue
+		}
+		sqlDB, err := p.db.DB()
+		if err != nil {
+			continue
+		}
+		var closeIdle func()
+		closeIdle = func() {
+			if sqlDB.Stats().InUse > 0 {
+				time.AfterFunc(delay, closeIdle)
+				return
+			}
+			_ = sqlDB.Close()
+		}
+		time.AfterFunc(delay, closeIdle)
+	}
+}
+
+// MySQLOpener MySQL 租户数据库打开方式
+//
+// IsolationDatabase 使用 DSN 打开新连接; IsolationSchema 以 schema 为默认库打开独立连接池,
+// Table、Raw、Exec 等不经过命名策略的语句同样在租户 schema 中执行
+func MySQLOpener(base *gorm.DB) TenantDBOpener {
+	return func(cfg *TenantDBConfig) (*gorm.DB, error) {
+		gormCfg := &gorm.Config{
+			Logger:                                   base.Logger,
+			NowFunc:                                  base.NowFunc,
+			DisableForeignKeyConstraintWhenMigrating: base.DisableForeignKeyConstraintWhenMigrating,
+		}
+		switch cfg.Mode {
+		case IsolationSchema:
+			d, ok := base.Dialector.(*mysql.Dialector)
+			if !ok {
+				return nil, fmt.Errorf("schema isolation requires a mysql default db")
+			}
+			dsn := cfg.DSN
+			if dsn == "" {
+				dsn = d.DSN
+			}
+			dsnCfg, err := mysqldriver.ParseDSN(dsn)
+			if err != nil {
+				return nil, err
+			}
+			dsnCfg.DBName = cfg.Schema
+			conf := *d.Config
+			conf.DSN, conf.DSNConfig, conf.Conn = dsnCfg.FormatDSN(), nil, nil
+			gormCfg.NamingStrategy = base.NamingStrategy
+			gormCfg.DisableAutomaticPing = base.DisableAutomaticPing
+			return gorm.Open(mysql.New(conf), gormCfg)
+		case IsolationDatabase:
+			return gorm.Open(mysql.Open(cfg.DSN), gormCfg)
+		default:
+			return nil, fmt.Errorf("unsupported isolation mode %d", cfg.Mode)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package plugin_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+	"github.com/ovra-cloud/ovra-toolkit/gorm/plugin"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+func TestTenantRouter(t *testing.T) {
+	def := newDryRunDB(t)
+	opened := 0
+	router := plugin.NewTenantRouter(def, func(_ context.Context, tenantId string) (*plugin.TenantDBConfig, error) {
+		if tenantId == "1" {
+			return nil, nil
+		}
+		return &plugin.TenantDBConfig{Mode: plugin.IsolationDatabase, DSN: "tenant_" + tenantId}, nil
+	})
+	router.MaxTenants = 1
+	router.Opener = func(cfg *plugin.TenantDBConfig) (*gorm.DB, error) {
+		opened++
+		return newDryRunDB(t), nil
+	}
+
+	t.Run("共享表租户使用默认库", func(t *testing.T) {
+		db, err := router.TenantDB(context.Background(), "1")
+		assert.NoError(t, err)
+		assert.Same(t, def, db)
+	})
+
+	t.Run("独立库租户懒加载并复用", func(t *testing.T) {
+		ctx := context.WithValue(context.Background(), auth.TenantIDKey, "2")
+		db1, err := router.DB(ctx)
+		assert.NoError(t, err)
+		db2, err := router.DB(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, db1.ConnPool, db2.ConnPool)
+		assert.Equal(t, 1, opened)
+	})
+
+	t.Run("超过上限回收最久未使用", func(t *testing.T) {
+		_, err := router.TenantDB(context.Background(), "3")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, router.Len())
+		_, err = router.TenantDB(context.Background(), "2")
+		assert.NoError(t, err)
+		assert.Equal(t, 3, opened)
+
+		router.Evict("2")
+		assert.Equal(t, 0, router.Len())
+	})
+}
+
+func TestTenantRouterCloseDelay(t *testing.T) {
+	router := plugin.NewTenantRouter(newDryRunDB(t), func(_ context.Context, tenantId string) (*plugin.TenantDBConfig, error) {
+		return &plugin.TenantDBConfig{Mode: plugin.IsolationDatabase}, nil
+	})
+	router.CloseDelay = 20 * time.Millisecond
+	router.Opener = func(cfg *plugin.TenantDBConfig) (*gorm.DB, error) {
+		return gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	}
+
+	db, err := router.TenantDB(context.Background(), "2")
+	require.NoError(t, err)
+	tx := db.Begin()
+	require.NoError(t, tx.Error)
+
+	// 回收后已取得连接的请求仍可继续使用
+	router.Evict("2")
+	assert.NoError(t, db.Exec("SELECT 1").Error)
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, tx.Exec("SELECT 1").Error)
+	require.NoError(t, tx.Commit().Error)
+
+	assert.Eventually(t, func() bool {
+		return db.Exec("SELECT 1").Error != nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+// prefixNamer 自定义命名策略
+type prefixNamer struct {
+	schema.NamingStrategy
+}
+
+func (n prefixNamer) TableName(table string) string {
+	return "biz_" + n.NamingStrategy.TableName(table)
+}
+
+type TenantOrder struct {
+	ID int64
+}
+
+func TestMySQLOpenerSchema(t *testing.T) {
+	base, err := gorm.Open(mysql.New(mysql.Config{
+		DSN:                       "root:root@tcp(127.0.0.1:3306)/dry_run",
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{
+		DisableAutomaticPing: true,
+		Logger:               logger.Discard,
+		NamingStrategy:       prefixNamer{schema.NamingStrategy{SingularTable: true}},
+	})
+	require.NoError(t, err)
+
+	db, err := plugin.MySQLOpener(base)(&plugin.TenantDBConfig{Mode: plugin.IsolationSchema, Schema: "tenant_2"})
+	require.NoError(t, err)
+	stmt := db.Session(&gorm.Session{DryRun: true}).Find(&[]TenantOrder{}).Statement
+	assert.Equal(t, "SELECT * FROM `biz_tenant_order`", stmt.SQL.String())
+
+	// 独立连接池以租户 schema 为默认库, Table、Raw、Exec 不会落到默认库
+	assert.Equal(t, "root:root@tcp(127.0.0.1:3306)/tenant_2", db.Dialector.(*mysql.Dialector).DSN)
+	assert.NotEqual(t, base.ConnPool, db.ConnPool)
+}
@@ -0,0 +1,838 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrTenantUnsafeSQL 严格模式下无法安全改写的原生 SQL
+var ErrTenantUnsafeSQL = errors.New("tenant: unable to safely rewrite raw sql")
+
+// errTenantNonDML SHOW、SET、DDL 等不读写行数据的语句, 非严格模式下直接执行
+var errTenantNonDML = errors.New("non dml statement")
+
+// 不读写行数据的语句
+var nonDMLWords = map[string]bool{
+	"SHOW": true, "SET": true, "DESC": true, "DESCRIBE": true, "EXPLAIN": true, "USE": true,
+	"CREATE": true, "ALTER": true, "DROP": true, "TRUNCATE": true, "RENAME": true, "ANALYZE": true,
+	"OPTIMIZE": true, "BEGIN": true, "START": true, "COMMIT": true, "ROLLBACK": true, "SAVEPOINT": true,
+	"RELEASE": true, "LOCK": true, "UNLOCK": true, "GRANT": true, "REVOKE": true, "FLUSH": true,
+	"KILL": true, "PRAGMA": true, "VACUUM": true,
+}
+
+type sqlTokenKind int
+
+const (
+	tkSpace sqlTokenKind = iota
+	tkComment
+	tkWord
+	tkQuoted // `ident` 或 "ident"
+	tkString
+	tkParam // ? 或 $n
+	tkPunct
+)
+
+type sqlToken struct {
+	kind sqlTokenKind
+	text string
+}
+
+// tokenizeSQL 将 SQL 拆分为 token, 拼接全部 token 即为原 SQL
+func tokenizeSQL(sql string) ([]sqlToken, error) {
+	var toks []sqlToken
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		start := i
+		kind := tkPunct
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			for i < len(sql) && strings.IndexByte(" \t\n\r", sql[i]) >= 0 {
+				i++
+			}
+			kind = tkSpace
+		case c == '#' || (c == '-' && strings.HasPrefix(sql[i:], "--")):
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+			kind = tkComment
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated comment", ErrTenantUnsafeSQL)
+			}
+			i += end + 4
+			kind = tkComment
+		case c == '\'' || c == '"' || c == '`':
+			i++
+			for i < len(sql) && sql[i] != c {
+				if sql[i] == '\\' && c != '`' {
+					i++
+				}
+				i++
+			}
+			if i >= len(sql) {
+				return nil, fmt.Errorf("%w: unterminated quote", ErrTenantUnsafeSQL)
+			}
+			i++
+			kind = tkString
+			if c != '\'' {
+				kind = tkQuoted
+			}
+		case c == '?':
+			i++
+			kind = tkParam
+		case c == '$' && i+1 < len(sql) && sql[i+1] >= '0' && sql[i+1] <= '9':
+			i++
+			for i < len(sql) && sql[i] >= '0' && sql[i] <= '9' {
+				i++
+			}
+			kind = tkParam
+		case isWordByte(c):
+			for i < len(sql) && isWordByte(sql[i]) {
+				i++
+			}
+			kind = tkWord
+		default:
+			i++
+		}
+		toks = append(toks, sqlToken{kind: kind, text: sql[start:i]})
+	}
+	return toks, nil
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// 不能作为表别名的关键字
+var sqlReserved = map[string]bool{
+	"WHERE": true, "ON": true, "USING": true, "JOIN": true, "INNER": true, "LEFT": true, "RIGHT": true,
+	"CROSS": true, "NATURAL": true, "STRAIGHT_JOIN": true, "FULL": true, "OUTER": true, "GROUP": true,
+	"ORDER": true, "HAVING": true, "LIMIT": true, "UNION": true, "EXCEPT": true, "INTERSECT": true,
+	"SET": true, "FOR": true, "LOCK": true, "WINDOW": true, "USE": true, "IGNORE": true, "FORCE": true,
+	"PARTITION": true, "INTO": true, "VALUES": true, "SELECT": true, "AS": true, "OFFSET": true,
+	"FETCH": true, "RETURNING": true, "FROM": true,
+}
+
+// FROM 子句结束关键字
+var fromEnd = map[string]bool{
+	"WHERE": true, "GROUP": true, "HAVING": true, "ORDER": true, "LIMIT": true, "WINDOW": true,
+	"FOR": true, "LOCK": true, "OFFSET": true, "FETCH": true, "RETURNING": true, "INTO": true, "SET": true,
+}
+
+// WHERE 子句结束关键字
+var whereEnd = map[string]bool{
+	"GROUP": true, "HAVING": true, "ORDER": true, "LIMIT": true, "WINDOW": true, "FOR": true,
+	"LOCK": true, "OFFSET": true, "FETCH": true, "RETURNING": true,
+}
+
+// JOIN 前缀关键字
+var joinWords = map[string]bool{
+	"JOIN": true, "INNER": true, "LEFT": true, "RIGHT": true, "CROSS": true, "NATURAL": true,
+	"STRAIGHT_JOIN": true, "FULL": true, "OUTER": true,
+}
+
+// tenantResolver 返回表的租户列（已转义）与租户值, 非租户表返回 false
+type tenantResolver func(table string) (column string, value interface{}, ok bool)
+
+// tenantQual 需要追加租户条件的表引用
+type tenantQual struct {
+	name   string // 表名或别名
+	column string
+	value  interface{}
+}
+
+// sqlInsert 改写时插入的文本, vars 依次对应文本中的 paramMark
+type sqlInsert struct {
+	text string
+	vars []interface{}
+}
+
+// tenantSQL 原生 SQL 租户条件改写
+type tenantSQL struct {
+	toks    []sqlToken
+	sig     []int       // 有效 token（非空白、注释）在 toks 中的下标
+	pair    map[int]int // 括号配对, 以 sig 下标表示
+	after   map[int][]sqlInsert
+	vars    []interface{}
+	params  map[int]int // 占位符在 vars 中的下标, 以 sig 下标表示
+	resolve tenantResolver
+	ctes    map[string]struct{} // WITH 定义的临时表
+	err     error
+}
+
+// paramMark 改写时插入的租户参数占位
+const paramMark = "\x00"
+
+// rewriteTenantSQL 为 SQL 中的每个表引用加上租户条件, 返回新 SQL 与参数
+//
+// INSERT 需写入当前租户, UPDATE 与 ON DUPLICATE KEY UPDATE 不允许修改租户列
+func rewriteTenantSQL(sql string, vars []interface{}, resolve tenantResolver) (string, []interface{}, error) {
+	toks, err := tokenizeSQL(sql)
+	if err != nil {
+		return "", nil, err
+	}
+	r := &tenantSQL{
+		toks:    toks,
+		pair:    map[int]int{},
+		after:   map[int][]sqlInsert{},
+		vars:    vars,
+		params:  map[int]int{},
+		resolve: resolve,
+		ctes:    map[string]struct{}{},
+	}
+	var stack []int
+	semicolon := -1
+	for i, t := range toks {
+		if t.kind == tkSpace || t.kind == tkComment {
+			continue
+		}
+		p := len(r.sig)
+		r.sig = append(r.sig, i)
+		if semicolon >= 0 {
+			return "", nil, fmt.Errorf("%w: multiple statements", ErrTenantUnsafeSQL)
+		}
+		if t.kind == tkParam {
+			r.params[p] = len(r.params)
+		}
+		switch t.text {
+		case "(":
+			stack = append(stack, p)
+		case ")":
+			if len(stack) == 0 {
+				return "", nil, fmt.Errorf("%w: unbalanced parentheses", ErrTenantUnsafeSQL)
+			}
+			r.pair[stack[len(stack)-1]] = p
+			stack = stack[:len(stack)-1]
+		case ";":
+			semicolon = p
+		}
+	}
+	if len(stack) > 0 {
+		return "", nil, fmt.Errorf("%w: unbalanced parentheses", ErrTenantUnsafeSQL)
+	}
+	end := len(r.sig)
+	if semicolon >= 0 {
+		end = semicolon
+	}
+	r.collectCTEs()
+	r.statement(0, end)
+	if r.err != nil {
+		return "", nil, r.err
+	}
+	return r.build()
+}
+
+func (r *tenantSQL) fail(format string, a ...interface{}) {
+	if r.err == nil {
+		r.err = fmt.Errorf("%w: %s", ErrTenantUnsafeSQL, fmt.Sprintf(format, a...))
+	}
+}
+
+func (r *tenantSQL) tok(p int) sqlToken { return r.toks[r.sig[p]] }
+func (r *tenantSQL) text(p int) string  { return r.toks[r.sig[p]].text }
+
+// word 返回大写关键字, 非单词 token 返回空
+func (r *tenantSQL) word(p int, end int) string {
+	if p >= end || r.tok(p).kind != tkWord {
+		return ""
+	}
+	return strings.ToUpper(r.text(p))
+}
+
+// skip 跳过括号, 返回下一个同层位置
+func (r *tenantSQL) skip(p int) int {
+	if r.text(p) == "(" {
+		return r.pair[p] + 1
+	}
+	return p + 1
+}
+
+// find 在同层查找首个命中关键字的位置, 未找到返回 end
+func (r *tenantSQL) find(p, end int, words map[string]bool) int {
+	for p < end {
+		if words[r.word(p, end)] {
+			return p
+		}
+		p = r.skip(p)
+	}
+	return end
+}
+
+// insertAfter 在 sig 位置 p 之后插入文本
+func (r *tenantSQL) insertAfter(p int, s string, vars ...interface{}) {
+	r.after[r.sig[p]] = append(r.after[r.sig[p]], sqlInsert{text: s, vars: vars})
+}
+
+func (r *tenantSQL) collectCTEs() {
+	for p := 0; p < len(r.sig); p++ {
+		if r.word(p, len(r.sig)) != "WITH" {
+			continue
+		}
+		q := p + 1
+		if r.word(q, len(r.sig)) == "RECURSIVE" {
+			q++
+		}
+		for q < len(r.sig) {
+			r.ctes[unquoteIdent(r.text(q))] = struct{}{}
+			q++
+			if q < len(r.sig) && r.text(q) == "(" {
+				q = r.pair[q] + 1
+			}
+			if r.word(q, len(r.sig)) != "AS" || q+1 >= len(r.sig) || r.text(q+1) != "(" {
+				break
+			}
+			q = r.pair[q+1] + 1
+			if q >= len(r.sig) || r.text(q) != "," {
+				break
+			}
+			q++
+		}
+	}
+}
+
+// statement 处理 [p,end) 范围内的语句, 包含 UNION 拆分与子查询
+func (r *tenantSQL) statement(p, end int) {
+	// 子查询
+	for q := p; q < end; q = r.skip(q) {
+		if r.text(q) == "(" {
+			r.nested(q+1, r.pair[q])
+		}
+	}
+	start := p
+	for q := p; q <= end; {
+		w := r.word(q, end)
+		if q == end || w == "UNION" || w == "EXCEPT" || w == "INTERSECT" {
+			r.block(start, q)
+			if q == end {
+				break
+			}
+			q++
+			if w := r.word(q, end); w == "ALL" || w == "DISTINCT" {
+				q++
+			}
+			start = q
+			continue
+		}
+		q = r.skip(q)
+	}
+}
+
+// nested 处理括号内容, 仅查询语句按语句处理, 其余（函数、IN 列表）继续查找子查询
+func (r *tenantSQL) nested(p, end int) {
+	if p >= end {
+		return
+	}
+	switch r.word(p, end) {
+	case "SELECT", "WITH":
+		r.statement(p, end)
+	default:
+		if r.text(p) == "(" && r.pair[p] == end-1 {
+			r.nested(p+1, end-1)
+			return
+		}
+		for q := p; q < end; q = r.skip(q) {
+			if r.text(q) == "(" {
+				r.nested(q+1, r.pair[q])
+			}
+		}
+	}
+}
+
+func (r *tenantSQL) block(p, end int) {
+	if p >= end {
+		return
+	}
+	if r.text(p) == "(" {
+		// (SELECT ...) UNION (SELECT ...) 已作为子查询处理
+		return
+	}
+	switch w := r.word(p, end); w {
+	case "WITH":
+		q := p + 1
+		if r.word(q, end) == "RECURSIVE" {
+			q++
+		}
+		for q < end {
+			q++
+			if q < end && r.text(q) == "(" {
+				q = r.pair[q] + 1
+			}
+			if r.word(q, end) != "AS" || q+1 >= end || r.text(q+1) != "(" {
+				r.fail("malformed WITH clause")
+				return
+			}
+			q = r.pair[q+1] + 1
+			if q >= end || r.text(q) != "," {
+				break
+			}
+			q++
+		}
+		r.block(q, end)
+	case "SELECT":
+		from := r.find(p, end, map[string]bool{"FROM": true})
+		if from == end {
+			return
+		}
+		tail := r.find(from+1, end, fromEnd)
+		quals := r.tableRefs(from+1, tail)
+		r.where(from+1, tail, end, quals)
+	case "UPDATE":
+		q := p + 1
+		for w := r.word(q, end); w == "LOW_PRIORITY" || w == "IGNORE" || w == "ONLY"; w = r.word(q, end) {
+			q++
+		}
+		set := r.find(q, end, map[string]bool{"SET": true})
+		if set == end {
+			r.fail("UPDATE without SET")
+			return
+		}
+		quals := r.tableRefs(q, set)
+		tail := r.find(set+1, end, map[string]bool{"WHERE": true, "ORDER": true, "LIMIT": true, "RETURNING": true, "FROM": true})
+		if r.word(tail, end) == "FROM" {
+			r.fail("UPDATE ... FROM is not supported")
+			return
+		}
+		for _, q := range quals {
+			if r.assigns(set+1, tail, q.column) {
+				r.fail("UPDATE sets tenant column %s", q.column)
+				return
+			}
+		}
+		r.where(set+1, tail, end, quals)
+	case "DELETE":
+		from := r.find(p, end, map[string]bool{"FROM": true})
+		if from == end {
+			r.fail("DELETE without FROM")
+			return
+		}
+		tail := r.find(from+1, end, map[string]bool{"WHERE": true, "ORDER": true, "LIMIT": true, "RETURNING": true, "USING": true})
+		if r.word(tail, end) == "USING" {
+			r.fail("DELETE ... USING is not supported")
+			return
+		}
+		quals := r.tableRefs(from+1, tail)
+		r.where(from+1, tail, end, quals)
+	case "INSERT", "REPLACE":
+		r.insert(p, end)
+	default:
+		if nonDMLWords[w] {
+			r.err = fmt.Errorf("%w: %w %q", ErrTenantUnsafeSQL, errTenantNonDML, w)
+			return
+		}
+		r.fail("unsupported statement %q", w)
+	}
+}
+
+// insert 校验 INSERT / REPLACE 写入的租户值为当前租户, 且冲突更新时不修改租户列
+func (r *tenantSQL) insert(p, end int) {
+	q := p + 1
+	for w := r.word(q, end); w == "LOW_PRIORITY" || w == "DELAYED" || w == "HIGH_PRIORITY" || w == "IGNORE" || w == "INTO"; w = r.word(q, end) {
+		q++
+	}
+	if q >= end || (r.tok(q).kind != tkWord && r.tok(q).kind != tkQuoted) {
+		r.fail("INSERT without table")
+		return
+	}
+	table := unquoteIdent(r.text(q))
+	q++
+	if q+1 < end && r.text(q) == "." {
+		table = unquoteIdent(r.text(q + 1))
+		q += 2
+	}
+	body := r.find(q, end, map[string]bool{"SELECT": true, "VALUES": true, "VALUE": true, "SET": true, "WITH": true})
+	// ON DUPLICATE KEY UPDATE / ON CONFLICT
+	upsert := body
+	for upsert < end {
+		if r.word(upsert, end) == "ON" {
+			if w := r.word(upsert+1, end); w == "DUPLICATE" || w == "CONFLICT" {
+				break
+			}
+		}
+		upsert = r.skip(upsert)
+	}
+	kind := r.word(body, end)
+	if kind == "SELECT" || kind == "WITH" {
+		r.block(body, upsert)
+	}
+	column, value, ok := r.resolve(table)
+	if !ok || r.err != nil {
+		return
+	}
+	if r.word(upsert+1, end) == "DUPLICATE" {
+		set := r.find(upsert+1, end, map[string]bool{"UPDATE": true})
+		if r.assigns(set+1, end, column) {
+			r.fail("ON DUPLICATE KEY UPDATE sets tenant column %s", column)
+			return
+		}
+	} else if upsert < end {
+		set := r.find(upsert+1, end, map[string]bool{"SET": true})
+		if r.assigns(set+1, r.find(set+1, end, map[string]bool{"WHERE": true, "RETURNING": true}), column) {
+			r.fail("ON CONFLICT DO UPDATE sets tenant column %s", column)
+			return
+		}
+	}
+
+	if kind == "SET" {
+		for _, item := range r.items(body+1, upsert) {
+			if eq := r.assignment(item[0], item[1]); eq > 0 && r.isColumn(item[0], eq, column) {
+				if !r.isValue(eq+1, item[1], value) {
+					r.fail("INSERT writes %s of another tenant", column)
+				}
+				return
+			}
+		}
+		r.fail("INSERT without %s column", column)
+		return
+	}
+	idx := -1
+	if q < body && r.text(q) == "(" {
+		for i, item := range r.items(q+1, r.pair[q]) {
+			if r.isColumn(item[0], item[1], column) {
+				idx = i
+			}
+		}
+	}
+	if idx < 0 {
+		r.fail("INSERT without %s column", column)
+		return
+	}
+	switch kind {
+	case "VALUES", "VALUE":
+		for row := body + 1; ; row++ {
+			if row >= upsert || r.text(row) != "(" {
+				r.fail("malformed VALUES")
+				return
+			}
+			items := r.items(row+1, r.pair[row])
+			if idx >= len(items) || !r.isValue(items[idx][0], items[idx][1], value) {
+				r.fail("INSERT writes %s of another tenant", column)
+				return
+			}
+			row = r.pair[row] + 1
+			if row >= upsert || r.text(row) != "," {
+				return
+			}
+		}
+	case "SELECT":
+		// 来源表已追加租户条件, 允许直接选择租户列
+		from := r.find(body+1, upsert, map[string]bool{"FROM": true})
+		items := r.items(body+1, from)
+		if len(items) > 0 {
+			if w := r.word(items[0][0], from); w == "DISTINCT" || w == "ALL" {
+				items[0][0]++
+			}
+		}
+		if idx >= len(items) {
+			r.fail("INSERT ... SELECT columns mismatch")
+			return
+		}
+		item := items[idx]
+		if item[1]-item[0] > 2 && r.word(item[1]-2, item[1]) == "AS" {
+			item[1] -= 2
+		}
+		if !r.isValue(item[0], item[1], value) && !r.isColumn(item[0], item[1], column) {
+			r.fail("INSERT ... SELECT writes %s of another tenant", column)
+		}
+	default:
+		r.fail("INSERT ... %s is not supported", kind)
+	}
+}
+
+// items 按同层逗号拆分 [p,end), 返回每一项的范围
+func (r *tenantSQL) items(p, end int) [][2]int {
+	var res [][2]int
+	start := p
+	for q := p; q <= end; {
+		if q == end || r.text(q) == "," {
+			if q > start {
+				res = append(res, [2]int{start, q})
+			}
+			if q == end {
+				break
+			}
+			q++
+			start = q
+			continue
+		}
+		q = r.skip(q)
+	}
+	return res
+}
+
+// assignment 返回赋值项中同层 = 的位置, 不是赋值时返回 -1
+func (r *tenantSQL) assignment(p, end int) int {
+	for q := p; q < end; q = r.skip(q) {
+		if r.text(q) == "=" {
+			return q
+		}
+	}
+	return -1
+}
+
+// assigns 判断 [p,end) 的赋值列表中是否修改了租户列
+func (r *tenantSQL) assigns(p, end int, column string) bool {
+	for _, item := range r.items(p, end) {
+		if eq := r.assignment(item[0], item[1]); eq > 0 && r.isColumn(item[0], eq, column) {
+			return true
+		}
+	}
+	return false
+}
+
+// isColumn 判断 [p,end) 是否为租户列, 允许表名限定
+func (r *tenantSQL) isColumn(p, end int, column string) bool {
+	if end-p == 3 && r.text(p+1) == "." {
+		p += 2
+	}
+	if end-p != 1 {
+		return false
+	}
+	t := r.tok(p)
+	return (t.kind == tkWord || t.kind == tkQuoted) && strings.EqualFold(unquoteIdent(t.text), unquoteIdent(column))
+}
+
+// isValue 判断 [p,end) 是否为等于 value 的占位符或字面量
+func (r *tenantSQL) isValue(p, end int, value interface{}) bool {
+	if end-p != 1 {
+		return false
+	}
+	want := fmt.Sprint(value)
+	t := r.tok(p)
+	switch t.kind {
+	case tkParam:
+		v := r.vars[r.params[p]]
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		return fmt.Sprint(v) == want
+	case tkString:
+		s := t.text[1 : len(t.text)-1]
+		return !strings.ContainsAny(s, `'\`) && s == want
+	case tkWord:
+		return t.text == want
+	}
+	return false
+}
+
+// tableRefs 解析 [p,end) 内的表引用, JOIN ... ON 直接追加租户条件, 返回需要追加到 WHERE 的表
+func (r *tenantSQL) tableRefs(p, end int) []tenantQual {
+	var quals []tenantQual
+	join := ""
+	for p < end {
+		var qual *tenantQual
+		if r.text(p) == "(" {
+			inner := r.word(p+1, r.pair[p])
+			if inner != "SELECT" && inner != "WITH" {
+				r.fail("nested table references are not supported")
+				return nil
+			}
+			p, _ = r.alias(r.pair[p]+1, end)
+		} else {
+			t := r.tok(p)
+			if t.kind != tkWord && t.kind != tkQuoted {
+				r.fail("unexpected token %q in table references", t.text)
+				return nil
+			}
+			name, table := t.text, unquoteIdent(t.text)
+			p++
+			if p+1 < end && r.text(p) == "." {
+				name += "." + r.text(p+1)
+				table = unquoteIdent(r.text(p + 1))
+				p += 2
+			}
+			var alias string
+			p, alias = r.alias(p, end)
+			if _, isCTE := r.ctes[table]; !isCTE {
+				if column, value, ok := r.resolve(table); ok {
+					qual = &tenantQual{name: name, column: column, value: value}
+					if alias != "" {
+						qual.name = alias
+					}
+				}
+			}
+			// 索引提示
+			for w := r.word(p, end); w == "USE" || w == "IGNORE" || w == "FORCE"; w = r.word(p, end) {
+				for p < end && r.text(p) != "(" {
+					p++
+				}
+				if p < end {
+					p = r.pair[p] + 1
+				}
+			}
+		}
+
+		// 连接条件
+		switch r.word(p, end) {
+		case "ON":
+			on := p
+			p = r.find(p+1, end, joinWords)
+			for q := on + 1; q < p; q = r.skip(q) {
+				if r.text(q) == "," {
+					p = q
+					break
+				}
+			}
+			if qual != nil {
+				r.insertAfter(on, r.pred(*qual)+" AND (", qual.value)
+				r.insertAfter(r.last(on+1, p), ")")
+			}
+		case "USING":
+			p++
+			if p < end && r.text(p) == "(" {
+				p = r.pair[p] + 1
+			}
+			fallthrough
+		default:
+			if qual != nil {
+				if join == "LEFT" || join == "RIGHT" || join == "FULL" {
+					r.fail("outer join without ON condition")
+					return nil
+				}
+				quals = append(quals, *qual)
+			}
+		}
+
+		// 下一个表引用
+		if p >= end {
+			break
+		}
+		join = ""
+		if r.text(p) == "," {
+			p++
+			continue
+		}
+		for joinWords[r.word(p, end)] {
+			w := r.word(p, end)
+			if join == "" && w != "OUTER" && w != "NATURAL" {
+				join = w
+			}
+			p++
+			if w == "JOIN" || w == "STRAIGHT_JOIN" {
+				break
+			}
+		}
+		if join == "" {
+			r.fail("unexpected token %q in table references", r.text(p))
+			return nil
+		}
+	}
+	return quals
+}
+
+// alias 跳过表别名, 返回别名之后的位置与别名
+func (r *tenantSQL) alias(p, end int) (int, string) {
+	if r.word(p, end) == "AS" && p+1 < end {
+		return p + 2, r.text(p + 1)
+	}
+	if p < end {
+		t := r.tok(p)
+		if (t.kind == tkWord && !sqlReserved[strings.ToUpper(t.text)]) || t.kind == tkQuoted {
+			return p + 1, t.text
+		}
+	}
+	return p, ""
+}
+
+// last 返回 [p,end) 内最后一个有效 token
+func (r *tenantSQL) last(p, end int) int {
+	if end <= p {
+		return p - 1
+	}
+	return end - 1
+}
+
+// where 将租户条件追加到 WHERE, 不存在 WHERE 时新建
+func (r *tenantSQL) where(p, tail, end int, quals []tenantQual) {
+	if len(quals) == 0 {
+		return
+	}
+	preds := make([]string, len(quals))
+	vars := make([]interface{}, len(quals))
+	for i, q := range quals {
+		preds[i], vars[i] = r.pred(q), q.value
+	}
+	cond := strings.Join(preds, " AND ")
+	if r.word(tail, end) == "WHERE" {
+		condEnd := r.find(tail+1, end, whereEnd)
+		r.insertAfter(tail, cond+" AND (", vars...)
+		r.insertAfter(r.last(tail+1, condEnd), ")")
+		return
+	}
+	r.insertAfter(r.last(p, tail), "WHERE "+cond, vars...)
+}
+
+func (r *tenantSQL) pred(q tenantQual) string {
+	return q.name + "." + q.column + " = " + paramMark
+}
+
+// build 拼接改写后的 SQL 并按顺序重建参数
+func (r *tenantSQL) build() (string, []interface{}, error) {
+	vars := r.vars
+	var (
+		sb       strings.Builder
+		out      = make([]interface{}, 0, len(vars)+1)
+		dollar   bool
+		params   int
+		trimNext bool
+	)
+	for _, t := range r.toks {
+		if t.kind == tkParam {
+			if strings.HasPrefix(t.text, "$") {
+				dollar = true
+				if t.text != "$"+strconv.Itoa(params+1) {
+					return "", nil, fmt.Errorf("%w: non sequential bind vars", ErrTenantUnsafeSQL)
+				}
+			}
+			params++
+		}
+	}
+	if params != len(vars) {
+		return "", nil, fmt.Errorf("%w: bind vars mismatch", ErrTenantUnsafeSQL)
+	}
+
+	bind := func(v interface{}) {
+		out = append(out, v)
+		if dollar {
+			sb.WriteString("$" + strconv.Itoa(len(out)))
+		} else {
+			sb.WriteByte('?')
+		}
+	}
+	params = 0
+	for i, t := range r.toks {
+		switch {
+		case t.kind == tkSpace && trimNext:
+		case t.kind == tkParam:
+			bind(vars[params])
+			params++
+		default:
+			sb.WriteString(t.text)
+		}
+		trimNext = false
+		for _, ins := range r.after[i] {
+			if ins.text != ")" {
+				sb.WriteByte(' ')
+			}
+			parts := strings.Split(ins.text, paramMark)
+			for j, part := range parts {
+				if j > 0 {
+					bind(ins.vars[j-1])
+				}
+				sb.WriteString(part)
+			}
+			trimNext = strings.HasSuffix(ins.text, "(")
+		}
+	}
+	return sb.String(), out, nil
+}
+
+// unquoteIdent 去除标识符引号
+func unquoteIdent(s string) string {
+	if len(s) >= 2 && (s[0] == '`' || s[0] == '"') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,178 @@
+package plugin
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRewriteTenantSQL(t *testing.T) {
+	resolve := func(table string) (string, interface{}, bool) { return "tenant_id", "1", table != "sys_dict" }
+	cases := []struct {
+		name string
+		sql  string
+		vars []interface{}
+		want string
+		args []interface{}
+	}{
+		{
+			name: "无条件查询",
+			sql:  "SELECT * FROM sys_user",
+			want: "SELECT * FROM sys_user WHERE sys_user.tenant_id = ?",
+			args: []interface{}{"1"},
+		},
+		{
+			name: "已有条件与排序",
+			sql:  "SELECT * FROM sys_user u WHERE u.id = ? OR u.name = ? ORDER BY u.id LIMIT 10",
+			vars: []interface{}{1, "a"},
+			want: "SELECT * FROM sys_user u WHERE u.tenant_id = ? AND (u.id = ? OR u.name = ?) ORDER BY u.id LIMIT 10",
+			args: []interface{}{"1", 1, "a"},
+		},
+		{
+			name: "JOIN 条件",
+			sql:  "SELECT * FROM sys_user AS u LEFT JOIN sys_dept d ON d.dept_id = u.dept_id WHERE u.status = ?",
+			vars: []interface{}{"0"},
+			want: "SELECT * FROM sys_user AS u LEFT JOIN sys_dept d ON d.tenant_id = ? AND (d.dept_id = u.dept_id) WHERE u.tenant_id = ? AND (u.status = ?)",
+			args: []interface{}{"1", "1", "0"},
+		},
+		{
+			name: "子查询与忽略表",
+			sql:  "SELECT * FROM sys_user WHERE dept_id IN (SELECT dept_id FROM sys_dept WHERE status = ?) AND type IN (SELECT v FROM sys_dict)",
+			vars: []interface{}{"0"},
+			want: "SELECT * FROM sys_user WHERE sys_user.tenant_id = ? AND (dept_id IN (SELECT dept_id FROM sys_dept WHERE sys_dept.tenant_id = ? AND (status = ?)) AND type IN (SELECT v FROM sys_dict))",
+			args: []interface{}{"1", "1", "0"},
+		},
+		{
+			name: "派生表与 UNION",
+			sql:  "SELECT * FROM (SELECT id FROM a) t UNION ALL SELECT id FROM b",
+			want: "SELECT * FROM (SELECT id FROM a WHERE a.tenant_id = ?) t UNION ALL SELECT id FROM b WHERE b.tenant_id = ?",
+			args: []interface{}{"1", "1"},
+		},
+		{
+			name: "UPDATE",
+			sql:  "UPDATE sys_user SET name = ? WHERE id = ?",
+			vars: []interface{}{"a", 1},
+			want: "UPDATE sys_user SET name = ? WHERE sys_user.tenant_id = ? AND (id = ?)",
+			args: []interface{}{"a", "1", 1},
+		},
+		{
+			name: "DELETE 无条件",
+			sql:  "DELETE FROM sys_user;",
+			want: "DELETE FROM sys_user WHERE sys_user.tenant_id = ?;",
+			args: []interface{}{"1"},
+		},
+		{
+			name: "CTE",
+			sql:  "WITH x AS (SELECT id FROM a) SELECT * FROM x JOIN b ON b.id = x.id",
+			want: "WITH x AS (SELECT id FROM a WHERE a.tenant_id = ?) SELECT * FROM x JOIN b ON b.tenant_id = ? AND (b.id = x.id)",
+			args: []interface{}{"1", "1"},
+		},
+		{
+			name: "PostgreSQL 占位符",
+			sql:  "SELECT * FROM a WHERE id = $1",
+			vars: []interface{}{1},
+			want: "SELECT * FROM a WHERE a.tenant_id = $1 AND (id = $2)",
+			args: []interface{}{"1", 1},
+		},
+		{
+			name: "INSERT 包含租户列",
+			sql:  "INSERT INTO a (id, tenant_id) VALUES (?, ?)",
+			vars: []interface{}{1, "1"},
+			want: "INSERT INTO a (id, tenant_id) VALUES (?, ?)",
+			args: []interface{}{1, "1"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sql, args, err := rewriteTenantSQL(c.sql, c.vars, resolve)
+			assert.NoError(t, err)
+			assert.Equal(t, c.want, sql)
+			assert.Equal(t, c.args, args)
+		})
+	}
+}
+
+func allTenantTables(string) (string, interface{}, bool) {
+	return "tenant_id", "1", true
+}
+
+func TestRewriteTenantSQLInsert(t *testing.T) {
+	// orgs 使用整型租户列 org_no, sys_dict 不是租户表
+	resolve := func(table string) (string, interface{}, bool) {
+		switch table {
+		case "sys_dict":
+			return "", nil, false
+		case "orgs":
+			return "org_no", int64(1), true
+		}
+		return "tenant_id", "1", true
+	}
+	for _, sql := range []string{
+		"INSERT INTO a (id, tenant_id) VALUES (?, ?), (?, ?)",
+		"INSERT INTO a (id, `tenant_id`) VALUES (2, '1')",
+		"INSERT INTO a SET id = ?, tenant_id = ?",
+		"INSERT INTO a (id, tenant_id) VALUES (?, ?) ON DUPLICATE KEY UPDATE name = VALUES(name)",
+		"INSERT INTO orgs (id, org_no) VALUES (?, ?)",
+		"INSERT INTO a (id, tenant_id) SELECT id, b.tenant_id FROM b",
+		"INSERT INTO sys_dict (id) VALUES (?)",
+	} {
+		vars := []interface{}{1, "1", 2, int64(1)}[:countParams(sql)]
+		_, _, err := rewriteTenantSQL(sql, vars, resolve)
+		assert.NoError(t, err, sql)
+	}
+
+	for _, c := range []struct {
+		sql  string
+		vars []interface{}
+	}{
+		{"INSERT INTO a (id, tenant_id) VALUES (?, ?), (?, ?)", []interface{}{1, "1", 2, "2"}},
+		{"INSERT INTO a (id, tenant_id) VALUES (2, '2')", nil},
+		{"INSERT INTO a SET id = ?, tenant_id = ?", []interface{}{1, "2"}},
+		{"INSERT INTO a (id, tenant_id) VALUES (?, ?) ON DUPLICATE KEY UPDATE tenant_id = ?", []interface{}{1, "1", "2"}},
+		{"INSERT INTO a (id, tenant_id) VALUES (?, ?) ON CONFLICT (id) DO UPDATE SET tenant_id = excluded.tenant_id", []interface{}{1, "1"}},
+		{"INSERT INTO orgs (id, org_no) VALUES (?, ?)", []interface{}{1, int64(2)}},
+		{"INSERT INTO a (id, tenant_id) SELECT id, ? FROM b", []interface{}{"2"}},
+		{"INSERT INTO a VALUES (?, ?)", []interface{}{1, "1"}},
+		{"UPDATE a SET name = ?, tenant_id = ? WHERE id = ?", []interface{}{"n", "2", 1}},
+		{"UPDATE a x SET x.tenant_id = ?", []interface{}{"1"}},
+	} {
+		_, _, err := rewriteTenantSQL(c.sql, c.vars, resolve)
+		assert.True(t, errors.Is(err, ErrTenantUnsafeSQL), c.sql)
+	}
+
+	sql, args, err := rewriteTenantSQL("UPDATE orgs SET name = ? WHERE id = ?", []interface{}{"n", 1}, resolve)
+	assert.NoError(t, err)
+	assert.Equal(t, "UPDATE orgs SET name = ? WHERE orgs.org_no = ? AND (id = ?)", sql)
+	assert.Equal(t, []interface{}{"n", int64(1), 1}, args)
+}
+
+func TestRewriteTenantSQLUnsafe(t *testing.T) {
+	for _, sql := range []string{
+		"TRUNCATE sys_user",
+		"SELECT 1; DELETE FROM sys_user",
+		"INSERT INTO a (id) VALUES (?)",
+		"SELECT * FROM a LEFT JOIN b USING (id)",
+		"SELECT * FROM (a JOIN b ON a.id = b.id)",
+	} {
+		_, _, err := rewriteTenantSQL(sql, []interface{}{1}[:countParams(sql)], allTenantTables)
+		assert.True(t, errors.Is(err, ErrTenantUnsafeSQL), sql)
+	}
+
+	for _, sql := range []string{"SHOW TABLES", "SET NAMES utf8mb4", "ALTER TABLE a ADD c INT", "TRUNCATE a"} {
+		_, _, err := rewriteTenantSQL(sql, nil, allTenantTables)
+		assert.True(t, errors.Is(err, errTenantNonDML), sql)
+	}
+	_, _, err := rewriteTenantSQL("MERGE INTO a", nil, allTenantTables)
+	assert.False(t, errors.Is(err, errTenantNonDML))
+}
+
+func countParams(sql string) int {
+	n := 0
+	for _, c := range sql {
+		if c == '?' {
+			n++
+		}
+	}
+	return n
+}
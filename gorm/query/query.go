@@ -0,0 +1,380 @@
+// Package query
+// @Description: 按请求结构体的 query 标签生成查询条件
+//
+//	type UserListReq struct {
+//		page.Request
+//		UserName  string          `form:"userName,optional" query:"like"`
+//		Status    string          `form:"status,optional" query:"in"`
+//		DeptIds   []int64         `form:"deptIds,optional" query:"in;column=dept_id"`
+//		Keyword   string          `form:"keyword,optional" query:"like;column=user_name|nick_name"`
+//		BeginTime string          `form:"params[beginTime],optional" query:"gte;column=create_time"`
+//		Created   query.TimeRange `json:"params,optional" query:"between;column=create_time"`
+//	}
+//
+//	db.Scopes(query.Where(&req)).Find(&users)
+//
+// 操作符: eq（默认）、ne、like、in、between、gte、lte; column 默认为字段名, 多列用 | 分隔并以 OR 连接.
+// 列名只来自标签且必须存在于模型中, 空值（utils.IsEmptyValue）不生成条件, 布尔条件需使用指针.
+// like 的值转义 %、_ 与 \ 后按包含匹配; TimeRange 仅用于时间、整数时间戳或字符串列
+package query
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ovra-cloud/ovra-toolkit/errx"
+	"github.com/ovra-cloud/ovra-toolkit/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	Tag = "query" // 查询条件标签
+
+	Eq      = "eq"
+	Ne      = "ne"
+	Like    = "like"
+	In      = "in"
+	Between = "between"
+	Gte     = "gte"
+	Lte     = "lte"
+)
+
+// ErrInvalidTime 时间范围格式不正确
+var ErrInvalidTime = errx.New(errx.CodeInvalid, "时间格式不正确")
+
+// TimeRange 时间范围, 对应前端 params[beginTime] / params[endTime]
+//
+// 仅有日期时 EndTime 包含当天. 时间列按 time.Time 比较, 整数列按 Unix 时间戳比较
+// （精度取 autoCreateTime / autoUpdateTime 标签, 默认秒）, 字符串列按原文比较
+type TimeRange struct {
+	BeginTime string `json:"beginTime,optional" form:"params[beginTime],optional"`
+	EndTime   string `json:"endTime,optional" form:"params[endTime],optional"`
+}
+
+// fieldRule 字段的查询规则
+type fieldRule struct {
+	index   []int
+	name    string
+	op      string
+	columns []string
+}
+
+var rules sync.Map // reflect.Type => []fieldRule
+
+// parseRules 解析请求结构体的查询规则, 匿名嵌入的结构体一并解析
+func parseRules(t reflect.Type) []fieldRule {
+	if v, ok := rules.Load(t); ok {
+		return v.([]fieldRule)
+	}
+	var result []fieldRule
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag, ok := f.Tag.Lookup(Tag)
+		if !ok {
+			if ft := f.Type; f.Anonymous && ft.Kind() == reflect.Struct {
+				for _, r := range parseRules(ft) {
+					r.index = append([]int{i}, r.index...)
+					result = append(result, r)
+				}
+			}
+			continue
+		}
+		if tag == "-" {
+			continue
+		}
+		rule := fieldRule{index: f.Index, name: f.Name, op: Eq}
+		for _, opt := range strings.Split(tag, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(opt), "=")
+			switch key {
+			case "":
+			case "column":
+				rule.columns = strings.Split(value, "|")
+			default:
+				rule.op = key
+			}
+		}
+		if len(rule.columns) == 0 {
+			rule.columns = []string{f.Name}
+		}
+		result = append(result, rule)
+	}
+	rules.Store(t, result)
+	return result
+}
+
+// Where 返回按 req 追加条件的 Scope, req 为请求结构体或其指针, 模型取自 Model 或查询目标
+func Where(req interface{}) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		rv := reflect.Indirect(reflect.ValueOf(req))
+		if rv.Kind() != reflect.Struct {
+			return db
+		}
+		stmt := db.Statement
+		model := stmt.Model
+		if model == nil {
+			model = stmt.Dest
+		}
+		if model == nil {
+			_ = db.AddError(fmt.Errorf("query: model not set, use db.Model(...) before Where"))
+			return db
+		}
+		if err := stmt.Parse(model); err != nil {
+			_ = db.AddError(err)
+			return db
+		}
+		var exprs []clause.Expression
+		for _, rule := range parseRules(rv.Type()) {
+			expr, err := rule.build(stmt, rv.FieldByIndex(rule.index))
+			if err != nil {
+				_ = db.AddError(err)
+				return db
+			}
+			if expr != nil {
+				exprs = append(exprs, expr)
+			}
+		}
+		if len(exprs) > 0 {
+			stmt.AddClause(clause.Where{Exprs: exprs})
+		}
+		return db
+	}
+}
+
+// build 生成字段的查询条件, 空值返回 nil
+func (r fieldRule) build(stmt *gorm.Statement, v reflect.Value) (clause.Expression, error) {
+	if utils.IsEmptyValue(v) {
+		return nil, nil
+	}
+	v = reflect.Indirect(v)
+	columns := make([]clause.Column, 0, len(r.columns))
+	fields := make([]*schema.Field, 0, len(r.columns))
+	for _, name := range r.columns {
+		f := LookUpField(stmt.Schema, stmt.DB.NamingStrategy, strings.TrimSpace(name))
+		if f == nil {
+			return nil, fmt.Errorf("query: column %s of field %s not found in %s", name, r.name, stmt.Schema.Name)
+		}
+		columns = append(columns, clause.Column{Table: clause.CurrentTable, Name: f.DBName})
+		fields = append(fields, f)
+	}
+
+	var build func(column clause.Column, field *schema.Field) clause.Expression
+	switch r.op {
+	case Eq:
+		build = func(c clause.Column, _ *schema.Field) clause.Expression {
+			return clause.Eq{Column: c, Value: v.Interface()}
+		}
+	case Ne:
+		build = func(c clause.Column, _ *schema.Field) clause.Expression {
+			return clause.Neq{Column: c, Value: v.Interface()}
+		}
+	case Like:
+		value := "%" + likeEscaper.Replace(fmt.Sprint(v.Interface())) + "%"
+		sql := "? LIKE ? ESCAPE '\\'"
+		if stmt.Dialector.Name() == "mysql" {
+			// MySQL 字符串字面量中的反斜杠需要转义
+			sql = "? LIKE ? ESCAPE '\\\\'"
+		}
+		build = func(c clause.Column, _ *schema.Field) clause.Expression {
+			return clause.Expr{SQL: sql, Vars: []interface{}{c, value}}
+		}
+	case Gte:
+		build = func(c clause.Column, _ *schema.Field) clause.Expression {
+			return clause.Gte{Column: c, Value: v.Interface()}
+		}
+	case Lte:
+		build = func(c clause.Column, _ *schema.Field) clause.Expression {
+			return clause.Lte{Column: c, Value: v.Interface()}
+		}
+	case In:
+		values := inValues(v)
+		if len(values) == 0 {
+			return nil, nil
+		}
+		build = func(c clause.Column, _ *schema.Field) clause.Expression { return clause.IN{Column: c, Values: values} }
+	case Between:
+		begin, end, err := rangeValues(v)
+		if err != nil || (begin == nil && end == nil) {
+			return nil, err
+		}
+		if _, ok := v.Interface().(TimeRange); ok {
+			for _, f := range fields {
+				if !timeColumn(f) {
+					return nil, fmt.Errorf("query: column %s of field %s is not a time column", f.DBName, r.name)
+				}
+			}
+		}
+		build = func(c clause.Column, f *schema.Field) clause.Expression { return betweenExpr(c, f, begin, end) }
+	default:
+		return nil, fmt.Errorf("query: unknown operator %s of field %s", r.op, r.name)
+	}
+
+	exprs := make([]clause.Expression, 0, len(columns))
+	for i, c := range columns {
+		exprs = append(exprs, build(c, fields[i]))
+	}
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return clause.Or(exprs...), nil
+}
+
+// inValues 切片取元素, 字符串按逗号拆分
+func inValues(v reflect.Value) []interface{} {
+	var values []interface{}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			values = append(values, v.Index(i).Interface())
+		}
+	case reflect.String:
+		for _, s := range strings.Split(v.String(), ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				values = append(values, s)
+			}
+		}
+	default:
+		values = append(values, v.Interface())
+	}
+	return values
+}
+
+// rangeValues 读取 TimeRange 或两个元素的切片, 未设置的一端为 nil
+func rangeValues(v reflect.Value) (begin, end interface{}, err error) {
+	if tr, ok := v.Interface().(TimeRange); ok {
+		return tr.bounds()
+	}
+	if (v.Kind() != reflect.Slice && v.Kind() != reflect.Array) || v.Len() != 2 {
+		return nil, nil, nil
+	}
+	if first := v.Index(0); !utils.IsEmptyValue(first) {
+		begin = first.Interface()
+	}
+	if second := v.Index(1); !utils.IsEmptyValue(second) {
+		end = second.Interface()
+	}
+	return begin, end, nil
+}
+
+// bounds 校验时间格式; 结束时间仅有日期时返回次日, 由 betweenExpr 生成小于条件
+func (tr TimeRange) bounds() (begin, end interface{}, err error) {
+	if tr.BeginTime != "" {
+		t, _, err := parseTime(tr.BeginTime)
+		if err != nil {
+			return nil, nil, err
+		}
+		begin = timeBound{text: tr.BeginTime, time: t}
+	}
+	if tr.EndTime != "" {
+		t, dateOnly, err := parseTime(tr.EndTime)
+		if err != nil {
+			return nil, nil, err
+		}
+		end = timeBound{text: tr.EndTime, time: t}
+		if dateOnly {
+			next := t.AddDate(0, 0, 1)
+			end = timeBound{text: next.Format(time.DateOnly), time: next, exclusive: true}
+		}
+	}
+	return begin, end, nil
+}
+
+// timeBound TimeRange 的一端, 按列类型转换后比较
+type timeBound struct {
+	text      string
+	time      time.Time
+	exclusive bool // 仅有日期的结束时间取次日, 使用小于比较以包含结束当天
+}
+
+// timeColumn 判断列能否按 TimeRange 比较
+func timeColumn(f *schema.Field) bool {
+	switch f.DataType {
+	case schema.Time, schema.Int, schema.Uint, schema.String:
+		return true
+	}
+	return false
+}
+
+// value 按列类型转换: 时间列为 time.Time, 整数列为 Unix 时间戳, 字符串列为原文
+func (b timeBound) value(f *schema.Field) interface{} {
+	switch f.DataType {
+	case schema.Time:
+		return b.time
+	case schema.Int, schema.Uint:
+		unit := f.AutoCreateTime
+		if unit == 0 {
+			unit = f.AutoUpdateTime
+		}
+		switch unit {
+		case schema.UnixMillisecond:
+			return b.time.UnixMilli()
+		case schema.UnixNanosecond:
+			return b.time.UnixNano()
+		}
+		return b.time.Unix()
+	}
+	return b.text
+}
+
+// likeEscaper 转义 LIKE 通配符, 配合 ESCAPE '\' 使用
+var likeEscaper = strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_")
+
+func parseTime(s string) (time.Time, bool, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, true, nil
+	}
+	if t, err := time.ParseInLocation(time.DateTime, s, time.Local); err == nil {
+		return t, false, nil
+	}
+	return time.Time{}, false, ErrInvalidTime
+}
+
+func betweenExpr(c clause.Column, f *schema.Field, begin, end interface{}) clause.Expression {
+	var upper clause.Expression
+	switch e := end.(type) {
+	case nil:
+	case timeBound:
+		if e.exclusive {
+			upper = clause.Lt{Column: c, Value: e.value(f)}
+		} else {
+			upper = clause.Lte{Column: c, Value: e.value(f)}
+		}
+	default:
+		upper = clause.Lte{Column: c, Value: e}
+	}
+	if b, ok := begin.(timeBound); ok {
+		begin = b.value(f)
+	}
+	switch {
+	case begin == nil:
+		return upper
+	case upper == nil:
+		return clause.Gte{Column: c, Value: begin}
+	}
+	if lte, ok := upper.(clause.Lte); ok {
+		return clause.Expr{SQL: "? BETWEEN ? AND ?", Vars: []interface{}{c, begin, lte.Value}}
+	}
+	return clause.And(clause.Gte{Column: c, Value: begin}, upper)
+}
+
+// LookUpField 按字段名、列名或驼峰列名（如 createTime）查找有列名的字段
+func LookUpField(s *schema.Schema, namer schema.Namer, name string) *schema.Field {
+	if name == "" {
+		return nil
+	}
+	f := s.LookUpField(name)
+	if f == nil {
+		f = s.LookUpField(namer.ColumnName("", name))
+	}
+	if f == nil || f.DBName == "" {
+		return nil
+	}
+	return f
+}
@@ -0,0 +1,146 @@
+package query_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ovra-cloud/ovra-toolkit/gorm/page"
+	"github.com/ovra-cloud/ovra-toolkit/gorm/query"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type SysUser struct {
+	ID         int64
+	UserName   string
+	NickName   string
+	Status     string
+	DeptID     int64
+	Admin      bool
+	CreateTime string
+}
+
+type UserListReq struct {
+	page.Request
+	UserName  string          `query:"like"`
+	Status    string          `query:"in"`
+	DeptIds   []int64         `query:"in;column=dept_id"`
+	NotStatus string          `query:"ne;column=status"`
+	Keyword   string          `query:"like;column=user_name|nickName"`
+	Admin     *bool           `query:"eq"`
+	MinID     int64           `query:"gte;column=id"`
+	MaxID     int64           `query:"lte;column=id"`
+	IDs       [2]int64        `query:"between;column=id"`
+	Params    query.TimeRange `query:"between;column=create_time"`
+	Ignored   string
+}
+
+func newDryRunDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		DSN:                       "root:root@tcp(127.0.0.1:3306)/dry_run",
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, Logger: logger.Discard})
+	require.NoError(t, err)
+	return db
+}
+
+func TestWhere(t *testing.T) {
+	db := newDryRunDB(t)
+	find := func(req interface{}) *gorm.Statement {
+		return db.Scopes(query.Where(req)).Find(&[]SysUser{}).Statement
+	}
+
+	stmt := find(&UserListReq{Ignored: "x"})
+	assert.Equal(t, "SELECT * FROM `sys_users`", stmt.SQL.String())
+
+	admin := false
+	stmt = find(UserListReq{
+		UserName: "ad",
+		Status:   "0,1",
+		DeptIds:  []int64{100, 101},
+		Keyword:  "k",
+		Admin:    &admin,
+		MinID:    10,
+	})
+	assert.Equal(t, "SELECT * FROM `sys_users` WHERE `sys_users`.`user_name` LIKE ? ESCAPE '\\\\' AND `sys_users`.`status` IN (?,?) "+
+		"AND `sys_users`.`dept_id` IN (?,?) AND (`sys_users`.`user_name` LIKE ? ESCAPE '\\\\' OR `sys_users`.`nick_name` LIKE ? ESCAPE '\\\\') "+
+		"AND `sys_users`.`admin` = ? AND `sys_users`.`id` >= ?", stmt.SQL.String())
+	assert.Equal(t, []interface{}{"%ad%", "0", "1", int64(100), int64(101), "%k%", "%k%", false, int64(10)}, stmt.Vars)
+
+	stmt = find(&UserListReq{UserName: `50%_a\b`})
+	assert.Equal(t, []interface{}{`%50\%\_a\\b%`}, stmt.Vars)
+
+	stmt = find(&UserListReq{NotStatus: "2", MaxID: 9, IDs: [2]int64{1, 5}})
+	assert.Equal(t, "SELECT * FROM `sys_users` WHERE `sys_users`.`status` <> ? AND `sys_users`.`id` <= ? "+
+		"AND (`sys_users`.`id` BETWEEN ? AND ?)", stmt.SQL.String())
+}
+
+func TestWhereTimeRange(t *testing.T) {
+	db := newDryRunDB(t)
+	find := func(tr query.TimeRange) *gorm.DB {
+		return db.Scopes(query.Where(&UserListReq{Params: tr})).Find(&[]SysUser{})
+	}
+
+	stmt := find(query.TimeRange{BeginTime: "2024-01-01", EndTime: "2024-01-31"}).Statement
+	assert.Equal(t, "SELECT * FROM `sys_users` WHERE `sys_users`.`create_time` >= ? AND `sys_users`.`create_time` < ?",
+		stmt.SQL.String())
+	assert.Equal(t, []interface{}{"2024-01-01", "2024-02-01"}, stmt.Vars)
+
+	stmt = find(query.TimeRange{BeginTime: "2024-01-01 08:00:00", EndTime: "2024-01-31 18:00:00"}).Statement
+	assert.Equal(t, "SELECT * FROM `sys_users` WHERE `sys_users`.`create_time` BETWEEN ? AND ?", stmt.SQL.String())
+
+	stmt = find(query.TimeRange{EndTime: "2024-01-31 18:00:00"}).Statement
+	assert.Equal(t, "SELECT * FROM `sys_users` WHERE `sys_users`.`create_time` <= ?", stmt.SQL.String())
+
+	assert.ErrorIs(t, find(query.TimeRange{BeginTime: "1' OR '1'='1"}).Error, query.ErrInvalidTime)
+}
+
+type Order struct {
+	ID        int64
+	Amount    float64
+	PaidAt    time.Time
+	CreatedAt int64 `gorm:"autoCreateTime:milli"`
+	UpdatedAt int64
+}
+
+func TestWhereTimeRangeColumnType(t *testing.T) {
+	db := newDryRunDB(t)
+	find := func(req interface{}) *gorm.DB {
+		return db.Scopes(query.Where(req)).Find(&[]Order{})
+	}
+	tr := query.TimeRange{BeginTime: "2024-01-01", EndTime: "2024-01-31"}
+	begin := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	end := time.Date(2024, 2, 1, 0, 0, 0, 0, time.Local)
+
+	stmt := find(&struct {
+		Params query.TimeRange `query:"between;column=paid_at"`
+	}{tr}).Statement
+	assert.Equal(t, "SELECT * FROM `orders` WHERE `orders`.`paid_at` >= ? AND `orders`.`paid_at` < ?", stmt.SQL.String())
+	assert.Equal(t, []interface{}{begin, end}, stmt.Vars)
+
+	stmt = find(&struct {
+		Params query.TimeRange `query:"between;column=created_at|updated_at"`
+	}{tr}).Statement
+	assert.Equal(t, []interface{}{begin.UnixMilli(), end.UnixMilli(), begin.Unix(), end.Unix()}, stmt.Vars)
+
+	assert.Error(t, find(&struct {
+		Params query.TimeRange `query:"between;column=amount"`
+	}{tr}).Error)
+}
+
+func TestWhereInvalidColumn(t *testing.T) {
+	db := newDryRunDB(t)
+	type badReq struct {
+		Name string `query:"eq;column=password"`
+	}
+	type badOp struct {
+		UserName string `query:"regexp"`
+	}
+	assert.Error(t, db.Scopes(query.Where(&badReq{Name: "x"})).Find(&[]SysUser{}).Error)
+	assert.Error(t, db.Scopes(query.Where(&badOp{UserName: "x"})).Find(&[]SysUser{}).Error)
+	assert.NoError(t, db.Scopes(query.Where(&badReq{})).Find(&[]SysUser{}).Error)
+}
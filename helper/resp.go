@@ -7,6 +7,7 @@ import (
 	"reflect"
 
 	"github.com/ovra-cloud/ovra-toolkit/errx"
+	"github.com/ovra-cloud/ovra-toolkit/mask"
 
 	"github.com/zeromicro/go-zero/core/logx"
 )
@@ -60,6 +61,13 @@ func OkHandler(_ context.Context, v interface{}) any {
 	return Success(v)
 }
 
+// MaskOkHandler 按 mask 标签对响应数据脱敏, 拥有 permission 的用户返回明文
+func MaskOkHandler(permission string) func(ctx context.Context, v interface{}) any {
+	return func(ctx context.Context, v interface{}) any {
+		return Success(mask.Apply(ctx, v, permission))
+	}
+}
+
 func ErrHandler(name string) func(ctx context.Context, err error) (int, any) {
 	return func(ctx context.Context, err error) (int, any) {
 		logx.WithContext(ctx).Errorf("【%s】 err %v", name, err)
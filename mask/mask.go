@@ -0,0 +1,352 @@
+// Package mask
+// @Description: 按结构体标签对敏感字段脱敏
+//
+//	Phone   string `mask:"phone"`
+//	IDCard  string `mask:"idcard;perm=system:user:sensitive"`
+//	Code    string `mask:"regex=^(.{2}).*(.{2})$;replace=$1****$2"`
+//
+// 标签值按 Go 字符串解析, 正则中的反斜杠需写为 \\, 正则可以包含 ;.
+// 正则无法编译时该字段全部替换为 *, 可在启动时调用 Validate 检查.
+// 拥有字段声明的权限（perm）或调用方传入的权限时不脱敏, 拥有 auth.AllPermission 的用户视为拥有全部权限
+package mask
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+const (
+	Tag = "mask" // 脱敏标签
+
+	Phone    = "phone"    // 手机号, 保留前 3 位和后 4 位
+	Email    = "email"    // 邮箱, 保留用户名首字符和域名
+	IDCard   = "idcard"   // 身份证号, 保留前 3 位和后 4 位
+	BankCard = "bankcard" // 银行卡号, 保留后 4 位
+	Name     = "name"     // 姓名, 保留首字符, 三个字及以上同时保留末字符
+	Address  = "address"  // 地址, 保留前 6 个字符
+)
+
+// Strategy 脱敏策略
+type Strategy func(value string) string
+
+var (
+	strategyMu sync.RWMutex
+	strategies = map[string]Strategy{
+		Phone:    func(s string) string { return Keep(s, 3, 4) },
+		Email:    maskEmail,
+		IDCard:   func(s string) string { return Keep(s, 3, 4) },
+		BankCard: func(s string) string { return Keep(s, 0, 4) },
+		Name:     maskName,
+		Address:  func(s string) string { return Keep(s, 6, 0) },
+	}
+
+	rules    sync.Map // reflect.Type => []fieldRule
+	tagRules sync.Map // 标签值 => tagRule
+)
+
+// Register 注册自定义脱敏策略, 可覆盖内置策略
+func Register(name string, strategy Strategy) {
+	strategyMu.Lock()
+	defer strategyMu.Unlock()
+	strategies[name] = strategy
+}
+
+func lookup(name string) Strategy {
+	strategyMu.RLock()
+	defer strategyMu.RUnlock()
+	return strategies[name]
+}
+
+// Keep 保留前 head 个和后 tail 个字符, 其余替换为 *; 长度不足时仅保留首字符
+func Keep(s string, head, tail int) string {
+	runes := []rune(s)
+	n := len(runes)
+	if n == 0 {
+		return s
+	}
+	if head+tail >= n {
+		head, tail = min(1, n-1), 0
+	}
+	return string(runes[:head]) + strings.Repeat("*", n-head-tail) + string(runes[n-tail:])
+}
+
+func maskEmail(s string) string {
+	at := strings.LastIndex(s, "@")
+	if at <= 0 {
+		return Keep(s, 1, 0)
+	}
+	_, size := utf8.DecodeRuneInString(s)
+	return s[:size] + "***" + s[at:]
+}
+
+func maskName(s string) string {
+	if utf8.RuneCountInString(s) >= 3 {
+		return Keep(s, 1, 1)
+	}
+	return Keep(s, 1, 0)
+}
+
+// fieldRule 字段的脱敏规则
+type fieldRule struct {
+	index []int
+	tagRule
+}
+
+// tagRule 标签解析结果, 相同的标签只解析一次
+type tagRule struct {
+	strategy Strategy
+	perm     string
+	err      error
+}
+
+// parseRules 解析结构体的脱敏规则, 嵌入的结构体在遍历时单独处理
+func parseRules(t reflect.Type) []fieldRule {
+	if v, ok := rules.Load(t); ok {
+		return v.([]fieldRule)
+	}
+	var result []fieldRule
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, ok := f.Tag.Lookup(Tag)
+		if !ok || tag == "-" || !f.IsExported() {
+			continue
+		}
+		rule := fieldRule{index: f.Index, tagRule: parseTag(tag)}
+		if rule.err != nil {
+			logx.Errorf("[mask] %s.%s: %v", t, f.Name, rule.err)
+		}
+		if rule.strategy != nil {
+			result = append(result, rule)
+		}
+	}
+	rules.Store(t, result)
+	return result
+}
+
+// parseTag 解析标签, 正则无法编译时返回错误, 并将字段全部替换为 *
+func parseTag(tag string) tagRule {
+	if v, ok := tagRules.Load(tag); ok {
+		return v.(tagRule)
+	}
+	var rule tagRule
+	var pattern, replace string
+	var hasPattern bool
+	for _, opt := range splitOptions(tag) {
+		key, value, _ := strings.Cut(opt, "=")
+		switch key {
+		case "perm":
+			rule.perm = value
+		case "regex":
+			pattern, hasPattern = value, true
+		case "replace":
+			replace = value
+		default:
+			if s := lookup(key); s != nil {
+				rule.strategy = s
+			}
+		}
+	}
+	if hasPattern {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			rule.err = fmt.Errorf("invalid regex %q: %w", pattern, err)
+			rule.strategy = func(s string) string { return Keep(s, 0, 0) }
+		} else {
+			rule.strategy = func(s string) string { return re.ReplaceAllString(s, replace) }
+		}
+	}
+	tagRules.Store(tag, rule)
+	return rule
+}
+
+// splitOptions 按 ; 拆分标签选项, regex、replace 的值中不是选项开头的 ; 保留在值中
+func splitOptions(tag string) []string {
+	var opts []string
+	for _, part := range strings.Split(tag, ";") {
+		if n := len(opts); n > 0 && !isOption(part) {
+			if key, _, _ := strings.Cut(opts[n-1], "="); key == "regex" || key == "replace" {
+				opts[n-1] += ";" + part
+				continue
+			}
+		}
+		opts = append(opts, strings.TrimSpace(part))
+	}
+	return opts
+}
+
+// isOption 判断是否为选项开头: perm=、regex=、replace= 或已注册的策略
+func isOption(s string) bool {
+	key, _, hasValue := strings.Cut(strings.TrimSpace(s), "=")
+	if hasValue {
+		return key == "perm" || key == "regex" || key == "replace"
+	}
+	return lookup(key) != nil
+}
+
+// Validate 检查 v 的类型及嵌套结构体中的 mask 标签, 返回第一个无法编译的正则
+func Validate(v interface{}) error {
+	return validate(reflect.TypeOf(v), map[reflect.Type]bool{})
+}
+
+func validate(t reflect.Type, visited map[reflect.Type]bool) error {
+	if t == nil || visited[t] {
+		return nil
+	}
+	visited[t] = true
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return validate(t.Elem(), visited)
+	case reflect.Struct:
+		for _, rule := range parseRules(t) {
+			if rule.err != nil {
+				return fmt.Errorf("mask: %s.%s: %w", t, t.FieldByIndex(rule.index).Name, rule.err)
+			}
+		}
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.IsExported() {
+				if err := validate(f.Type, visited); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Apply 对 v 中带 mask 标签的字段脱敏, 支持结构体、指针、切片、map 及嵌套结构体.
+// 指针参数原地修改; 非指针参数返回脱敏后的副本, 其中的切片、map 同时复制, 不修改调用方的数据,
+// 但经指针引用的数据仍原地修改. 拥有 permission 的用户不脱敏, 为空时仅按字段权限判断
+func Apply(ctx context.Context, v interface{}, permission string) interface{} {
+	if v == nil {
+		return v
+	}
+	perms := auth.GetPermissions(ctx)
+	if slices.Contains(perms, auth.AllPermission) || (permission != "" && slices.Contains(perms, permission)) {
+		return v
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr {
+		copied := reflect.New(rv.Type())
+		copied.Elem().Set(rv)
+		walk(copied.Elem(), perms, map[uintptr]bool{}, true)
+		return copied.Elem().Interface()
+	}
+	walk(rv, perms, map[uintptr]bool{}, false)
+	return v
+}
+
+// walk 遍历并脱敏, clone 为 true 时先复制切片与 map 再修改
+func walk(rv reflect.Value, perms []string, visited map[uintptr]bool, clone bool) {
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() || visited[rv.Pointer()] {
+			return
+		}
+		visited[rv.Pointer()] = true
+		walk(rv.Elem(), perms, visited, false)
+	case reflect.Interface:
+		if rv.IsNil() {
+			return
+		}
+		elem := rv.Elem()
+		if elem.Kind() == reflect.Ptr || !rv.CanSet() {
+			walk(elem, perms, visited, clone)
+			return
+		}
+		// interface 中的值不可寻址, 复制后写回
+		copied := reflect.New(elem.Type()).Elem()
+		copied.Set(elem)
+		walk(copied, perms, visited, clone)
+		rv.Set(copied)
+	case reflect.Slice:
+		if rv.IsNil() {
+			return
+		}
+		if clone {
+			if !rv.CanSet() {
+				return
+			}
+			copied := reflect.MakeSlice(rv.Type(), rv.Len(), rv.Len())
+			reflect.Copy(copied, rv)
+			rv.Set(copied)
+		}
+		for i := 0; i < rv.Len(); i++ {
+			walk(rv.Index(i), perms, visited, clone)
+		}
+	case reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			walk(rv.Index(i), perms, visited, clone)
+		}
+	case reflect.Map:
+		if rv.IsNil() {
+			return
+		}
+		if clone {
+			if !rv.CanSet() {
+				return
+			}
+			copied := reflect.MakeMapWithSize(rv.Type(), rv.Len())
+			iter := rv.MapRange()
+			for iter.Next() {
+				copied.SetMapIndex(iter.Key(), iter.Value())
+			}
+			rv.Set(copied)
+		}
+		iter := rv.MapRange()
+		for iter.Next() {
+			if !nested(iter.Value()) {
+				continue
+			}
+			value := reflect.New(iter.Value().Type()).Elem()
+			value.Set(iter.Value())
+			walk(value, perms, visited, clone)
+			rv.SetMapIndex(iter.Key(), value)
+		}
+	case reflect.Struct:
+		if !rv.CanSet() {
+			return
+		}
+		for _, rule := range parseRules(rv.Type()) {
+			if rule.perm != "" && slices.Contains(perms, rule.perm) {
+				continue
+			}
+			maskValue(rv.FieldByIndex(rule.index), rule.strategy)
+		}
+		for i := 0; i < rv.NumField(); i++ {
+			if f := rv.Field(i); rv.Type().Field(i).IsExported() && nested(f) {
+				walk(f, perms, visited, clone)
+			}
+		}
+	}
+}
+
+// nested 判断值是否可能包含需要脱敏的结构体
+func nested(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Struct, reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map, reflect.Interface:
+		return true
+	}
+	return false
+}
+
+// maskValue 修改 string / *string 字段
+func maskValue(f reflect.Value, strategy Strategy) {
+	if f.Kind() == reflect.Ptr {
+		if f.IsNil() {
+			return
+		}
+		f = f.Elem()
+	}
+	if f.Kind() == reflect.String && f.CanSet() && f.String() != "" {
+		f.SetString(strategy(f.String()))
+	}
+}
@@ -0,0 +1,124 @@
+package mask_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+	"github.com/ovra-cloud/ovra-toolkit/mask"
+	"github.com/stretchr/testify/assert"
+)
+
+type Contact struct {
+	Phone   string `mask:"phone"`
+	Email   string `mask:"email"`
+	Address string `mask:"address"`
+}
+
+type Person struct {
+	Contact
+	Name     string  `mask:"name"`
+	IDCard   *string `mask:"idcard;perm=system:user:sensitive"`
+	BankCard string  `mask:"bankcard"`
+	Code     string  `mask:"regex=^(.{2}).*(.{2})$;replace=$1****$2"`
+	Plate    string  `mask:"plate"`
+	Friends  []*Person
+}
+
+func TestKeep(t *testing.T) {
+	assert.Equal(t, "138****5678", mask.Keep("13812345678", 3, 4))
+	assert.Equal(t, "************5678", mask.Keep("6222021234565678", 0, 4))
+	assert.Equal(t, "1**", mask.Keep("123", 3, 4))
+	assert.Equal(t, "*", mask.Keep("1", 1, 0))
+	assert.Equal(t, "", mask.Keep("", 1, 1))
+}
+
+func TestApply(t *testing.T) {
+	mask.Register("plate", func(s string) string { return mask.Keep(s, 2, 0) })
+	newPerson := func() *Person {
+		idCard := "110101199001011234"
+		return &Person{
+			Contact:  Contact{Phone: "13812345678", Email: "alice@example.com", Address: "北京市海淀区中关村大街1号"},
+			Name:     "欧阳娜娜",
+			IDCard:   &idCard,
+			BankCard: "6222021234565678",
+			Code:     "AB123456CD",
+			Plate:    "京A12345",
+			Friends:  []*Person{{Name: "张三"}},
+		}
+	}
+
+	t.Run("脱敏", func(t *testing.T) {
+		p := newPerson()
+		mask.Apply(context.Background(), p, "")
+		assert.Equal(t, "138****5678", p.Phone)
+		assert.Equal(t, "a***@example.com", p.Email)
+		assert.Equal(t, "北京市海淀区"+strings.Repeat("*", 7), p.Address)
+		assert.Equal(t, "欧**娜", p.Name)
+		assert.Equal(t, "110***********1234", *p.IDCard)
+		assert.Equal(t, "************5678", p.BankCard)
+		assert.Equal(t, "AB****CD", p.Code)
+		assert.Equal(t, "京A*****", p.Plate)
+		assert.Equal(t, "张*", p.Friends[0].Name)
+	})
+
+	t.Run("非指针返回副本", func(t *testing.T) {
+		p := newPerson()
+		got := mask.Apply(context.Background(), []Person{*p}, "").([]Person)
+		assert.Equal(t, "138****5678", got[0].Phone)
+
+		data := map[string]interface{}{"user": *p}
+		masked := mask.Apply(context.Background(), data, "").(map[string]interface{})
+		assert.Equal(t, "138****5678", masked["user"].(Person).Phone)
+
+		// 嵌套的切片、map 同时复制, 不修改调用方的数据
+		list := []Person{*p}
+		mask.Apply(context.Background(), list, "")
+		assert.Equal(t, "13812345678", list[0].Phone)
+		assert.Equal(t, "13812345678", data["user"].(Person).Phone)
+		nested := struct{ Users map[string][]Person }{Users: map[string][]Person{"a": {*p}}}
+		copied := mask.Apply(context.Background(), nested, "").(struct{ Users map[string][]Person })
+		assert.Equal(t, "138****5678", copied.Users["a"][0].Phone)
+		assert.Equal(t, "13812345678", nested.Users["a"][0].Phone)
+	})
+
+	t.Run("权限", func(t *testing.T) {
+		ctx := context.WithValue(context.Background(), auth.PermissionsKey, []string{"system:user:sensitive"})
+		p := newPerson()
+		mask.Apply(ctx, p, "")
+		assert.Equal(t, "110101199001011234", *p.IDCard)
+		assert.Equal(t, "138****5678", p.Phone)
+
+		p = newPerson()
+		mask.Apply(ctx, p, "system:user:sensitive")
+		assert.Equal(t, "13812345678", p.Phone)
+
+		ctx = context.WithValue(context.Background(), auth.PermissionsKey, []string{"*:*:*"})
+		p = newPerson()
+		mask.Apply(ctx, p, "")
+		assert.Equal(t, "欧阳娜娜", p.Name)
+	})
+}
+
+type Token struct {
+	Secret  string `mask:"regex=^([a-z]+);.*$;replace=$1;***"`
+	Broken  string `mask:"regex=([a-z]"`
+	Private string `mask:"regex=^(.).*(.)$;replace=$1*$2;perm=system:token:query"`
+}
+
+func TestApplyRegex(t *testing.T) {
+	tk := &Token{Secret: "key;abc", Broken: "abc", Private: "abcd"}
+	mask.Apply(context.Background(), tk, "")
+	assert.Equal(t, "key;***", tk.Secret)
+	assert.Equal(t, "***", tk.Broken)
+	assert.Equal(t, "a*d", tk.Private)
+
+	ctx := context.WithValue(context.Background(), auth.PermissionsKey, []string{"system:token:query"})
+	tk = &Token{Private: "abcd"}
+	mask.Apply(ctx, tk, "")
+	assert.Equal(t, "abcd", tk.Private)
+
+	assert.ErrorContains(t, mask.Validate([]*Token{}), "Broken")
+	assert.NoError(t, mask.Validate(&Person{}))
+}
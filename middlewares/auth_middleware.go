@@ -56,6 +56,8 @@ func ExecHandle(next http.HandlerFunc, accessSecret string, rds *redis.Redis, mu
 		ctx := context.WithValue(r.Context(), auth.UserIDKey, uc.UserId)
 		ctx = context.WithValue(ctx, auth.TenantIDKey, tenantId)
 		ctx = context.WithValue(ctx, auth.ClientIDKey, uc.ClientId)
+		ctx = context.WithValue(ctx, auth.PermissionsKey, uc.Permissions)
+		ctx = context.WithValue(ctx, auth.RoleScopesKey, uc.RoleScopes)
 		next(w, r.WithContext(ctx))
 	}
 }
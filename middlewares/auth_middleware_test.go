@@ -0,0 +1,62 @@
+package middlewares_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+	"github.com/ovra-cloud/ovra-toolkit/gorm/plugin"
+	"github.com/ovra-cloud/ovra-toolkit/middlewares"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type Doc struct {
+	ID         int64
+	CreateDept string
+	CreateBy   string
+}
+
+func TestExecHandleRoleScopes(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rds := redis.MustNewRedis(redis.RedisConf{Host: mr.Addr(), Type: redis.NodeType})
+	const secret = "secret"
+
+	user := auth.UserInfo{UserId: "1", TenantId: "1", ClientId: "web", RoleScopes: []auth.RoleScope{
+		{RoleId: "r1", DataScope: 5},
+		{RoleId: "r2", DataScope: 2, DeptIds: []string{"7", "9"}},
+	}}
+	token, err := auth.GenerateToken(user, secret, 60)
+	require.NoError(t, err)
+	mr.HSet(fmt.Sprintf(auth.TokenKey, user.ClientId, user.UserId),
+		auth.FieldToken, token, auth.FieldActiveTimeout, "0", auth.FieldCurrentTime, "0")
+
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		DSN:                       "root:root@tcp(127.0.0.1:3306)/dry_run",
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.Use(&plugin.DataScopePlugin{Enabled: true}))
+
+	// 令牌中的角色数据权限经中间件写入上下文, 由数据权限插件生效
+	var stmt *gorm.Statement
+	handler := middlewares.ExecHandle(func(w http.ResponseWriter, r *http.Request) {
+		stmt = db.WithContext(r.Context()).Find(&[]Doc{}).Statement
+	}, secret, rds, false)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NotNil(t, stmt)
+	assert.Equal(t, "SELECT * FROM `docs` WHERE (`docs`.`create_by` = ? OR `docs`.`create_dept` IN (?,?))", stmt.SQL.String())
+	assert.Equal(t, []interface{}{"1", "7", "9"}, stmt.Vars)
+}
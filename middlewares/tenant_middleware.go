@@ -0,0 +1,62 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+	"github.com/ovra-cloud/ovra-toolkit/errx"
+	"github.com/ovra-cloud/ovra-toolkit/helper"
+	"github.com/ovra-cloud/ovra-toolkit/tenant"
+)
+
+// TenantHandle 校验租户状态、有效期与在线名额, 并将用户权限限制在租户套餐范围内
+//
+// 需要在 ExecHandle 之后执行
+func TenantHandle(next http.HandlerFunc, registry *tenant.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenantId, ok := auth.LookupTenantId(r.Context())
+		if !ok {
+			writeTenantErr(w, errx.New(errx.CodeTenantNotFound, "租户不存在"))
+			return
+		}
+		info, err := registry.Check(r.Context(), tenantId)
+		if err != nil {
+			writeTenantErr(w, err)
+			return
+		}
+		if userId, ok := auth.LookupUserId(r.Context()); ok {
+			if err = registry.Occupy(r.Context(), info, userId); err != nil {
+				writeTenantErr(w, err)
+				return
+			}
+		}
+		perms := info.FilterPermissions(auth.GetPermissions(r.Context()))
+		ctx := context.WithValue(r.Context(), auth.PermissionsKey, perms)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// writeTenantErr 以 helper.Fail 的 JSON 格式输出 errx 错误码:
+// 租户不存在返回 401, 租户不可用返回 403, 其余返回 500
+func writeTenantErr(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var e *errx.Error
+	if errors.As(err, &e) {
+		switch e.Code {
+		case errx.CodeTenantNotFound:
+			status = http.StatusUnauthorized
+		case errx.CodeTenantDisabled, errx.CodeTenantExpired, errx.CodeTenantSeatMax:
+			status = http.StatusForbidden
+		}
+	}
+	if status == http.StatusInternalServerError {
+		err = errx.New(errx.CodeInternal, "系统错误")
+	}
+	b, _ := json.Marshal(helper.Fail(err))
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = w.Write(b)
+}
@@ -0,0 +1,69 @@
+package middlewares_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+	"github.com/ovra-cloud/ovra-toolkit/errx"
+	"github.com/ovra-cloud/ovra-toolkit/helper"
+	"github.com/ovra-cloud/ovra-toolkit/middlewares"
+	"github.com/ovra-cloud/ovra-toolkit/tenant"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+func TestTenantHandle(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rds := redis.MustNewRedis(redis.RedisConf{Host: mr.Addr(), Type: redis.NodeType})
+	registry := tenant.NewRegistry(rds, tenant.LoaderFunc(func(_ context.Context, tenantId string) (*tenant.Info, error) {
+		switch tenantId {
+		case "1":
+			return &tenant.Info{TenantId: "1", Status: tenant.StatusNormal, SeatLimit: 1, PackageId: "p1",
+				Permissions: []string{"system:user:list"}}, nil
+		case "2":
+			return &tenant.Info{TenantId: "2", Status: tenant.StatusDisable}, nil
+		}
+		return nil, nil
+	}))
+
+	var perms []string
+	handler := middlewares.TenantHandle(func(w http.ResponseWriter, r *http.Request) {
+		perms = auth.GetPermissions(r.Context())
+	}, registry)
+	var body helper.Response
+	serve := func(ctx context.Context) int {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+		body = helper.Response{}
+		if w.Code != http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		}
+		return w.Code
+	}
+	withUser := func(tenantId, userId string) context.Context {
+		ctx := context.WithValue(context.Background(), auth.TenantIDKey, tenantId)
+		ctx = context.WithValue(ctx, auth.UserIDKey, userId)
+		return context.WithValue(ctx, auth.PermissionsKey, []string{"system:user:list", "system:role:list"})
+	}
+
+	assert.Equal(t, http.StatusOK, serve(withUser("1", "u1")))
+	assert.Equal(t, []string{"system:user:list"}, perms)
+
+	assert.Equal(t, http.StatusUnauthorized, serve(context.Background()))
+	assert.Equal(t, int32(errx.CodeTenantNotFound), body.Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(withUser("404", "u1")))
+	assert.Equal(t, int32(errx.CodeTenantNotFound), body.Code)
+	assert.Equal(t, http.StatusForbidden, serve(withUser("2", "u1")))
+	assert.Equal(t, int32(errx.CodeTenantDisabled), body.Code)
+	assert.NotEmpty(t, body.Msg)
+	// 在线名额已被 u1 占用
+	assert.Equal(t, http.StatusForbidden, serve(withUser("1", "u2")))
+	assert.Equal(t, int32(errx.CodeTenantSeatMax), body.Code)
+	assert.Equal(t, http.StatusOK, serve(withUser("1", "u1")))
+}
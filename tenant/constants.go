@@ -1,5 +1,40 @@
 package tenant
 
+import (
+	"time"
+
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+)
+
+const (
+	TENANT_KEY      = "tenant:%s"      //userId
+	TENANT_INFO_KEY = "tenant_info:%s" //tenantId
+	TENANT_SCOPE    = "t:%s:"          //tenantId, 租户级缓存 key 前缀
+	TENANT_SEAT_KEY = "tenant_seat:%s" //tenantId, 在线用户 ZSet, score 为最近活跃时间
+
+	// Redis Hash 字段
+	FieldNewTenant  = "nt" // 切换后的租户
+	FieldOldTenant  = "ot" // 原始租户
+	FieldSwitchTime = "st" // 切换时间（秒）
+
+	// DefaultSwitchExpire 租户切换默认有效期
+	DefaultSwitchExpire = 2 * time.Hour
+	// DefaultInfoExpire 租户信息默认缓存时间
+	DefaultInfoExpire = 10 * time.Minute
+	// DefaultNotFoundExpire 不存在的租户默认缓存时间
+	DefaultNotFoundExpire = time.Minute
+	// DefaultSeatWindow 超过该时间未活跃的用户不再占用在线名额
+	DefaultSeatWindow = 30 * time.Minute
+
+	StatusNormal  = "0" // 租户正常
+	StatusDisable = "1" // 租户停用
+
+	// AllPermission 超级权限标识
+	AllPermission = auth.AllPermission
+)
+
+// 审计动作
 const (
-	TENANT_KEY = "tenant:%s" //userId
+	ActionSwitch  = "switch"
+	ActionRestore = "restore"
 )
@@ -0,0 +1,94 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ovra-cloud/ovra-toolkit/gorm/plugin"
+
+	"gorm.io/gorm"
+)
+
+// GormLoader 基于数据库的租户加载器, 默认表结构与 sys_tenant / sys_tenant_package / sys_menu 一致
+type GormLoader struct {
+	DB           *gorm.DB
+	TenantTable  string // 默认 sys_tenant
+	PackageTable string // 默认 sys_tenant_package
+	MenuTable    string // 默认 sys_menu
+}
+
+type tenantRow struct {
+	TenantId     string
+	Status       string
+	ExpireTime   *time.Time
+	AccountCount int64
+	PackageId    string
+}
+
+// NewGormLoader 创建基于数据库的租户加载器
+func NewGormLoader(db *gorm.DB) *GormLoader {
+	return &GormLoader{
+		DB:           db,
+		TenantTable:  "sys_tenant",
+		PackageTable: "sys_tenant_package",
+		MenuTable:    "sys_menu",
+	}
+}
+
+func (l *GormLoader) Load(ctx context.Context, tenantId string) (*Info, error) {
+	db := l.DB.WithContext(plugin.IgnoreTenant(ctx))
+
+	var row tenantRow
+	err := db.Table(l.TenantTable).
+		Select("tenant_id, status, expire_time, account_count, package_id").
+		Where("tenant_id = ? AND del_flag = ?", tenantId, "0").
+		Take(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// account_count 为 -1 或空时不限制用户数, 在线数不限制
+	info := &Info{
+		TenantId:  row.TenantId,
+		Status:    row.Status,
+		UserLimit: max(row.AccountCount, 0),
+		PackageId: row.PackageId,
+	}
+	if row.ExpireTime != nil {
+		info.ExpireTime = *row.ExpireTime
+	}
+	if info.PackageId == "" {
+		return info, nil
+	}
+
+	var menuIds string
+	err = db.Table(l.PackageTable).
+		Select("menu_ids").
+		Where("package_id = ? AND status = ?", info.PackageId, StatusNormal).
+		Take(&menuIds).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	for _, id := range strings.Split(menuIds, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			info.MenuIds = append(info.MenuIds, id)
+		}
+	}
+	// 套餐停用或为空时不授予任何权限
+	info.Permissions = []string{}
+	if len(info.MenuIds) == 0 {
+		return info, nil
+	}
+	err = db.Table(l.MenuTable).
+		Where("menu_id IN ? AND perms <> ''", info.MenuIds).
+		Pluck("perms", &info.Permissions).Error
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
@@ -0,0 +1,50 @@
+package tenant
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestGormLoader(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	for _, sql := range []string{
+		"CREATE TABLE sys_tenant (tenant_id TEXT, status TEXT, expire_time DATETIME, account_count INTEGER, package_id TEXT, del_flag TEXT)",
+		"CREATE TABLE sys_tenant_package (package_id TEXT, menu_ids TEXT, status TEXT)",
+		"CREATE TABLE sys_menu (menu_id TEXT, perms TEXT)",
+		"INSERT INTO sys_tenant VALUES ('1', '0', NULL, -1, '', '0'), ('2', '0', '2030-01-01 00:00:00', 10, 'p1', '0'), ('3', '0', NULL, -1, '', '2'), ('4', '0', NULL, NULL, '', '0')",
+		"INSERT INTO sys_tenant_package VALUES ('p1', '100, 101,102', '0')",
+		"INSERT INTO sys_menu VALUES ('100', 'system:user:list'), ('101', ''), ('102', 'system:role:list'), ('103', 'system:menu:list')",
+	} {
+		require.NoError(t, db.Exec(sql).Error)
+	}
+	ctx := context.Background()
+	loader := NewGormLoader(db)
+
+	info, err := loader.Load(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, &Info{TenantId: "1", Status: StatusNormal}, info)
+
+	info, err = loader.Load(ctx, "2")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), info.UserLimit)
+	assert.Equal(t, 2030, info.ExpireTime.Year())
+	assert.Equal(t, []string{"100", "101", "102"}, info.MenuIds)
+	assert.ElementsMatch(t, []string{"system:user:list", "system:role:list"}, info.Permissions)
+
+	info, err = loader.Load(ctx, "3")
+	require.NoError(t, err)
+	assert.Nil(t, info)
+
+	info, err = loader.Load(ctx, "4")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), info.UserLimit)
+}
@@ -0,0 +1,210 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+// ErrNoTenant 上下文中没有租户ID
+var ErrNoTenant = errors.New("tenant: no tenant id in context")
+
+// ErrInvalidTenantId 租户ID包含 key 分隔符, 会与其他租户的 key 冲突
+var ErrInvalidTenantId = errors.New("tenant: tenant id must not contain ':'")
+
+// globEscaper 转义 SCAN 匹配模式中的特殊字符
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// flushBatch 批量清除时每次 SCAN 的数量
+const flushBatch = 500
+
+// Redis 租户隔离的 Redis, 所有 key 自动加上 t:{tenantId}: 前缀
+//
+// 全局 key（如 token、租户信息）需通过 Global 显式访问
+type Redis struct {
+	rds *redis.Redis
+}
+
+// NewRedis 创建租户隔离的 Redis
+func NewRedis(rds *redis.Redis) *Redis {
+	return &Redis{rds: rds}
+}
+
+// Global 返回不做租户隔离的 Redis, 仅用于全局 key
+func (r *Redis) Global() *redis.Redis {
+	return r.rds
+}
+
+// Key 返回当前租户下的完整 key
+func (r *Redis) Key(ctx context.Context, key string) (string, error) {
+	tenantId, ok := auth.LookupTenantId(ctx)
+	if !ok {
+		return "", ErrNoTenant
+	}
+	if strings.Contains(tenantId, ":") {
+		return "", ErrInvalidTenantId
+	}
+	return fmt.Sprintf(TENANT_SCOPE, tenantId) + key, nil
+}
+
+func (r *Redis) keys(ctx context.Context, keys []string) ([]string, error) {
+	res := make([]string, 0, len(keys))
+	for _, k := range keys {
+		key, err := r.Key(ctx, k)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, key)
+	}
+	return res, nil
+}
+
+func (r *Redis) Get(ctx context.Context, key string) (string, error) {
+	k, err := r.Key(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return r.rds.GetCtx(ctx, k)
+}
+
+func (r *Redis) Set(ctx context.Context, key, value string) error {
+	k, err := r.Key(ctx, key)
+	if err != nil {
+		return err
+	}
+	return r.rds.SetCtx(ctx, k, value)
+}
+
+func (r *Redis) Setex(ctx context.Context, key, value string, seconds int) error {
+	k, err := r.Key(ctx, key)
+	if err != nil {
+		return err
+	}
+	return r.rds.SetexCtx(ctx, k, value, seconds)
+}
+
+func (r *Redis) Del(ctx context.Context, keys ...string) (int, error) {
+	ks, err := r.keys(ctx, keys)
+	if err != nil {
+		return 0, err
+	}
+	return r.rds.DelCtx(ctx, ks...)
+}
+
+func (r *Redis) Exists(ctx context.Context, key string) (bool, error) {
+	k, err := r.Key(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	return r.rds.ExistsCtx(ctx, k)
+}
+
+func (r *Redis) Expire(ctx context.Context, key string, seconds int) error {
+	k, err := r.Key(ctx, key)
+	if err != nil {
+		return err
+	}
+	return r.rds.ExpireCtx(ctx, k, seconds)
+}
+
+func (r *Redis) Ttl(ctx context.Context, key string) (int, error) {
+	k, err := r.Key(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return r.rds.TtlCtx(ctx, k)
+}
+
+func (r *Redis) Incr(ctx context.Context, key string) (int64, error) {
+	k, err := r.Key(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return r.rds.IncrCtx(ctx, k)
+}
+
+func (r *Redis) Hget(ctx context.Context, key, field string) (string, error) {
+	k, err := r.Key(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return r.rds.HgetCtx(ctx, k, field)
+}
+
+func (r *Redis) Hset(ctx context.Context, key, field, value string) error {
+	k, err := r.Key(ctx, key)
+	if err != nil {
+		return err
+	}
+	return r.rds.HsetCtx(ctx, k, field, value)
+}
+
+func (r *Redis) Hmset(ctx context.Context, key string, fieldsAndValues map[string]string) error {
+	k, err := r.Key(ctx, key)
+	if err != nil {
+		return err
+	}
+	return r.rds.HmsetCtx(ctx, k, fieldsAndValues)
+}
+
+func (r *Redis) Hgetall(ctx context.Context, key string) (map[string]string, error) {
+	k, err := r.Key(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return r.rds.HgetallCtx(ctx, k)
+}
+
+func (r *Redis) Hdel(ctx context.Context, key string, fields ...string) (bool, error) {
+	k, err := r.Key(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	return r.rds.HdelCtx(ctx, k, fields...)
+}
+
+// Flush 清除指定租户下的全部 key, 返回删除数量
+func (r *Redis) Flush(ctx context.Context, tenantId string) (int64, error) {
+	if tenantId == "" {
+		return 0, ErrNoTenant
+	}
+	if strings.Contains(tenantId, ":") {
+		return 0, ErrInvalidTenantId
+	}
+	match := fmt.Sprintf(TENANT_SCOPE, globEscaper.Replace(tenantId)) + "*"
+	var (
+		cursor uint64
+		total  int64
+	)
+	for {
+		keys, next, err := r.rds.ScanCtx(ctx, cursor, match, flushBatch)
+		if err != nil {
+			return total, fmt.Errorf("scan tenant keys failed: %v", err)
+		}
+		if len(keys) > 0 {
+			n, err := r.rds.UnlinkCtx(ctx, keys...)
+			if err != nil {
+				return total, fmt.Errorf("delete tenant keys failed: %v", err)
+			}
+			total += n
+		}
+		if next == 0 {
+			return total, nil
+		}
+		cursor = next
+	}
+}
+
+// FlushCurrent 清除当前上下文租户下的全部 key
+func (r *Redis) FlushCurrent(ctx context.Context) (int64, error) {
+	tenantId, ok := auth.LookupTenantId(ctx)
+	if !ok {
+		return 0, ErrNoTenant
+	}
+	return r.Flush(ctx, tenantId)
+}
@@ -0,0 +1,68 @@
+package tenant
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedis(t *testing.T) {
+	mr, rds := newTestRedis(t)
+	r := NewRedis(rds)
+	withTenant := func(tenantId string) context.Context {
+		return context.WithValue(context.Background(), auth.TenantIDKey, tenantId)
+	}
+	ctx := withTenant("1")
+
+	require.NoError(t, r.Set(ctx, "k", "v"))
+	mr.CheckGet(t, "t:1:k", "v")
+	v, err := r.Get(ctx, "k")
+	require.NoError(t, err)
+	assert.Equal(t, "v", v)
+
+	require.NoError(t, r.Hset(ctx, "h", "f", "1"))
+	assert.Equal(t, "1", mr.HGet("t:1:h", "f"))
+
+	_, err = r.Get(context.Background(), "k")
+	assert.ErrorIs(t, err, ErrNoTenant)
+	// 包含分隔符的租户ID会与其他租户的 key 冲突
+	_, err = r.Get(withTenant("1:k"), "")
+	assert.ErrorIs(t, err, ErrInvalidTenantId)
+}
+
+func TestRedisFlush(t *testing.T) {
+	mr, rds := newTestRedis(t)
+	r := NewRedis(rds)
+	ctx := context.Background()
+	for _, k := range []string{"t:1:a", "t:1:b", "t:10:a", "t:2:a", "t:*:a", "t:[1]:a", "t:1", "tenant_info:1"} {
+		require.NoError(t, mr.Set(k, "v"))
+	}
+
+	n, err := r.Flush(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	assert.Equal(t, []string{"t:*:a", "t:1", "t:10:a", "t:2:a", "t:[1]:a", "tenant_info:1"}, mr.Keys())
+
+	// 通配符按字面匹配
+	n, err = r.Flush(ctx, "*")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	n, err = r.Flush(ctx, "[1]")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.Equal(t, []string{"t:1", "t:10:a", "t:2:a", "tenant_info:1"}, mr.Keys())
+
+	n, err = r.FlushCurrent(context.WithValue(ctx, auth.TenantIDKey, "2"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	_, err = r.Flush(ctx, "")
+	assert.ErrorIs(t, err, ErrNoTenant)
+	_, err = r.Flush(ctx, "1:")
+	assert.ErrorIs(t, err, ErrInvalidTenantId)
+	assert.Equal(t, []string{"t:1", "t:10:a", "tenant_info:1"}, mr.Keys())
+}
@@ -0,0 +1,196 @@
+package tenant
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/ovra-cloud/ovra-toolkit/errx"
+
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+// Info 租户信息
+type Info struct {
+	TenantId    string    `json:"tenantId"`
+	Status      string    `json:"status"`      // StatusNormal / StatusDisable
+	ExpireTime  time.Time `json:"expireTime"`  // 过期时间, 零值表示永不过期
+	UserLimit   int64     `json:"userLimit"`   // 用户数量上限, 小于等于 0 表示不限制
+	SeatLimit   int64     `json:"seatLimit"`   // 同时在线数量上限, 小于等于 0 表示不限制
+	PackageId   string    `json:"packageId"`   // 租户套餐, 为空表示不限制菜单与权限
+	MenuIds     []string  `json:"menuIds"`     // 套餐内的菜单
+	Permissions []string  `json:"permissions"` // 套餐内的权限标识
+}
+
+// Check 校验租户状态与有效期
+func (i *Info) Check(now time.Time) error {
+	if i.Status != StatusNormal {
+		return errx.New(errx.CodeTenantDisabled, "租户已停用")
+	}
+	if !i.ExpireTime.IsZero() && now.After(i.ExpireTime) {
+		return errx.New(errx.CodeTenantExpired, "租户已过期")
+	}
+	return nil
+}
+
+// CheckUserLimit 校验租户用户数, count 为新增后的用户总数, 创建用户前调用
+func (i *Info) CheckUserLimit(count int64) error {
+	if i.UserLimit > 0 && count > i.UserLimit {
+		return errx.Newf(errx.CodeTenantUserMax, "租户用户数已达上限 %d", i.UserLimit)
+	}
+	return nil
+}
+
+// FilterPermissions 将用户权限与租户套餐取交集, 拥有 AllPermission 的用户获得套餐内全部权限
+func (i *Info) FilterPermissions(perms []string) []string {
+	if i.PackageId == "" {
+		return perms
+	}
+	if slices.Contains(perms, AllPermission) {
+		return slices.Clone(i.Permissions)
+	}
+	res := make([]string, 0, len(perms))
+	for _, p := range perms {
+		if slices.Contains(i.Permissions, p) {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// Loader 租户信息加载器, 未找到租户时返回 nil, nil
+type Loader interface {
+	Load(ctx context.Context, tenantId string) (*Info, error)
+}
+
+// LoaderFunc 函数形式的 Loader
+type LoaderFunc func(ctx context.Context, tenantId string) (*Info, error)
+
+func (f LoaderFunc) Load(ctx context.Context, tenantId string) (*Info, error) {
+	return f(ctx, tenantId)
+}
+
+// notFoundPlaceholder 不存在的租户的缓存值
+const notFoundPlaceholder = "*"
+
+// seatScript 清理过期名额后占用名额, 已占用的用户只刷新活跃时间; 名额已满返回 0
+const seatScript = `redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+if not redis.call('ZSCORE', KEYS[1], ARGV[3]) and redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[4]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[3])
+redis.call('EXPIRE', KEYS[1], ARGV[5])
+return 1`
+
+// Registry 租户注册表, 通过 Loader 加载并缓存到 Redis
+type Registry struct {
+	Rds            *redis.Redis
+	Loader         Loader
+	Expire         time.Duration // 缓存时间, 为 0 时使用 DefaultInfoExpire
+	NotFoundExpire time.Duration // 不存在的租户的缓存时间, 为 0 时使用 DefaultNotFoundExpire
+	SeatWindow     time.Duration // 在线名额的活跃窗口, 为 0 时使用 DefaultSeatWindow
+}
+
+// NewRegistry 创建租户注册表
+func NewRegistry(rds *redis.Redis, loader Loader) *Registry {
+	return &Registry{Rds: rds, Loader: loader}
+}
+
+// Get 获取租户信息, 优先读取缓存
+func (r *Registry) Get(ctx context.Context, tenantId string) (*Info, error) {
+	key := fmt.Sprintf(TENANT_INFO_KEY, tenantId)
+	val, err := r.Rds.GetCtx(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("get tenant cache failed: %v", err)
+	}
+	if val == notFoundPlaceholder {
+		return nil, errx.New(errx.CodeTenantNotFound, "租户不存在")
+	}
+	if val != "" {
+		info := new(Info)
+		if err = json.Unmarshal([]byte(val), info); err == nil {
+			return info, nil
+		}
+	}
+
+	info, err := r.Loader.Load(ctx, tenantId)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		if err = r.Rds.SetexCtx(ctx, key, notFoundPlaceholder, int(durationOr(r.NotFoundExpire, DefaultNotFoundExpire).Seconds())); err != nil {
+			return nil, fmt.Errorf("set tenant cache failed: %v", err)
+		}
+		return nil, errx.New(errx.CodeTenantNotFound, "租户不存在")
+	}
+	b, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.Rds.SetexCtx(ctx, key, string(b), int(durationOr(r.Expire, DefaultInfoExpire).Seconds())); err != nil {
+		return nil, fmt.Errorf("set tenant cache failed: %v", err)
+	}
+	return info, nil
+}
+
+// Check 获取租户信息并校验状态与有效期
+func (r *Registry) Check(ctx context.Context, tenantId string) (*Info, error) {
+	info, err := r.Get(ctx, tenantId)
+	if err != nil {
+		return nil, err
+	}
+	if err = info.Check(time.Now()); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Invalidate 清除租户信息缓存, 租户或套餐变更后调用
+func (r *Registry) Invalidate(ctx context.Context, tenantIds ...string) error {
+	if len(tenantIds) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(tenantIds))
+	for _, id := range tenantIds {
+		keys = append(keys, fmt.Sprintf(TENANT_INFO_KEY, id))
+	}
+	if _, err := r.Rds.DelCtx(ctx, keys...); err != nil {
+		return fmt.Errorf("delete tenant cache failed: %v", err)
+	}
+	return nil
+}
+
+// Occupy 为用户占用租户在线名额, SeatLimit 小于等于 0 时不限制; 名额已满且用户未占用名额时返回错误
+func (r *Registry) Occupy(ctx context.Context, info *Info, userId string) error {
+	if info.SeatLimit <= 0 {
+		return nil
+	}
+	window := durationOr(r.SeatWindow, DefaultSeatWindow)
+	now := time.Now()
+	ok, err := r.Rds.EvalCtx(ctx, seatScript, []string{fmt.Sprintf(TENANT_SEAT_KEY, info.TenantId)},
+		now.Add(-window).UnixMilli(), now.UnixMilli(), userId, info.SeatLimit, int(window.Seconds()))
+	if err != nil {
+		return fmt.Errorf("occupy tenant seat failed: %v", err)
+	}
+	if n, _ := ok.(int64); n == 0 {
+		return errx.Newf(errx.CodeTenantSeatMax, "租户在线数已达上限 %d", info.SeatLimit)
+	}
+	return nil
+}
+
+// Release 释放用户占用的在线名额, 用户退出登录时调用
+func (r *Registry) Release(ctx context.Context, tenantId, userId string) error {
+	if _, err := r.Rds.ZremCtx(ctx, fmt.Sprintf(TENANT_SEAT_KEY, tenantId), userId); err != nil {
+		return fmt.Errorf("release tenant seat failed: %v", err)
+	}
+	return nil
+}
+
+func durationOr(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
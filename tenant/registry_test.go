@@ -0,0 +1,109 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ovra-cloud/ovra-toolkit/errx"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func errCode(t *testing.T, err error) int32 {
+	t.Helper()
+	var e *errx.Error
+	require.True(t, errors.As(err, &e), "unexpected error: %v", err)
+	return e.Code
+}
+
+func TestRegistry(t *testing.T) {
+	mr, rds := newTestRedis(t)
+	ctx := context.Background()
+
+	loads := map[string]int{}
+	r := NewRegistry(rds, LoaderFunc(func(_ context.Context, tenantId string) (*Info, error) {
+		loads[tenantId]++
+		switch tenantId {
+		case "1":
+			return &Info{TenantId: "1", Status: StatusNormal}, nil
+		case "2":
+			return &Info{TenantId: "2", Status: StatusDisable}, nil
+		case "3":
+			return &Info{TenantId: "3", Status: StatusNormal, ExpireTime: time.Now().Add(-time.Hour)}, nil
+		}
+		return nil, nil
+	}))
+
+	t.Run("缓存", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			info, err := r.Check(ctx, "1")
+			require.NoError(t, err)
+			assert.Equal(t, "1", info.TenantId)
+		}
+		assert.Equal(t, 1, loads["1"])
+		assert.Equal(t, DefaultInfoExpire, mr.TTL(fmt.Sprintf(TENANT_INFO_KEY, "1")))
+
+		require.NoError(t, r.Invalidate(ctx, "1"))
+		_, err := r.Get(ctx, "1")
+		require.NoError(t, err)
+		assert.Equal(t, 2, loads["1"])
+	})
+
+	t.Run("不存在的租户短时缓存", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			_, err := r.Get(ctx, "404")
+			assert.Equal(t, int32(errx.CodeTenantNotFound), errCode(t, err))
+		}
+		assert.Equal(t, 1, loads["404"])
+		assert.Equal(t, DefaultNotFoundExpire, mr.TTL(fmt.Sprintf(TENANT_INFO_KEY, "404")))
+
+		mr.FastForward(DefaultNotFoundExpire + time.Second)
+		_, err := r.Get(ctx, "404")
+		assert.Error(t, err)
+		assert.Equal(t, 2, loads["404"])
+	})
+
+	t.Run("状态与有效期", func(t *testing.T) {
+		_, err := r.Check(ctx, "2")
+		assert.Equal(t, int32(errx.CodeTenantDisabled), errCode(t, err))
+		_, err = r.Check(ctx, "3")
+		assert.Equal(t, int32(errx.CodeTenantExpired), errCode(t, err))
+	})
+}
+
+func TestInfoCheckUserLimit(t *testing.T) {
+	assert.NoError(t, (&Info{}).CheckUserLimit(100), "零值不限制")
+	assert.NoError(t, (&Info{UserLimit: -1}).CheckUserLimit(100))
+	assert.NoError(t, (&Info{UserLimit: 2}).CheckUserLimit(2))
+	assert.Equal(t, int32(errx.CodeTenantUserMax), errCode(t, (&Info{UserLimit: 2}).CheckUserLimit(3)))
+}
+
+func TestRegistryOccupy(t *testing.T) {
+	mr, rds := newTestRedis(t)
+	ctx := context.Background()
+	r := NewRegistry(rds, nil)
+	r.SeatWindow = time.Minute
+	info := &Info{TenantId: "1", SeatLimit: 2}
+
+	require.NoError(t, r.Occupy(ctx, info, "u1"))
+	require.NoError(t, r.Occupy(ctx, info, "u2"))
+	// 已占用名额的用户不受上限影响
+	require.NoError(t, r.Occupy(ctx, info, "u1"))
+	assert.Equal(t, int32(errx.CodeTenantSeatMax), errCode(t, r.Occupy(ctx, info, "u3")))
+	assert.NoError(t, r.Occupy(ctx, &Info{TenantId: "1", SeatLimit: -1}, "u3"))
+	assert.NoError(t, r.Occupy(ctx, &Info{TenantId: "1"}, "u3"), "零值不限制")
+
+	require.NoError(t, r.Release(ctx, "1", "u2"))
+	require.NoError(t, r.Occupy(ctx, info, "u3"))
+
+	// 超过活跃窗口的名额被回收
+	members, err := mr.ZMembers(fmt.Sprintf(TENANT_SEAT_KEY, "1"))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"u1", "u3"}, members)
+	mr.ZAdd(fmt.Sprintf(TENANT_SEAT_KEY, "1"), float64(time.Now().Add(-2*time.Minute).UnixMilli()), "u1")
+	assert.NoError(t, r.Occupy(ctx, info, "u4"))
+}
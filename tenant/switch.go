@@ -0,0 +1,145 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+	"github.com/ovra-cloud/ovra-toolkit/errx"
+
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+// SwitchPermission 校验用户是否允许切换到目标租户, 返回 error 时拒绝切换
+type SwitchPermission func(ctx context.Context, user *auth.UserInfo, tenantId string) error
+
+// SwitchEvent 租户切换审计事件
+type SwitchEvent struct {
+	Action     string    // ActionSwitch / ActionRestore
+	UserId     string    // 操作用户
+	FromTenant string    // 操作前生效的租户
+	ToTenant   string    // 操作后生效的租户
+	Time       time.Time // 操作时间
+}
+
+// SwitchAudit 审计事件接收者
+type SwitchAudit func(ctx context.Context, event SwitchEvent)
+
+// switchScript 原子写入切换记录与过期时间, 避免进程中断导致记录永不过期
+const switchScript = `redis.call('HSET', KEYS[1], '` + FieldOldTenant + `', ARGV[1], '` + FieldNewTenant + `', ARGV[2], '` + FieldSwitchTime + `', ARGV[3])
+return redis.call('EXPIRE', KEYS[1], ARGV[4])`
+
+// Switcher 租户切换, 状态保存在 Redis Hash tenant:{userId} 中
+type Switcher struct {
+	Rds        *redis.Redis
+	Expire     time.Duration    // 切换有效期, 为 0 时使用 DefaultSwitchExpire
+	Permission SwitchPermission // 权限校验, 为 nil 时拒绝所有切换
+	Audit      SwitchAudit      // 审计, 为 nil 时输出到 logx
+}
+
+// NewSwitcher 创建租户切换器
+func NewSwitcher(rds *redis.Redis, permission SwitchPermission) *Switcher {
+	return &Switcher{Rds: rds, Permission: permission}
+}
+
+// AllowRoles 仅允许拥有指定角色的用户切换租户
+func AllowRoles(roles ...string) SwitchPermission {
+	return func(_ context.Context, user *auth.UserInfo, _ string) error {
+		for _, r := range user.Roles {
+			if slices.Contains(roles, r) {
+				return nil
+			}
+		}
+		return errx.New(errx.CodeNoPerm, "无权切换租户")
+	}
+}
+
+// Switch 将用户切换到目标租户, 切换到原租户时等同于 Restore
+func (s *Switcher) Switch(ctx context.Context, user *auth.UserInfo, tenantId string) error {
+	if tenantId == "" {
+		return errx.New(errx.CodeInvalid, "租户ID不能为空")
+	}
+	if s.Permission == nil {
+		return errx.New(errx.CodeNoPerm, "无权切换租户")
+	}
+	if err := s.Permission(ctx, user, tenantId); err != nil {
+		return err
+	}
+	if tenantId == user.TenantId {
+		return s.Restore(ctx, user)
+	}
+
+	from, err := s.Current(ctx, user)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if _, err = s.Rds.EvalCtx(ctx, switchScript, []string{fmt.Sprintf(TENANT_KEY, user.UserId)},
+		user.TenantId, tenantId, strconv.FormatInt(now.Unix(), 10), int(s.expire().Seconds())); err != nil {
+		return fmt.Errorf("set tenant switch failed: %v", err)
+	}
+	s.audit(ctx, SwitchEvent{
+		Action:     ActionSwitch,
+		UserId:     user.UserId,
+		FromTenant: from,
+		ToTenant:   tenantId,
+		Time:       now,
+	})
+	return nil
+}
+
+// Restore 恢复用户的原始租户, 始终删除切换记录, 仅在租户发生变化时审计
+func (s *Switcher) Restore(ctx context.Context, user *auth.UserInfo) error {
+	from, err := s.Current(ctx, user)
+	if err != nil {
+		return err
+	}
+	if _, err = s.Rds.DelCtx(ctx, fmt.Sprintf(TENANT_KEY, user.UserId)); err != nil {
+		return fmt.Errorf("restore tenant failed: %v", err)
+	}
+	if from == user.TenantId {
+		return nil
+	}
+	s.audit(ctx, SwitchEvent{
+		Action:     ActionRestore,
+		UserId:     user.UserId,
+		FromTenant: from,
+		ToTenant:   user.TenantId,
+		Time:       time.Now(),
+	})
+	return nil
+}
+
+// Current 获取用户当前生效的租户, 未切换或切换已过期时返回原始租户
+func (s *Switcher) Current(ctx context.Context, user *auth.UserInfo) (string, error) {
+	return GetTenantId(ctx, s.Rds, user)
+}
+
+// IsSwitched 判断用户当前是否处于切换租户状态
+func (s *Switcher) IsSwitched(ctx context.Context, user *auth.UserInfo) (bool, error) {
+	current, err := s.Current(ctx, user)
+	if err != nil {
+		return false, err
+	}
+	return current != user.TenantId, nil
+}
+
+func (s *Switcher) expire() time.Duration {
+	if s.Expire <= 0 {
+		return DefaultSwitchExpire
+	}
+	return s.Expire
+}
+
+func (s *Switcher) audit(ctx context.Context, event SwitchEvent) {
+	if s.Audit != nil {
+		s.Audit(ctx, event)
+		return
+	}
+	logx.WithContext(ctx).Infof("tenant %s: user=%s from=%s to=%s",
+		event.Action, event.UserId, event.FromTenant, event.ToTenant)
+}
@@ -0,0 +1,95 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ovra-cloud/ovra-toolkit/auth"
+	"github.com/ovra-cloud/ovra-toolkit/errx"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Redis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	return mr, redis.MustNewRedis(redis.RedisConf{Host: mr.Addr(), Type: redis.NodeType})
+}
+
+func TestAllowRoles(t *testing.T) {
+	permission := AllowRoles("superadmin")
+	assert.NoError(t, permission(context.Background(), &auth.UserInfo{Roles: []string{"common", "superadmin"}}, "2"))
+
+	var e *errx.Error
+	err := permission(context.Background(), &auth.UserInfo{Roles: []string{"common"}}, "2")
+	require.True(t, errors.As(err, &e))
+	assert.Equal(t, int32(errx.CodeNoPerm), e.Code)
+}
+
+func TestSwitcher(t *testing.T) {
+	mr, rds := newTestRedis(t)
+	ctx := context.Background()
+	user := &auth.UserInfo{UserId: "9", TenantId: "1", Roles: []string{"superadmin"}}
+	key := fmt.Sprintf(TENANT_KEY, user.UserId)
+
+	var events []SwitchEvent
+	s := NewSwitcher(rds, AllowRoles("superadmin"))
+	s.Expire = time.Minute
+	s.Audit = func(_ context.Context, e SwitchEvent) { events = append(events, e) }
+
+	current, err := s.Current(ctx, user)
+	require.NoError(t, err)
+	assert.Equal(t, "1", current)
+
+	t.Run("切换", func(t *testing.T) {
+		require.NoError(t, s.Switch(ctx, user, "2"))
+		current, err := s.Current(ctx, user)
+		require.NoError(t, err)
+		assert.Equal(t, "2", current)
+		assert.Equal(t, "1", mr.HGet(key, FieldOldTenant))
+		assert.NotEmpty(t, mr.HGet(key, FieldSwitchTime))
+		assert.Equal(t, time.Minute, mr.TTL(key))
+		require.Len(t, events, 1)
+		assert.Equal(t, SwitchEvent{Action: ActionSwitch, UserId: "9", FromTenant: "1", ToTenant: "2", Time: events[0].Time}, events[0])
+
+		switched, err := s.IsSwitched(ctx, user)
+		require.NoError(t, err)
+		assert.True(t, switched)
+	})
+
+	t.Run("过期后恢复原租户", func(t *testing.T) {
+		mr.FastForward(time.Minute + time.Second)
+		current, err := s.Current(ctx, user)
+		require.NoError(t, err)
+		assert.Equal(t, "1", current)
+	})
+
+	t.Run("恢复", func(t *testing.T) {
+		events = nil
+		require.NoError(t, s.Switch(ctx, user, "3"))
+		require.NoError(t, s.Restore(ctx, user))
+		assert.False(t, mr.Exists(key))
+		require.Len(t, events, 2)
+		assert.Equal(t, ActionRestore, events[1].Action)
+		assert.Equal(t, "3", events[1].FromTenant)
+
+		// 记录中的租户与原租户相同时同样删除, 不产生审计
+		mr.HSet(key, FieldOldTenant, "1", FieldNewTenant, "1")
+		require.NoError(t, s.Switch(ctx, user, "1"))
+		assert.False(t, mr.Exists(key))
+		assert.Len(t, events, 2)
+	})
+
+	t.Run("拒绝切换", func(t *testing.T) {
+		assert.Error(t, s.Switch(ctx, user, ""))
+		assert.Error(t, s.Switch(ctx, &auth.UserInfo{UserId: "8", TenantId: "1"}, "2"))
+		assert.Error(t, NewSwitcher(rds, nil).Switch(ctx, user, "2"))
+		assert.False(t, mr.Exists(fmt.Sprintf(TENANT_KEY, "8")))
+	})
+}
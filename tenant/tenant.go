@@ -20,29 +20,32 @@ func GetTenantId(ctx context.Context, rds *redis.Redis, user *auth.UserInfo) (st
 	if !ex {
 		return user.TenantId, nil
 	}
-	val, err := rds.HgetCtx(ctx, key, "nt")
+	val, err := rds.HgetCtx(ctx, key, FieldNewTenant)
 	if err != nil {
 		return "", err
 	}
 	return val, nil
 }
 
+// SetTenantId 直接写入切换租户, 不做权限校验
+//
+// Deprecated: 使用 Switcher.Switch, 其包含权限校验、过期与审计
 func SetTenantId(ctx context.Context, rds *redis.Redis, userId, tenantId string) error {
 	key := fmt.Sprintf(TENANT_KEY, userId)
-	ot := auth.GetTenantId(ctx)
+	ot, _ := auth.LookupTenantId(ctx)
 	ex, err := rds.ExistsCtx(ctx, key)
 	if err != nil {
 		return err
 	}
 	if !ex {
-		err = rds.HsetCtx(ctx, key, "ot", ot)
+		err = rds.HsetCtx(ctx, key, FieldOldTenant, ot)
 		if err != nil {
 			return err
 		}
 	}
-	err = rds.HsetCtx(ctx, key, "nt", tenantId)
+	err = rds.HsetCtx(ctx, key, FieldNewTenant, tenantId)
 	if err != nil {
 		return err
 	}
-	return nil
+	return rds.ExpireCtx(ctx, key, int(DefaultSwitchExpire.Seconds()))
 }
@@ -89,7 +89,7 @@ func StructToMapOmit[T any](input T, includeFields []string, excludeFields []str
 			result[fieldName] = value.Interface()
 			continue
 		}
-		if omitEmpty && isEmptyValue(value) {
+		if omitEmpty && IsEmptyValue(value) {
 			continue
 		}
 		result[fieldName] = value.Interface()
@@ -97,7 +97,8 @@ func StructToMapOmit[T any](input T, includeFields []string, excludeFields []str
 	return result
 }
 
-func isEmptyValue(v reflect.Value) bool {
+// IsEmptyValue 判断是否为空值: nil、空字符串/切片/map、数值 0、零时间或 MySQL 零时间
+func IsEmptyValue(v reflect.Value) bool {
 	if !v.IsValid() {
 		return true
 	}
@@ -106,7 +107,7 @@ func isEmptyValue(v reflect.Value) bool {
 		if v.IsNil() {
 			return true
 		}
-		return isEmptyValue(v.Elem())
+		return IsEmptyValue(v.Elem())
 
 	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
 		return v.Len() == 0
@@ -21,6 +21,11 @@ func Init(startTime string, machineID int64) (err error) {
 	return
 }
 
+// Initialized 是否已调用 Init 初始化节点
+func Initialized() bool {
+	return node != nil
+}
+
 // GetID 生成ID
 func GetID() string {
 	return strconv.FormatInt(node.Generate().Int64(), 10)